@@ -1,214 +1,185 @@
 package baxtep
 
 import (
+	"context"
 	"database/sql"
-	"fmt"
+	"time"
 )
 
 type Baxtep struct {
-	db database
+	store         Store
+	hasher        PasswordHasher
+	tokens        *TokenGenerator
+	tokenDuration time.Duration
+	magicDuration time.Duration
+	maxFailures   int
+	lockDuration  time.Duration
+	secretKey     []byte
 }
 
-type database struct {
-	conn   *sql.DB
-	driver string
-	prefix string
-}
-
-func NewBaxtep(db *sql.DB, dbType, prefix string) *Baxtep {
+func NewBaxtep(store Store) *Baxtep {
 	return &Baxtep{
-		db: database{
-			conn:   db,
-			driver: dbType,
-			prefix: prefix,
-		},
+		store:         store,
+		hasher:        NewArgon2idHasher(),
+		tokens:        NewTokenGenerator(),
+		tokenDuration: time.Hour,
+		magicDuration: 15 * time.Minute,
+		maxFailures:   10,
+		lockDuration:  15 * time.Minute,
 	}
 }
 
-var dbVersion = 1
+// SetPasswordHasher replaces the default argon2id hasher. Existing hashes
+// are upgraded to the new hasher on the next successful login.
+func (b *Baxtep) SetPasswordHasher(h PasswordHasher) {
+	b.hasher = h
+}
+
+// SetTokenGenerator sets the length and alphabet of session IDs,
+// confirmation codes and generated passwords.
+func (b *Baxtep) SetTokenGenerator(g *TokenGenerator) {
+	b.tokens = g
+}
 
+// InitDB creates or upgrades the database schema.
 func (b *Baxtep) InitDB() error {
-	//_ = b.db.conn.QueryRow("SELECT Value FROM "+b.db.prefix+"_param WHERE Name='db_version'").Scan(&version)
-	//if version == "" {
-	//	version = "0"
-	//}
-	tx, err := b.db.conn.Begin()
-	if err != nil {
-		return err
-	}
-	defer tx.Rollback()
-	switch b.db.driver {
-	case "mysql", "tidb":
-		var query = []string{
-			"SET SQL_MODE = \"NO_AUTO_VALUE_ON_ZERO\";",
-			"SET time_zone = \"+00:00\";",
-			fmt.Sprintf(
-				"CREATE TABLE IF NOT EXISTS `%s` ("+
-				" `id` int(11) NOT NULL AUTO_INCREMENT,"+
-				" `name` varchar(100) NOT NULL,"+
-				" `password` varchar(64) NOT NULL,"+
-				" `email` varchar(100) NOT NULL,"+
-				" `registration_time` timestamp NOT NULL DEFAULT '0000-00-00 00:00:00',"+
-				" `enable` tinyint(1) NOT NULL,"+
-				" `session_id` varchar(64) NOT NULL,"+
-				" `session_time` timestamp NOT NULL DEFAULT '0000-00-00 00:00:00',"+
-				" PRIMARY KEY (id)) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;",
-				b.db.prefix),
-			fmt.Sprintf("CREATE TABLE IF NOT EXISTS `%s_param` ("+
-				" `id` int(11) NOT NULL AUTO_INCREMENT,"+
-				" `user_id` int(11) NOT NULL,"+
-				" `key` varchar(100) NOT NULL,"+
-				" `val` varchar(250) NOT NULL,"+
-				" PRIMARY KEY (id)) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;",
-				b.db.prefix),
-		}
-		for i := range query {
-			_, err = tx.Exec(query[i])
-			if err != nil {
-				return err
-			}
-		}
-	case "ql", "ql-mem":
-		var query = []string{
-			fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (" +
-				" name string," +
-				" password string," +
-				" email string," +
-				" registration_time time," +
-				" enable bool," +
-				" session_id string," +
-				" session_time time" +
-				");",
-				b.db.prefix),
-			fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s_param (" +
-				" user_id int," +
-				" key string," +
-				" val string" +
-				");",
-				b.db.prefix),
-		}
-		for i := range query {
-			_, err = tx.Exec(query[i])
-			if err != nil {
-				return err
-			}
-		}
-		err = tx.Commit()
-	default:
-		err = fmt.Errorf("Database type '%s' not supported", b.db.driver)
-	}
+	return b.Migrate(context.Background())
+}
+
+// Migrate applies pending schema migrations, each version in its own transaction.
+func (b *Baxtep) Migrate(ctx context.Context) error {
+	return b.store.Migrate(ctx)
+}
 
-	return err
+// SchemaVersion returns the applied schema version, 0 for an empty database.
+func (b *Baxtep) SchemaVersion() (int, error) {
+	return b.store.SchemaVersion()
 }
 
+func (b *Baxtep) newUser(d UserData) User {
+	return User{b: b, id: d.ID, Name: d.Name, Email: d.Email, Enable: d.Enable}
+}
+
+// AddNewUser adds a disabled user without password and returns it with
+// the code for ConfirmRegistration.
 func (b *Baxtep) AddNewUser(name, email string) (User, string, error) {
-	u := User{db: b.db, Name: name, Email: email, Enable: false}
-	err := b.CheckExistUserName(name)
+	return b.addUser(name, email, "")
+}
+
+// RegisterUser is AddNewUser storing the password hash with the user.
+func (b *Baxtep) RegisterUser(name, email, password string) (User, string, error) {
+	passhash, err := b.hasher.Hash(password)
 	if err != nil {
 		return User{}, "", err
 	}
-	err = b.CheckExistUserEmail(email)
+	return b.addUser(name, email, passhash)
+}
+
+func (b *Baxtep) addUser(name, email, passhash string) (User, string, error) {
+	u := User{b: b, Name: name, Email: email, Enable: false}
+	err := b.CheckExistUserName(name)
 	if err != nil {
 		return User{}, "", err
 	}
-	confirm := generateRandomString(32)
-	tx, err := b.db.conn.Begin()
+	err = b.CheckExistUserEmail(email)
 	if err != nil {
-		return User{}, "", nil
-	}
-	defer tx.Rollback()
-	var res sql.Result
-	switch b.db.driver {
-	case "mysql":
-		res, err = tx.Exec("INSERT INTO `"+b.db.prefix+"`(`name`, `email`, `session_id`, `enable`) VALUES (?, ?, ?, FALSE)", u.Name, u.Email, confirm)
-	case "ql", "ql-mem":
-		res, err = tx.Exec("INSERT INTO "+b.db.prefix+"(name, email, session_id, enable) VALUES ($1, $2, $3, false)", u.Name, u.Email, confirm)
+		return User{}, "", err
 	}
+	confirm, err := b.tokens.confirmCode()
 	if err != nil {
 		return User{}, "", err
 	}
-	u.id, err = res.LastInsertId()
+	// like session IDs the code is kept hashed
+	u.id, err = b.store.AddUser(u.Name, u.Email, passhash, hashToken(confirm), time.Now().UTC())
 	if err != nil {
 		return User{}, "", err
 	}
-	err = tx.Commit()
-	return u, confirm, err
+	return u, confirm, nil
 }
 
 func (b *Baxtep) ConfirmRegistration(str string) (User, error) {
-	user, err := b.GetUserBySessionID(str)
-	if err == ErrUserSessionNotFound {
-		return user, ErrUserNotFound
+	user, err := b.checkConfirm(str)
+	if err != nil {
+		return user, err
+	}
+	err = b.store.SetUserConfirm(user.id, "")
+	if err != nil {
+		return user, err
 	}
 	err = user.SetEnable()
 	return user, err
 }
 
-func (b *Baxtep) GetUserByEmail(email string) (User, error) {
-	var row *sql.Row
-	u := User{db: b.db, Email: email}
-	switch b.db.driver {
-	case "mysql":
-		row = b.db.conn.QueryRow("SELECT `id`, `name`, `enable` FROM `"+b.db.prefix+"` WHERE `email`=?", u.Email)
-	case "ql", "ql-mem":
-		row = b.db.conn.QueryRow("SELECT id(), name, enable FROM "+b.db.prefix+" WHERE email=$1", u.Email)
+// checkConfirm returns the user of a confirmation code without using it up.
+func (b *Baxtep) checkConfirm(str string) (User, error) {
+	if str == "" {
+		return User{b: b}, ErrUserNotFound
 	}
-	err := row.Scan(&u.id, &u.Name, &u.Enable)
+	d, err := b.store.GetUserByConfirm(hashToken(str))
 	if err == sql.ErrNoRows {
-		return u, ErrUserWithEmailNotFound
+		return User{b: b}, ErrUserNotFound
 	}
-	return u, err
+	if err != nil {
+		return User{b: b}, err
+	}
+	return b.newUser(d), nil
 }
 
-func (b *Baxtep) GetUserByName(name string) (User, error) {
-	u := User{db: b.db, Name: name}
-	var row *sql.Row
-	switch b.db.driver {
-	case "mysql":
-		row = b.db.conn.QueryRow("SELECT `id`, `email`, `enable` FROM `"+b.db.prefix+"` WHERE `name`=?", u.Name)
-	case "ql", "ql-mem":
-		row = b.db.conn.QueryRow("SELECT id(), email, enable FROM "+b.db.prefix+" WHERE name=$1", u.Name)
+func (b *Baxtep) GetUserByEmail(email string) (User, error) {
+	d, err := b.store.GetUserByEmail(email)
+	if err == sql.ErrNoRows {
+		return User{b: b, Email: email}, ErrUserWithEmailNotFound
 	}
-	err := row.Scan(&u.id, &u.Email, &u.Enable)
+	return b.newUser(d), err
+}
+
+func (b *Baxtep) GetUserByName(name string) (User, error) {
+	d, err := b.store.GetUserByName(name)
 	if err == sql.ErrNoRows {
-		err = ErrUserWithNameNotFound
+		return User{b: b, Name: name}, ErrUserWithNameNotFound
 	}
-	return u, err
+	return b.newUser(d), err
 }
 
 func (b *Baxtep) GetUserByID(id int64) (User, error) {
-	u := User{db: b.db, id: id}
-	var row *sql.Row
-	switch b.db.driver {
-	case "mysql":
-		row = b.db.conn.QueryRow("SELECT `name`, `email`, `enable` FROM `"+b.db.prefix+"` WHERE `id`=?", u.id)
-	case "ql", "ql-mem":
-		row = b.db.conn.QueryRow("SELECT name, email, enable FROM "+b.db.prefix+" WHERE id()=?", u.id)
-	}
-	err := row.Scan(&u.Name, &u.Email, &u.Enable)
+	d, err := b.store.GetUserByID(id)
 	if err == sql.ErrNoRows {
-		err = ErrUserWithIDNotFound
+		return User{b: b, id: id}, ErrUserWithIDNotFound
 	}
-	return u, err
+	return b.newUser(d), err
 }
 
+// GetUserBySessionID returns the user logged in with the session cookie value.
 func (b *Baxtep) GetUserBySessionID(sessionID string) (User, error) {
-	u := User{db: b.db}
-	var row *sql.Row
-	switch b.db.driver {
-	case "mysql":
-		row = b.db.conn.QueryRow("SELECT `id`, `name`, `email`, `enable` FROM `"+b.db.prefix+"` WHERE `session_id`=?", sessionID)
-	case "ql", "ql-mem":
-		row = b.db.conn.QueryRow("SELECT id(), name, email, enable FROM "+b.db.prefix+" WHERE session_id=$1", sessionID)
+	session, err := b.store.GetSession(hashToken(sessionID))
+	if err == sql.ErrNoRows {
+		return User{b: b}, ErrUserSessionNotFound
 	}
-
-	err := row.Scan(&u.id, &u.Name, &u.Email, &u.Enable)
+	if err != nil {
+		return User{b: b}, err
+	}
+	d, err := b.store.GetUserByID(session.UserID)
 	if err == sql.ErrNoRows {
-		err = ErrUserSessionNotFound
+		return User{b: b}, ErrUserSessionNotFound
 	}
+	u := b.newUser(d)
+	u.session = session.ID
+	u.org = session.OrgID
 	return u, err
 }
 
+// Logout revokes the session with the cookie value sessionID.
+func (b *Baxtep) Logout(sessionID string) error {
+	u, err := b.GetUserBySessionID(sessionID)
+	if err == ErrUserSessionNotFound {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return u.RevokeSession(u.session)
+}
+
 func (b *Baxtep) GetUserByEmailPassword(email, password string) (User, error) {
 	u, err := b.GetUserByEmail(email)
 	if err != nil {
@@ -228,15 +199,7 @@ func (b *Baxtep) GetUserByUsernamePassword(name, password string) (User, error)
 }
 
 func (b *Baxtep) CheckExistUserName(username string) error {
-	var count int64
-	var row *sql.Row
-	switch b.db.driver {
-	case "mysql":
-		row = b.db.conn.QueryRow("SELECT COUNT(*) FROM "+b.db.prefix+" WHERE name=?", username)
-	case "ql", "ql-mem":
-		row = b.db.conn.QueryRow("SELECT count(*) FROM "+b.db.prefix+" WHERE name=$1", username)
-	}
-	err := row.Scan(&count)
+	count, err := b.store.CountUserName(username)
 	if err != nil {
 		return err
 	}
@@ -247,21 +210,9 @@ func (b *Baxtep) CheckExistUserName(username string) error {
 }
 
 func (b *Baxtep) CheckExistUserEmail(email string) error {
-	return checkExistUserEmail(b.db, email)
+	return checkExistUserEmail(b.store, email)
 }
 
 func (b *Baxtep) DeleteUser(id int64) error {
-	tx, err := b.db.conn.Begin()
-	if err != nil {
-		return nil
-	}
-	defer tx.Rollback()
-	switch b.db.driver {
-	case "mysql":
-		_, err = b.db.conn.Exec("DELETE FROM `"+b.db.prefix+"` WHERE `id`=?", id)
-	case "ql", "ql-mem":
-		_, err = b.db.conn.Exec("DELETE FROM "+b.db.prefix+" WHERE id()=$1", id)
-	}
-	tx.Commit()
-	return err
+	return b.store.DeleteUser(id)
 }
@@ -0,0 +1,36 @@
+package baxtep
+
+import (
+	"database/sql"
+	"testing"
+)
+
+func TestConfirmRegistration(t *testing.T) {
+	b := newTestBaxtep(t)
+	u, confirm, err := b.RegisterUser("user", "user@example.com", "password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = b.store.GetUserByConfirm(confirm); err != sql.ErrNoRows {
+		t.Errorf("the code is stored in plaintext: %v", err)
+	}
+	if _, err = b.ConfirmRegistration(confirm + "x"); err != ErrUserNotFound {
+		t.Errorf("wrong code: %v", err)
+	}
+	if _, err = b.ConfirmRegistration(""); err != ErrUserNotFound {
+		t.Errorf("empty code: %v", err)
+	}
+	if _, err = b.checkConfirm(confirm); err != nil {
+		t.Fatal(err)
+	}
+	confirmed, err := b.ConfirmRegistration(confirm)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if confirmed.GetID() != u.GetID() || !confirmed.Enable {
+		t.Errorf("confirmed user %+v", confirmed)
+	}
+	if _, err = b.ConfirmRegistration(confirm); err != ErrUserNotFound {
+		t.Errorf("second confirmation: %v", err)
+	}
+}
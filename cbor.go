@@ -0,0 +1,121 @@
+package baxtep
+
+import (
+	"encoding/binary"
+	"errors"
+	"math"
+)
+
+// Just enough CBOR (RFC 7049) to read WebAuthn attestation objects and
+// COSE keys: definite lengths only, as CTAP2 canonical encoding has.
+// Integers decode to int64, byte strings to []byte, text to string,
+// arrays to []interface{} and maps to map[interface{}]interface{}.
+
+var errCBOR = errors.New("malformed CBOR")
+
+const cborMaxDepth = 16
+
+// cborDecode decodes the first item of data and returns the bytes after it.
+func cborDecode(data []byte) (interface{}, []byte, error) {
+	return cborItem(data, 0)
+}
+
+func cborItem(data []byte, depth int) (interface{}, []byte, error) {
+	if len(data) == 0 || depth > cborMaxDepth {
+		return nil, nil, errCBOR
+	}
+	major, info := data[0]>>5, data[0]&0x1f
+	data = data[1:]
+	var arg uint64
+	switch {
+	case info < 24:
+		arg = uint64(info)
+	case info == 24 && len(data) >= 1:
+		arg, data = uint64(data[0]), data[1:]
+	case info == 25 && len(data) >= 2:
+		arg, data = uint64(binary.BigEndian.Uint16(data)), data[2:]
+	case info == 26 && len(data) >= 4:
+		arg, data = uint64(binary.BigEndian.Uint32(data)), data[4:]
+	case info == 27 && len(data) >= 8:
+		arg, data = binary.BigEndian.Uint64(data), data[8:]
+	default:
+		return nil, nil, errCBOR
+	}
+
+	switch major {
+	case 0:
+		if arg > math.MaxInt64 {
+			return nil, nil, errCBOR
+		}
+		return int64(arg), data, nil
+	case 1:
+		if arg > math.MaxInt64 {
+			return nil, nil, errCBOR
+		}
+		return -1 - int64(arg), data, nil
+	case 2, 3:
+		if arg > uint64(len(data)) {
+			return nil, nil, errCBOR
+		}
+		if major == 2 {
+			return append([]byte(nil), data[:arg]...), data[arg:], nil
+		}
+		return string(data[:arg]), data[arg:], nil
+	case 4:
+		if arg > uint64(len(data)) {
+			return nil, nil, errCBOR
+		}
+		items := make([]interface{}, 0, arg)
+		for i := uint64(0); i < arg; i++ {
+			var item interface{}
+			var err error
+			item, data, err = cborItem(data, depth+1)
+			if err != nil {
+				return nil, nil, err
+			}
+			items = append(items, item)
+		}
+		return items, data, nil
+	case 5:
+		if arg > uint64(len(data)) {
+			return nil, nil, errCBOR
+		}
+		items := make(map[interface{}]interface{}, arg)
+		for i := uint64(0); i < arg; i++ {
+			var key, value interface{}
+			var err error
+			key, data, err = cborItem(data, depth+1)
+			if err != nil {
+				return nil, nil, err
+			}
+			switch key.(type) {
+			case int64, string:
+			default:
+				return nil, nil, errCBOR
+			}
+			value, data, err = cborItem(data, depth+1)
+			if err != nil {
+				return nil, nil, err
+			}
+			items[key] = value
+		}
+		return items, data, nil
+	case 6:
+		// tags are not used by WebAuthn, take the tagged item as is
+		return cborItem(data, depth+1)
+	default:
+		switch info {
+		case 20:
+			return false, data, nil
+		case 21:
+			return true, data, nil
+		case 22, 23:
+			return nil, data, nil
+		case 26:
+			return float64(math.Float32frombits(uint32(arg))), data, nil
+		case 27:
+			return math.Float64frombits(arg), data, nil
+		}
+		return nil, nil, errCBOR
+	}
+}
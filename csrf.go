@@ -0,0 +1,59 @@
+package baxtep
+
+import (
+	"crypto/subtle"
+	"net/http"
+)
+
+// Built-in CSRF protection is a double-submit cookie: forms send the
+// cookie value back in the csrfField, JavaScript in the csrfHeader.
+const (
+	csrfCookie = "csrf_token"
+	csrfField  = "csrf_token"
+	csrfHeader = "X-CSRF-Token"
+)
+
+// CSRFToken returns the token for a form field named "csrf_token" or the
+// X-CSRF-Token header, templates get it as ._CSRF. With HandlerConfig.CSRF
+// set it is the token of the external middleware.
+func (uh *Handler) CSRFToken(w http.ResponseWriter, r *http.Request) string {
+	if uh.Config.CSRF != nil {
+		return uh.Config.CSRF(r)
+	}
+	if c, err := r.Cookie(csrfCookie); err == nil && c.Value != "" {
+		return c.Value
+	}
+	token, err := uh.Config.Baxter.tokens.confirmCode()
+	if err != nil {
+		uh.logPrintf("CSRF token error: %s", err)
+		return ""
+	}
+	http.SetCookie(w, &http.Cookie{
+		Path:     "/",
+		Name:     csrfCookie,
+		Value:    token,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+	// later CSRFToken calls for this request get the same token
+	r.AddCookie(&http.Cookie{Name: csrfCookie, Value: token})
+	return token
+}
+
+// checkCSRF verifies the token of a state-changing request, it is left
+// to the external middleware when HandlerConfig.CSRF is set.
+func (uh *Handler) checkCSRF(r *http.Request) bool {
+	if uh.Config.CSRF != nil {
+		return true
+	}
+	c, err := r.Cookie(csrfCookie)
+	if err != nil || c.Value == "" {
+		return false
+	}
+	token := r.Header.Get(csrfHeader)
+	if token == "" {
+		// not from the query, URLs end up in logs and Referer headers
+		token = r.PostFormValue(csrfField)
+	}
+	return subtle.ConstantTimeCompare([]byte(token), []byte(c.Value)) == 1
+}
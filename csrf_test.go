@@ -0,0 +1,65 @@
+package baxtep
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestCSRF(t *testing.T) {
+	s := newTestServer(t, nil)
+	addTestUser(t, s.b, "user", "user@example.com")
+	form := url.Values{"email": {"user@example.com"}, "password": {"password"}}
+	token := s.csrf()
+
+	postForm := func(path string, form url.Values, header string) *http.Response {
+		req, _ := http.NewRequest("POST", s.url(path), strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		if header != "" {
+			req.Header.Set(csrfHeader, header)
+		}
+		resp, _ := s.do(req)
+		return resp
+	}
+	for name, resp := range map[string]*http.Response{
+		"no token":       postForm("/user?login", form, ""),
+		"wrong token":    postForm("/user?login", url.Values{"email": form["email"], "password": form["password"], csrfField: {"wrong"}}, ""),
+		"wrong header":   postForm("/user?login", form, "wrong"),
+		"token in query": postForm("/user?login&"+csrfField+"="+url.QueryEscape(token), form, ""),
+	} {
+		if resp.StatusCode != http.StatusForbidden {
+			t.Errorf("login with %s: %d", name, resp.StatusCode)
+		}
+	}
+	if resp := postForm("/user?login", form, token); resp.StatusCode != http.StatusFound {
+		t.Errorf("login with the header: %d", resp.StatusCode)
+	}
+
+	// a link or an image must not log the user out
+	if resp, _ := s.get("/user?logout&" + csrfField + "=" + url.QueryEscape(token)); resp.StatusCode != http.StatusMethodNotAllowed {
+		t.Errorf("GET of logout: %d", resp.StatusCode)
+	}
+	if resp, body := s.get("/user?base"); !strings.Contains(body, "user@example.com") {
+		t.Errorf("GET of logout logged the user out: %d %s", resp.StatusCode, body)
+	}
+	if resp, _ := s.post("/user?logout", url.Values{csrfField: {"wrong"}}); resp.StatusCode != http.StatusForbidden {
+		t.Errorf("logout with a wrong token: %d", resp.StatusCode)
+	}
+	if resp, _ := s.post("/user?logout", nil); resp.StatusCode != http.StatusFound {
+		t.Errorf("logout: %d", resp.StatusCode)
+	}
+}
+
+func TestCSRFExternal(t *testing.T) {
+	s := newTestServer(t, &HandlerConfig{CSRF: func(r *http.Request) string { return "external" }})
+	addTestUser(t, s.b, "user", "user@example.com")
+	resp, _ := s.post("/user?login", url.Values{
+		"email":    {"user@example.com"},
+		"password": {"password"},
+		csrfField:  {"external"},
+	})
+	if resp.StatusCode != http.StatusFound {
+		t.Errorf("login with the external middleware: %d", resp.StatusCode)
+	}
+}
@@ -0,0 +1,140 @@
+package baxtep
+
+import (
+	"net/http"
+)
+
+const tokenEmail = "email"
+
+// RequestEmailChange returns a token for Baxtep.ConfirmEmailChange, the
+// email of the user changes only when the new address is confirmed.
+func (u *User) RequestEmailChange(email string) (string, error) {
+	err := checkExistUserEmail(u.b.store, email)
+	if err != nil {
+		return "", err
+	}
+	return u.b.newToken(u.id, tokenEmail, email, u.b.tokenDuration)
+}
+
+func (b *Baxtep) ConfirmEmailChange(token string) (User, error) {
+	u, t, err := b.takeToken(token, tokenEmail)
+	if err != nil {
+		return u, err
+	}
+	err = u.SetNewEmail(t.Data)
+	return u, err
+}
+
+func (uh *Handler) email(w http.ResponseWriter, r *http.Request) {
+	// has confirmation link?
+	if len(r.URL.Query()["email"]) > 0 && r.URL.Query()["email"][0] != "" {
+		uh.emailConfirmation(w, r)
+		return
+	}
+	userdata, v := uh.getFormData(w, r, "email")
+	user, ok := userdata["_User"].(User)
+	if !ok {
+		uh.redirect(w, r, "login")
+		return
+	}
+	status := http.StatusOK
+	if r.Method == "POST" {
+		email := r.FormValue("email")
+		v.required(r, "email")
+		if email != "" && !validEmail(email) {
+			v.Add("email", "Invalid email address")
+		}
+		if v.Valid() {
+			token, err := user.RequestEmailChange(email)
+			if err == ErrUserEmailExist {
+				v.Add("email", "This email is already registered")
+			} else {
+				if err == nil {
+					err = uh.sendMail("email", email, user, map[string]interface{}{
+						"Link":  uh.link(r, "email", token),
+						"Email": email,
+					})
+				}
+				if err != nil {
+					uh.logPrintf("Email RequestEmailChange error: %s", err)
+					http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+					return
+				}
+			}
+		}
+		if v.Valid() {
+			userdata["_Sent"] = true
+		} else {
+			status = http.StatusUnprocessableEntity
+		}
+	}
+	uh.emailTemplate(w, userdata, status)
+}
+
+// emailTemplate renders _useremail with the status.
+func (uh *Handler) emailTemplate(w http.ResponseWriter, userdata map[string]interface{}, status int) {
+	w.Header().Set("Content-Type", "text/html")
+	err := uh.checkTemplate()
+	if err != nil {
+		uh.logPrintf("Email template error: %s", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(status)
+	err = uh.Config.tmpl.ExecuteTemplate(w, "_useremail", userdata)
+	if err != nil {
+		uh.logPrintf("Email template execute error: %s", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+}
+
+// emailConfirmation changes the email by a confirmation link. GET shows a
+// button only, the token is used up by its POST: mail scanners follow links.
+func (uh *Handler) emailConfirmation(w http.ResponseWriter, r *http.Request) {
+	token := r.URL.Query()["email"][0]
+	old, _, err := uh.Config.Baxter.checkToken(token, tokenEmail)
+	if r.Method != "POST" {
+		switch err {
+		case nil:
+			uh.emailTemplate(w, map[string]interface{}{"_Token": token, "_CSRF": uh.CSRFToken(w, r)}, http.StatusOK)
+		case ErrUserTokenNotFound, ErrUserTokenExpired:
+			http.Error(w, "Bad confirmation link", http.StatusForbidden)
+		default:
+			uh.logPrintf("Email checkToken error: %s", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	var u User
+	if err == nil {
+		u, err = uh.Config.Baxter.ConfirmEmailChange(token)
+	}
+	switch err {
+	case nil:
+		if uh.Config.Mailer != nil {
+			err = uh.sendMail("emailchanged", old.Email, u, map[string]interface{}{
+				"OldEmail": old.Email,
+				"Email":    u.Email,
+			})
+			if err != nil {
+				uh.logPrintf("Email changed alert mail error: %s", err)
+			}
+		}
+	case ErrUserTokenNotFound, ErrUserTokenExpired:
+		http.Error(w, "Bad confirmation link", http.StatusForbidden)
+		return
+	case ErrUserEmailExist:
+		// the address was registered after the change was requested
+		userdata, v := uh.getFormData(w, r)
+		v.Add("", "This email is already registered")
+		uh.emailTemplate(w, userdata, http.StatusUnprocessableEntity)
+		return
+	default:
+		uh.logPrintf("Email confirmation error: %s", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	uh.emailTemplate(w, map[string]interface{}{"_User": u, "_Changed": true}, http.StatusOK)
+}
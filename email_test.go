@@ -0,0 +1,45 @@
+package baxtep
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestEmailConfirmation(t *testing.T) {
+	s := newTestServer(t, nil)
+	addTestUser(t, s.b, "user", "user@example.com")
+	s.login("user@example.com")
+	resp, body := s.post("/user?email", url.Values{"email": {"new@example.com"}})
+	if resp.StatusCode != http.StatusOK || !strings.Contains(body, "sent a confirmation link") {
+		t.Fatalf("email change request: %d %s", resp.StatusCode, body)
+	}
+	link := sentLink(t, s.mail, "email")
+
+	// mail scanners follow links
+	for i := 0; i < 2; i++ {
+		resp, body = s.get(link)
+		if resp.StatusCode != http.StatusOK || !strings.Contains(body, "Confirm email") {
+			t.Fatalf("GET of the link: %d %s", resp.StatusCode, body)
+		}
+	}
+	if u, _ := s.b.GetUserByName("user"); u.Email != "user@example.com" {
+		t.Errorf("GET changed the email to %s", u.Email)
+	}
+
+	resp, body = s.post(link, url.Values{csrfField: {"bad"}})
+	if resp.StatusCode != http.StatusForbidden {
+		t.Errorf("POST without CSRF token: %d", resp.StatusCode)
+	}
+	resp, body = s.post(link, nil)
+	if resp.StatusCode != http.StatusOK || !strings.Contains(body, "Email changed to new@example.com") {
+		t.Fatalf("POST of the link: %d %s", resp.StatusCode, body)
+	}
+	if u, _ := s.b.GetUserByName("user"); u.Email != "new@example.com" {
+		t.Errorf("email is %s", u.Email)
+	}
+	if resp, _ = s.post(link, nil); resp.StatusCode != http.StatusForbidden {
+		t.Errorf("second POST of the link: %d", resp.StatusCode)
+	}
+}
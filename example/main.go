@@ -39,18 +39,22 @@ func main() {
 }
 
 func Start(listenAddres string) error {
-	baxta := baxtep.NewBaxtep(db, driverName, "user")
-	err := baxta.InitDB()
+	store, err := baxtep.NewStore(db, driverName, "user")
+	if err != nil {
+		panic(err)
+	}
+	baxta := baxtep.NewBaxtep(store)
+	// encrypts TOTP secrets, keep a real key out of the source
+	baxta.SetSecretKey([]byte("example secret key"))
+	err = baxta.InitDB()
 	if err != nil {
 		panic(err)
 	}
 
 	var logWriter LogWriter
-	userContextName := "BAXTER"
 
 	baxtepHandler := baxtep.NewHandler(&baxtep.HandlerConfig{
 		Pattern:             "/user",
-		ContextName: userContextName,
 		Baxter:              baxta,
 		RedirectAfterLogin:  nil,
 		RedirectAfterLogout: nil,
@@ -68,6 +72,7 @@ func Start(listenAddres string) error {
 		}
 		fmt.Printf("User email '%s', confirmation link '%s'\n", user.Email, confirm)
 		user.SetNewPassword("userpass")
+		user.SetEnable()
 		user.AddParams(
 			map[string]string{"for delete 1": "test 1"},
 			map[string]string{"for delete 2": "test 2"},
@@ -82,15 +87,21 @@ func Start(listenAddres string) error {
 	mux := http.NewServeMux()
 	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Content-Type", "text/html")
-		fmt.Fprint(w, "<a href='/user'>User page</a> | <a href='/user?registration'>Registration page</a> | <a href='/user?login'>Login page</a> | <a href='/user?logout'>Logout page</a><hr>")
-		user := r.Context().Value(userContextName)
-		if user == nil {
+		fmt.Fprintf(w, "<a href='/user'>User page</a> | <a href='/user?registration'>Registration page</a> | <a href='/user?login'>Login page</a> | <form action='/user?logout' method='POST' style='display:inline'><input name='csrf_token' type='hidden' value='%s'/><input type='submit' value='Logout'/></form><hr>", baxtepHandler.CSRFToken(w, r))
+		user, ok := baxtep.UserFromContext(r.Context())
+		if !ok {
 			fmt.Fprint(w, "User not login")
 			return
 		}
-		fmt.Fprintf(w, "Hello, %s!", user.(baxtep.User).Name)
+		fmt.Fprintf(w, "Hello, %s!", user.Name)
 	})
 
+	// anonymous users are sent to the login page and back here after it
+	mux.Handle("/private", baxtepHandler.RequireLogin(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, _ := baxtep.UserFromContext(r.Context())
+		fmt.Fprintf(w, "Private page of %s", user.Name)
+	})))
+
 	mux.HandleFunc("/favicon.ico", func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Content-Type", "image/x-icon")
 		ico, _ := base64.StdEncoding.DecodeString("AAABAAEAEBAAAAEAIABoBAAAFgAAACgAAAAQAAAAIAAAAAEAIAAAAAAAAAQAABILAAASCwAAAAAAAAAAAAByGSL/chki/3IZIv9yGSL/chki/3IZIv9yGSL/chki/3IZIv9yGSL/chki/3IZIv9yGSL/chki/3IZIv9yGSL/chki/8q2uP9yGSL/yra4/3IZIv/Ktrj/yra4/3IZIv9yGSL/yra4/8q2uP9yGSL/yra4/3IZIv/Ktrj/chki/3IZIv/Ktrj/chki/+je3/9yGSL/yra4/3IZIv/Ktrj/chki/8q2uP9yGSL/chki/8q2uP9yGSL/yra4/3IZIv9yGSL/yra4/+je3//Ktrj/chki/8q2uP9yGSL/yra4/3IZIv/Ktrj/yra4/3IZIv/Ktrj/yra4/3IZIv9yGSL/chki/+je3/9yGSL/yra4/3IZIv/Ktrj/chki/8q2uP9yGSL/yra4/3IZIv9yGSL/yra4/3IZIv/Ktrj/chki/3IZIv/Ktrj/chki/8q2uP9yGSL/yra4/8q2uP9yGSL/chki/8q2uP/Ktrj/chki/8q2uP/Ktrj/yra4/3IZIv9yGSL/chki/3IZIv9yGSL/chki/3IZIv9yGSL/chki/3IZIv9yGSL/chki/3IZIv9yGSL/chki/3IZIv9yGSL/chki/+je3//o3t//6N7f/+je3//o3t//6N7f/+je3/9yGSL/6N7f/+je3//o3t//6N7f/+je3//o3t//chki/3IZIv/o3t//yra4/8q2uP/Ktrj/yra4/8q2uP/Ktrj/chki/8q2uP/Ktrj/yra4/8q2uP/Ktrj/6N7f/3IZIv9yGSL/6N7f/8q2uP9yGSL/chki/3IZIv/Ktrj/6N7f/3IZIv/Ktrj/yra4/3IZIv9yGSL/yra4/+je3/9yGSL/chki/+je3//Ktrj/chki/8q2uP/o3t//6N7f/8q2uP9yGSL/6N7f/+je3/9yGSL/chki/8q2uP/o3t//chki/3IZIv/o3t//yra4/3IZIv/o3t//yra4/8q2uP/Ktrj/chki/8q2uP/Ktrj/chki/3IZIv/Ktrj/6N7f/3IZIv9yGSL/6N7f/+je3/9yGSL/chki/3IZIv9yGSL/chki/3IZIv/Ktrj/yra4/8q2uP/Ktrj/6N7f/+je3/9yGSL/chki/+je3//o3t//6N7f/+je3//o3t//6N7f/+je3/9yGSL/6N7f/+je3//o3t//6N7f/+je3//o3t//chki/3IZIv/Ktrj/yra4/8q2uP/Ktrj/yra4/8q2uP/Ktrj/chki/3IZIv9yGSL/chki/3IZIv9yGSL/chki/3IZIv9yGSL/chki/3IZIv9yGSL/chki/3IZIv9yGSL/chki/3IZIv9yGSL/chki/3IZIv9yGSL/chki/3IZIv9yGSL/AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA==")
@@ -0,0 +1,180 @@
+package baxtep
+
+import (
+	"database/sql"
+	"time"
+)
+
+// Group is a set of users, like a department or a project. Members
+// inherit the params and roles of their groups.
+type Group struct {
+	b    *Baxtep
+	id   int64
+	Name string
+}
+
+func (b *Baxtep) newGroup(d GroupData) Group {
+	return Group{b: b, id: d.ID, Name: d.Name}
+}
+
+// CreateGroup adds a group, group names are unique.
+func (b *Baxtep) CreateGroup(name string) (Group, error) {
+	_, err := b.store.GetGroupByName(name)
+	if err == nil {
+		return Group{b: b}, ErrGroupNameExist
+	}
+	if err != sql.ErrNoRows {
+		return Group{b: b}, err
+	}
+	id, err := b.store.AddGroup(name, time.Now().UTC())
+	if err != nil {
+		return Group{b: b}, err
+	}
+	return Group{b: b, id: id, Name: name}, nil
+}
+
+func (b *Baxtep) GetGroupByID(id int64) (Group, error) {
+	d, err := b.store.GetGroupByID(id)
+	if err == sql.ErrNoRows {
+		return Group{b: b}, ErrGroupNotFound
+	}
+	if err != nil {
+		return Group{b: b}, err
+	}
+	return b.newGroup(d), nil
+}
+
+func (b *Baxtep) GetGroupByName(name string) (Group, error) {
+	d, err := b.store.GetGroupByName(name)
+	if err == sql.ErrNoRows {
+		return Group{b: b}, ErrGroupNotFound
+	}
+	if err != nil {
+		return Group{b: b}, err
+	}
+	return b.newGroup(d), nil
+}
+
+// DeleteGroup deletes the group with its params and roles,
+// the members stay without it.
+func (b *Baxtep) DeleteGroup(id int64) error {
+	return b.store.DeleteGroup(id)
+}
+
+func (g *Group) GetID() int64 {
+	return g.id
+}
+
+// AddMember adds the user to the group, adding it twice is not an error.
+func (g *Group) AddMember(userID int64) error {
+	_, err := g.b.GetUserByID(userID)
+	if err != nil {
+		return err
+	}
+	return g.b.store.AddGroupMember(g.id, userID)
+}
+
+func (g *Group) RemoveMember(userID int64) error {
+	return g.b.store.DeleteGroupMember(g.id, userID)
+}
+
+func (g *Group) Members() ([]User, error) {
+	ids, err := g.b.store.GetGroupMembers(g.id)
+	if err != nil {
+		return nil, err
+	}
+	var users []User
+	for _, id := range ids {
+		u, err := g.b.GetUserByID(id)
+		if err == ErrUserWithIDNotFound {
+			continue
+		}
+		if err != nil {
+			return users, err
+		}
+		users = append(users, u)
+	}
+	return users, nil
+}
+
+func (g *Group) AddParams(params ...map[string]string) error {
+	return g.b.store.AddGroupParams(g.id, params...)
+}
+
+func (g *Group) GetParams() (map[string][]string, error) {
+	return g.b.store.GetGroupParams(g.id)
+}
+
+func (g *Group) DeleteParams(keys ...string) error {
+	return g.b.store.DeleteGroupParams(g.id, keys...)
+}
+
+// GrantRole gives the role to all members of the group.
+func (g *Group) GrantRole(role string) error {
+	return g.b.store.AddGroupRole(g.id, role)
+}
+
+func (g *Group) RevokeRole(role string) error {
+	return g.b.store.DeleteGroupRole(g.id, role)
+}
+
+func (g *Group) Roles() ([]string, error) {
+	return g.b.store.GetGroupRoles(g.id)
+}
+
+// Groups returns the groups the user is a member of.
+func (u *User) Groups() ([]Group, error) {
+	ids, err := u.b.store.GetUserGroups(u.id)
+	if err != nil {
+		return nil, err
+	}
+	var groups []Group
+	for _, id := range ids {
+		g, err := u.b.GetGroupByID(id)
+		if err == ErrGroupNotFound {
+			continue
+		}
+		if err != nil {
+			return groups, err
+		}
+		groups = append(groups, g)
+	}
+	return groups, nil
+}
+
+// groupParams returns the params of the user's groups, values of a key
+// in several groups are joined.
+func (u *User) groupParams() (map[string][]string, error) {
+	params := map[string][]string{}
+	ids, err := u.b.store.GetUserGroups(u.id)
+	if err != nil {
+		return params, err
+	}
+	for _, id := range ids {
+		p, err := u.b.store.GetGroupParams(id)
+		if err != nil {
+			return params, err
+		}
+		for k, v := range p {
+			params[k] = append(params[k], v...)
+		}
+	}
+	return params, nil
+}
+
+// groupRoles returns the roles of the user's groups.
+func (u *User) groupRoles() ([]string, error) {
+	var roles []string
+	ids, err := u.b.store.GetUserGroups(u.id)
+	if err != nil {
+		return nil, err
+	}
+	for _, id := range ids {
+		r, err := u.b.store.GetGroupRoles(id)
+		if err != nil {
+			return nil, err
+		}
+		roles = append(roles, r...)
+	}
+	return roles, nil
+}
@@ -0,0 +1,80 @@
+package baxtep
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestGroupInheritance(t *testing.T) {
+	b := newTestBaxtep(t)
+	u := addTestUser(t, b, "user", "user@example.com")
+	other := addTestUser(t, b, "other", "other@example.com")
+	b.AddPermissions("editor", "post.edit")
+	b.AddPermissions("viewer", "post.view")
+
+	g, err := b.CreateGroup("staff")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = b.CreateGroup("staff"); err != ErrGroupNameExist {
+		t.Errorf("taken group name: %v", err)
+	}
+	if err = g.AddMember(0); err != ErrUserWithIDNotFound {
+		t.Errorf("unknown member: %v", err)
+	}
+	g.AddMember(u.GetID())
+	g.AddMember(u.GetID())
+	g.GrantRole("editor")
+	g.AddParams(map[string]string{"team": "staff"}, map[string]string{"lang": "en"})
+	b.GrantRole(u.GetID(), "viewer")
+	u.AddParams(map[string]string{"lang": "ru"})
+
+	if members, err := g.Members(); err != nil || len(members) != 1 || members[0].GetID() != u.GetID() {
+		t.Errorf("members: %v, %v", members, err)
+	}
+	if groups, err := u.Groups(); err != nil || len(groups) != 1 || groups[0].Name != "staff" {
+		t.Errorf("groups: %v, %v", groups, err)
+	}
+	roles, err := u.Roles()
+	sort.Strings(roles)
+	if err != nil || !reflect.DeepEqual(roles, []string{"editor", "viewer"}) {
+		t.Errorf("roles with the group: %v, %v", roles, err)
+	}
+	if ok, err := u.HasPermission("post.edit"); err != nil || !ok {
+		t.Errorf("permission of the group role: %v, %v", ok, err)
+	}
+	if ok, _ := other.HasPermission("post.edit"); ok {
+		t.Error("permission of a group the user is not in")
+	}
+	// own params win over the group ones
+	params, err := u.GetParams()
+	if err != nil || !reflect.DeepEqual(params, map[string][]string{"team": {"staff"}, "lang": {"ru"}}) {
+		t.Errorf("params with the group: %v, %v", params, err)
+	}
+	if ok, _ := u.HasParamValue("team", "staff"); !ok {
+		t.Error("group param")
+	}
+	u.DeleteParams("team")
+	if ok, _ := u.HasParam("team"); !ok {
+		t.Error("DeleteParams deleted a group param")
+	}
+
+	g.RemoveMember(u.GetID())
+	if ok, _ := u.HasPermission("post.edit"); ok {
+		t.Error("permission of a group the user left")
+	}
+	g.AddMember(u.GetID())
+	if err = b.DeleteGroup(g.GetID()); err != nil {
+		t.Fatal(err)
+	}
+	if groups, _ := u.Groups(); len(groups) != 0 {
+		t.Errorf("groups after DeleteGroup: %v", groups)
+	}
+	if ok, _ := u.HasPermission("post.edit"); ok {
+		t.Error("permission of a deleted group")
+	}
+	if _, err = b.GetGroupByName("staff"); err != ErrGroupNotFound {
+		t.Errorf("deleted group: %v", err)
+	}
+}
@@ -8,6 +8,9 @@ import (
 	"time"
 	"io"
 	"fmt"
+	"net/url"
+	"encoding/json"
+	texttemplate "text/template"
 )
 
 type Handler struct {
@@ -17,16 +20,58 @@ type Handler struct {
 type HandlerConfig struct {
 	Pattern             string
 	Baxter              *Baxtep
+	// ContextName also puts the user in the request context under this
+	// string key when it is set.
+	//
+	// Deprecated: use UserFromContext.
 	ContextName			string
+	// RedirectAfterLogin is the page after login when the login form
+	// has no next param, Pattern+"?base" when it is nil
 	RedirectAfterLogin  *string
 	RedirectAfterLogout *string
 	SessionDuration     time.Duration
+	// ConfirmRegistration gets the registration confirmation link and
+	// writes the response instead of the "check your email" page,
+	// Mailer is used when it is nil
 	ConfirmRegistration func(http.ResponseWriter, *http.Request, string)
+	// PasswordReset delivers the password reset link to the user,
+	// Mailer is used when it is nil
+	PasswordReset func(r *http.Request, u User, link string) error
+	// MagicLink delivers the login link of ?magic to the user,
+	// Mailer is used when it is nil
+	MagicLink func(r *http.Request, u User, link string) error
+	Mailer        Mailer
+	// BaseURL like "https://example.com" for links sent to users,
+	// without it links are built from the client controlled Host header
+	BaseURL string
+	// CSRF returns the token of an external CSRF middleware wrapping the
+	// handler, the built-in check is off when it is set
+	CSRF func(r *http.Request) string
+	// IPLimiter and EmailLimiter throttle login attempts, NewHandler sets
+	// in-memory limiters when they are nil
+	IPLimiter    Limiter
+	EmailLimiter Limiter
+	// TrustedProxies are addresses or CIDR ranges of reverse proxies
+	// whose X-Forwarded-For and X-Real-IP headers give the client IP
+	TrustedProxies []string
+	// TOTPIssuer names the site in authenticator apps, Host by default
+	TOTPIssuer string
+	// WebAuthn is the relying party of passkeys, by default it is
+	// the host of BaseURL or of the request
+	WebAuthn *WebAuthn
 	LogWriter			io.Writer
 	tmpl                *template.Template
+	mailText            *texttemplate.Template
+	mailHTML            *template.Template
 }
 
 func NewHandler(config *HandlerConfig) *Handler {
+	if config.IPLimiter == nil {
+		config.IPLimiter = NewMemoryLimiter(100, 15*time.Minute)
+	}
+	if config.EmailLimiter == nil {
+		config.EmailLimiter = NewMemoryLimiter(10, 15*time.Minute)
+	}
 	handler := Handler{Config: config}
 	return &handler
 }
@@ -48,6 +93,16 @@ func (uh *Handler) Handler(h http.Handler) http.Handler {
 }
 
 func (uh *Handler) HandlerFunc(w http.ResponseWriter, r *http.Request) bool {
+	// logout changes state too, a link or an image must not log users out
+	if _, logout := r.URL.Query()["logout"]; logout && r.Method != "POST" {
+		w.Header().Set("Allow", "POST")
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return true
+	}
+	if r.Method != "GET" && r.Method != "HEAD" && !uh.checkCSRF(r) {
+		http.Error(w, "Bad CSRF token", http.StatusForbidden)
+		return true
+	}
 	if _, ok := r.URL.Query()["login"]; ok {
 		uh.login(w, r)
 		return true
@@ -60,11 +115,27 @@ func (uh *Handler) HandlerFunc(w http.ResponseWriter, r *http.Request) bool {
 	} else if _, ok := r.URL.Query()["registration"]; ok {
 		uh.registration(w, r)
 		return true
-	} else {
-		uh.Base(w, r)
+	} else if _, ok := r.URL.Query()["forgot"]; ok {
+		uh.forgot(w, r)
+		return true
+	} else if _, ok := r.URL.Query()["reset"]; ok {
+		uh.reset(w, r)
+		return true
+	} else if _, ok := r.URL.Query()["email"]; ok {
+		uh.email(w, r)
+		return true
+	} else if _, ok := r.URL.Query()["totp"]; ok {
+		uh.totp(w, r)
+		return true
+	} else if _, ok := r.URL.Query()["passkey"]; ok {
+		uh.passkey(w, r)
+		return true
+	} else if _, ok := r.URL.Query()["magic"]; ok {
+		uh.magic(w, r)
 		return true
 	}
-	return false
+	uh.Base(w, r)
+	return true
 }
 
 // ToDo captcha
@@ -74,29 +145,24 @@ func (uh *Handler) registration(w http.ResponseWriter, r *http.Request) {
 		uh.confirmation(w, r)
 		return
 	}
+	userdata, v := uh.getFormData(w, r, "name", "email")
+	status := http.StatusOK
 	if r.Method == "POST" {
-		if r.FormValue("name") == "" {
-			http.Error(w, "Blank name", http.StatusOK)
-			return
+		v.required(r, "name", "email", "password", "retry-password")
+		if r.FormValue("email") != "" && !validEmail(r.FormValue("email")) {
+			v.Add("email", "Invalid email address")
 		}
-		if r.FormValue("email") == "" {
-			http.Error(w, "Blank email", http.StatusOK)
-			return
-		}
-		if r.FormValue("password") == "" {
-			http.Error(w, "Blank password", http.StatusOK)
-			return
+		if r.FormValue("retry-password") != "" && r.FormValue("password") != r.FormValue("retry-password") {
+			v.Add("retry-password", "Password does not match")
 		}
-		if r.FormValue("password") == "" {
-			http.Error(w, "Blank retry password", http.StatusOK)
+		if v.Valid() && !uh.register(w, r, v) {
 			return
 		}
-		if r.FormValue("password") != r.FormValue("retry-password") {
-			http.Error(w, "Password does not match", http.StatusOK)
-			return
+		if v.Valid() {
+			userdata["_Sent"] = true
+		} else {
+			status = http.StatusUnprocessableEntity
 		}
-		user := uh.Config.Baxter.AddNewUser(r.FormValue("name"), r.FormValue("email"))
-		return
 	}
 	w.Header().Set("Content-Type", "text/html")
 	err := uh.checkTemplate()
@@ -105,7 +171,8 @@ func (uh *Handler) registration(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
 		return
 	}
-	err = uh.Config.tmpl.ExecuteTemplate(w, "_userregistration", nil)
+	w.WriteHeader(status)
+	err = uh.Config.tmpl.ExecuteTemplate(w, "_userregistration", userdata)
 	if err != nil {
 		uh.logPrintf("Registration template execute error: %s", err)
 		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
@@ -113,8 +180,57 @@ func (uh *Handler) registration(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// register adds the user of the registration form and delivers the
+// confirmation link, a taken name or email goes to v. It returns false
+// when the response is written, by an error or by ConfirmRegistration.
+func (uh *Handler) register(w http.ResponseWriter, r *http.Request, v Validation) bool {
+	user, confirm, err := uh.Config.Baxter.RegisterUser(r.FormValue("name"), r.FormValue("email"), r.FormValue("password"))
+	switch err {
+	case nil:
+	case ErrUserNameExist:
+		v.Add("name", "This name is already taken")
+		return true
+	case ErrUserEmailExist:
+		v.Add("email", "This email is already registered")
+		return true
+	default:
+		uh.logPrintf("Registration RegisterUser error: %s", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return false
+	}
+	link := uh.link(r, "registration", confirm)
+	if uh.Config.ConfirmRegistration != nil {
+		uh.Config.ConfirmRegistration(w, r, link)
+		return false
+	}
+	err = uh.sendMail("confirmation", user.Email, user, map[string]interface{}{"Link": link})
+	if err != nil {
+		uh.logPrintf("Registration confirmation mail error: %s", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return false
+	}
+	return true
+}
+
+// confirmation confirms the registration by the link. GET shows a button
+// only, the code is used up by its POST: mail scanners follow links.
 func (uh *Handler) confirmation(w http.ResponseWriter, r *http.Request) {
-	u, err := uh.Config.Baxter.ConfirmRegistration(r.URL.Query()["registration"][0])
+	confirm := r.URL.Query()["registration"][0]
+	if r.Method != "POST" {
+		_, err := uh.Config.Baxter.checkConfirm(confirm)
+		if err == ErrUserNotFound {
+			http.Error(w, "Bad confirmation link", http.StatusForbidden)
+			return
+		}
+		if err != nil {
+			uh.logPrintf("Confirmation checkConfirm error: %s", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+		uh.confirmationPage(w, map[string]interface{}{"_Token": confirm, "_CSRF": uh.CSRFToken(w, r)})
+		return
+	}
+	u, err := uh.Config.Baxter.ConfirmRegistration(confirm)
 	if err == ErrUserNotFound {
 		http.Error(w, "Bad confirmation link", http.StatusForbidden)
 		return
@@ -124,28 +240,24 @@ func (uh *Handler) confirmation(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
 		return
 	}
-	sessionID, err := u.SetNewSessionID()
+	err = uh.startSession(w, r, u)
 	if err != nil {
 		uh.logPrintf("Confirmation SetNewSession error: %s", err)
 		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
 		return
 	}
-	cookie := http.Cookie{
-		Path:     "/",
-		Name:     "session_id",
-		Value:    sessionID,
-		Expires:  time.Now().Add(uh.Config.SessionDuration),
-		HttpOnly: true,
-	}
-	http.SetCookie(w, &cookie)
+	uh.confirmationPage(w, map[string]interface{}{"_User": u})
+}
+
+func (uh *Handler) confirmationPage(w http.ResponseWriter, data map[string]interface{}) {
 	w.Header().Set("Content-Type", "text/html")
-	err = uh.checkTemplate()
+	err := uh.checkTemplate()
 	if err != nil {
 		uh.logPrintf( "Confirmation template error: %s", err)
 		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
 		return
 	}
-	err = uh.Config.tmpl.ExecuteTemplate(w, "_userconfirmation", u)
+	err = uh.Config.tmpl.ExecuteTemplate(w, "_userconfirmation", data)
 	if err != nil {
 		uh.logPrintf("Confirmation template execute error: %s", err)
 		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
@@ -156,49 +268,41 @@ func (uh *Handler) confirmation(w http.ResponseWriter, r *http.Request) {
 
 // ToDo captcha
 func (uh *Handler) login(w http.ResponseWriter, r *http.Request) {
+	userdata, v := uh.getFormData(w, r, "email", "next")
+	status := http.StatusOK
 	if r.Method == "POST" {
-		if r.FormValue("email") == "" {
-			http.Error(w, "Blank email", http.StatusForbidden)
-			return
-		}
-		if r.FormValue("password") == "" {
-			http.Error(w, "Blank password", http.StatusForbidden)
-			return
-		}
-		u, err := uh.Config.Baxter.GetUserByEmailPassword(r.FormValue("email"), r.FormValue("password"))
-		if err != nil {
-			switch err {
-			case ErrUserWithEmailNotFound, ErrUserBadPassword:
-				 http.Error(w, "Wrong email or password", http.StatusForbidden)
-			default:
+		v.required(r, "email", "password")
+		if !v.Valid() {
+			status = http.StatusUnprocessableEntity
+		} else if !uh.allowLogin(r, r.FormValue("email")) {
+			v.Add("", "Too many login attempts, try again later")
+			status = http.StatusTooManyRequests
+		} else {
+			u, err := uh.Config.Baxter.GetUserByEmailPassword(r.FormValue("email"), r.FormValue("password"))
+			switch {
+			case err == ErrUserWithEmailNotFound || err == ErrUserBadPassword:
+				v.Add("", "Wrong email or password")
+			case err == ErrUserLocked:
+				v.Add("", "Too many wrong passwords, the account is locked for a while")
+			case err != nil:
 				uh.logPrintf("Login GetUserByEmailPassword error: %s", err)
 				http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+				return
+			case !u.Enable:
+				// registration is not confirmed yet or the user is blocked
+				v.Add("", "User is disabled")
 			}
-			return
-		}
-		sessionID, err := u.SetNewSessionID()
-		if err != nil {
-			uh.logPrintf("Login SetNewSession error: %s", err)
-			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
-			return
-		}
-		cookie := http.Cookie{
-			Path:     "/",
-			Name:     "session_id",
-			Value:    sessionID,
-			Expires:  time.Now().Add(uh.Config.SessionDuration),
-			HttpOnly: true,
-		}
-		http.SetCookie(w, &cookie)
-		if uh.Config.RedirectAfterLogin != nil {
-			http.Redirect(w, r, *uh.Config.RedirectAfterLogin, http.StatusFound)
-			return
+			if v.Valid() {
+				if uh.Config.EmailLimiter != nil {
+					uh.Config.EmailLimiter.Reset("email:" + strings.ToLower(r.FormValue("email")))
+				}
+				uh.authenticated(w, r, u)
+				return
+			}
+			status = http.StatusForbidden
 		}
-		http.Redirect(w, r, "?base", http.StatusFound)
-		return
 	}
 
-	// else GET method
 	w.Header().Set("Content-Type", "text/html")
 	err := uh.checkTemplate()
 	if err != nil {
@@ -206,7 +310,7 @@ func (uh *Handler) login(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
 		return
 	}
-	userdata := uh.getUserData(w, r)
+	w.WriteHeader(status)
 	err = uh.Config.tmpl.ExecuteTemplate(w, "_userlogin", userdata)
 	if err != nil {
 		uh.logPrintf("Login template execute error: %s", err)
@@ -215,7 +319,91 @@ func (uh *Handler) login(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// authenticated goes on with the login of u after the password or another
+// first factor: to the second factor if u has one, or to a new session.
+func (uh *Handler) authenticated(w http.ResponseWriter, r *http.Request, u User) {
+	has2FA, err := u.HasTOTP()
+	if err != nil {
+		uh.logPrintf("Login HasTOTP error: %s", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	if has2FA {
+		uh.secondFactor(w, r, u)
+		return
+	}
+	uh.logIn(w, r, u)
+}
+
+// logIn starts a new session of u and redirects to the next param
+// or RedirectAfterLogin.
+func (uh *Handler) logIn(w http.ResponseWriter, r *http.Request, u User) {
+	err := uh.startSession(w, r, u)
+	if err != nil {
+		uh.logPrintf("Login SetNewSession error: %s", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	http.Redirect(w, r, uh.afterLogin(r), http.StatusFound)
+}
+
+// startSession starts a new session of u and sets its cookie.
+func (uh *Handler) startSession(w http.ResponseWriter, r *http.Request, u User) error {
+	sessionID, err := u.NewSession(r.UserAgent(), uh.clientIP(r))
+	if err != nil {
+		return err
+	}
+	cookie := http.Cookie{
+		Path:     "/",
+		Name:     "session_id",
+		Value:    sessionID,
+		Expires:  time.Now().Add(uh.Config.SessionDuration),
+		HttpOnly: true,
+	}
+	http.SetCookie(w, &cookie)
+	return nil
+}
+
+// afterLogin is the page a user goes to after login: the page the user
+// was sent to the login from, given by the next param, or the default one.
+func (uh *Handler) afterLogin(r *http.Request) string {
+	if next := safeNext(r.FormValue("next")); next != "" {
+		return next
+	}
+	if uh.Config.RedirectAfterLogin != nil {
+		return *uh.Config.RedirectAfterLogin
+	}
+	return uh.Config.Pattern + "?base"
+}
+
+// safeNext returns next if it is a path on this site and "" otherwise,
+// so the login can't send users to another site.
+func safeNext(next string) string {
+	if !strings.HasPrefix(next, "/") || strings.HasPrefix(next, "//") || strings.HasPrefix(next, "/\\") {
+		return ""
+	}
+	// browsers drop tabs and newlines, "/\t/host" would become "//host"
+	for _, c := range next {
+		if c < 0x20 || c == 0x7f {
+			return ""
+		}
+	}
+	u, err := url.Parse(next)
+	if err != nil || u.Scheme != "" || u.Host != "" {
+		return ""
+	}
+	return next
+}
+
 func (uh *Handler) logout(w http.ResponseWriter, r *http.Request) {
+	if sessionID, err := r.Cookie("session_id"); err == nil {
+		err = uh.Config.Baxter.Logout(sessionID.Value)
+		if err != nil {
+			uh.logPrintf("Logout error: %s", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+	}
 	c := &http.Cookie{
 		Name:     "session_id",
 		Value:    "",
@@ -228,7 +416,7 @@ func (uh *Handler) logout(w http.ResponseWriter, r *http.Request) {
 		http.Redirect(w, r, *uh.Config.RedirectAfterLogout, http.StatusFound)
 		return
 	}
-	http.Redirect(w, r, "?comeout", http.StatusFound)
+	uh.redirect(w, r, "cameout")
 }
 
 // cameout page after exit ???
@@ -259,20 +447,18 @@ func (uh *Handler) Base(w http.ResponseWriter, r *http.Request) {
 	}
 	err = uh.Config.tmpl.ExecuteTemplate(w, "_userbase", userdata)
 	if err != nil {
-		uh.logPrint("Base template execute error: %s", err)
+		uh.logPrintf("Base template execute error: %s", err)
 		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
 		return
 	}
 }
 
 func (uh *Handler) getUserData(w http.ResponseWriter, r *http.Request) map[string]interface{} {
-	data := map[string]interface{}{}
-	ctx := uh.check(w, r)
-	r = r.WithContext(ctx)
-	if ok := r.Context().Value(uh.Config.ContextName); ok == nil {
+	data := map[string]interface{}{"_CSRF": uh.CSRFToken(w, r)}
+	user, ok := UserFromContext(uh.check(w, r))
+	if !ok {
 		return data
 	}
-	user := r.Context().Value(uh.Config.ContextName).(User)
 	data["_User"] = user
 	params, err := user.GetParams()
 	if err != nil {
@@ -293,7 +479,7 @@ func (uh *Handler) checkHandler(h http.Handler) http.Handler {
 
 func  (uh *Handler) check(w http.ResponseWriter, r *http.Request) context.Context {
 	var user User
-	ctx := context.Background()
+	ctx := r.Context()
 	sessionID, err := r.Cookie("session_id")
 	if err != http.ErrNoCookie {
 		if err != nil {
@@ -310,7 +496,7 @@ func  (uh *Handler) check(w http.ResponseWriter, r *http.Request) context.Contex
 		}
 
 		err = user.CheckSessionID(uh.Config.SessionDuration)
-		if err == ErrUserSessionExpired || err == ErrUserNotFound {
+		if err == ErrUserSessionExpired || err == ErrUserSessionNotFound {
 			return ctx
 		}
 		if err != nil {
@@ -318,11 +504,61 @@ func  (uh *Handler) check(w http.ResponseWriter, r *http.Request) context.Contex
 			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
 			return ctx
 		}
-		ctx = context.WithValue(r.Context(), uh.Config.ContextName, user)
+		ctx = WithUser(ctx, user)
+		if uh.Config.ContextName != "" {
+			ctx = context.WithValue(ctx, uh.Config.ContextName, user)
+		}
 	}
 	return ctx
 }
 
+type userContextKey struct{}
+
+// UserFromContext returns the logged in user the handler put in the
+// request context.
+func UserFromContext(ctx context.Context) (User, bool) {
+	u, ok := ctx.Value(userContextKey{}).(User)
+	return u, ok
+}
+
+// WithUser returns ctx with u as the logged in user, for tests of
+// handlers that use UserFromContext.
+func WithUser(ctx context.Context, u User) context.Context {
+	return context.WithValue(ctx, userContextKey{}, u)
+}
+
+// link returns the absolute URL of a handler action with a value.
+func (uh *Handler) link(r *http.Request, action, value string) string {
+	base := uh.Config.BaseURL
+	if base == "" {
+		scheme := "http"
+		if r.TLS != nil {
+			scheme = "https"
+		}
+		base = scheme + "://" + r.Host
+	}
+	return base + uh.Config.Pattern + "?" + action + "=" + url.QueryEscape(value)
+}
+
+// redirect sends the browser to an action of the handler. A bare "?action"
+// would resolve against the parent directory of a Pattern like "/user".
+func (uh *Handler) redirect(w http.ResponseWriter, r *http.Request, action string) {
+	http.Redirect(w, r, uh.Config.Pattern+"?"+action, http.StatusFound)
+}
+
+func (uh *Handler) writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	err := json.NewEncoder(w).Encode(v)
+	if err != nil {
+		uh.logPrintf("JSON encode error: %s", err)
+	}
+}
+
+func (uh *Handler) jsonError(w http.ResponseWriter, status int, message string) {
+	uh.writeJSON(w, status, map[string]string{"error": message})
+}
+
 func (uh *Handler) checkTemplate() error {
 	if uh.Config.tmpl == nil {
 		// use default template
@@ -0,0 +1,375 @@
+package baxtep
+
+import (
+	"context"
+	"database/sql"
+	"io"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	_ "modernc.org/sqlite"
+)
+
+// newTestBaxtep returns a Baxtep on a new in-memory SQLite database.
+func newTestBaxtep(t *testing.T) *Baxtep {
+	db, err := sql.Open("sqlite", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// every connection to :memory: is another database
+	db.SetMaxOpenConns(1)
+	t.Cleanup(func() { db.Close() })
+	b := NewBaxtep(NewSQLiteStore(db, "test"))
+	// cheap parameters, the defaults make the tests slow
+	b.SetPasswordHasher(&Argon2idHasher{Time: 1, Memory: 1024, Threads: 1, KeyLen: 32, SaltLen: 16})
+	err = b.InitDB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+// addTestUser adds an enabled user with the password "password".
+func addTestUser(t *testing.T, b *Baxtep, name, email string) User {
+	_, confirm, err := b.RegisterUser(name, email, "password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	u, err := b.ConfirmRegistration(confirm)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return u
+}
+
+// sentLink returns the link of the action in the last mail having one.
+func sentLink(t *testing.T, m *RecordingMailer, action string) string {
+	re := regexp.MustCompile(`https?://\S+\?` + action + `=[^\s"<]+`)
+	messages := m.Messages()
+	for i := len(messages) - 1; i >= 0; i-- {
+		if link := re.FindString(messages[i].Text); link != "" {
+			return link
+		}
+	}
+	t.Fatalf("no %s link is sent", action)
+	return ""
+}
+
+// testServer is the handler on Pattern "/user" in front of a page
+// answering "page", with a browser keeping cookies.
+type testServer struct {
+	t      *testing.T
+	uh     *Handler
+	b      *Baxtep
+	mail   *RecordingMailer
+	srv    *httptest.Server
+	client *http.Client
+}
+
+func newTestServer(t *testing.T, config *HandlerConfig) *testServer {
+	s := &testServer{t: t, mail: &RecordingMailer{}}
+	if config == nil {
+		config = &HandlerConfig{}
+	}
+	if config.Baxter == nil {
+		config.Baxter = newTestBaxtep(t)
+	}
+	if config.Pattern == "" {
+		config.Pattern = "/user"
+	}
+	if config.SessionDuration == 0 {
+		config.SessionDuration = time.Hour
+	}
+	if config.Mailer == nil {
+		config.Mailer = s.mail
+	}
+	if config.LogWriter == nil {
+		config.LogWriter = io.Discard
+	}
+	s.b = config.Baxter
+	s.uh = NewHandler(config)
+	page := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "page")
+	})
+	s.srv = httptest.NewServer(s.uh.Handler(page))
+	t.Cleanup(s.srv.Close)
+	jar, _ := cookiejar.New(nil)
+	s.client = &http.Client{
+		Jar: jar,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	return s
+}
+
+// serve puts h behind the handler instead of the page.
+func (s *testServer) serve(h http.Handler) {
+	s.srv.Close()
+	s.srv = httptest.NewServer(s.uh.Handler(h))
+	s.t.Cleanup(s.srv.Close)
+}
+
+// do sends the request and returns the response with its body.
+func (s *testServer) do(req *http.Request) (*http.Response, string) {
+	resp, err := s.client.Do(req)
+	if err != nil {
+		s.t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		s.t.Fatal(err)
+	}
+	return resp, string(body)
+}
+
+// url returns the absolute URL of a path or of a link.
+func (s *testServer) url(path string) string {
+	if strings.HasPrefix(path, "http") {
+		u, _ := url.Parse(path)
+		path = u.RequestURI()
+	}
+	return s.srv.URL + path
+}
+
+func (s *testServer) get(path string) (*http.Response, string) {
+	req, _ := http.NewRequest("GET", s.url(path), nil)
+	return s.do(req)
+}
+
+// post sends the form with the CSRF token of the browser.
+func (s *testServer) post(path string, form url.Values) (*http.Response, string) {
+	if form == nil {
+		form = url.Values{}
+	}
+	if form.Get(csrfField) == "" {
+		form.Set(csrfField, s.csrf())
+	}
+	req, _ := http.NewRequest("POST", s.url(path), strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	return s.do(req)
+}
+
+// cookie returns the value of a cookie of the browser, "" without it.
+func (s *testServer) cookie(name string) string {
+	u, _ := url.Parse(s.srv.URL)
+	for _, c := range s.client.Jar.Cookies(u) {
+		if c.Name == name {
+			return c.Value
+		}
+	}
+	return ""
+}
+
+// csrf returns the CSRF cookie of the browser, a page sets it first.
+func (s *testServer) csrf() string {
+	if s.cookie(csrfCookie) == "" {
+		s.get("/user?login")
+	}
+	if token := s.cookie(csrfCookie); token != "" {
+		return token
+	}
+	s.t.Fatal("no CSRF cookie")
+	return ""
+}
+
+// browser returns a server client with its own cookies.
+func (s *testServer) browser() *testServer {
+	other := *s
+	jar, _ := cookiejar.New(nil)
+	other.client = &http.Client{Jar: jar, CheckRedirect: s.client.CheckRedirect}
+	return &other
+}
+
+// login logs the browser in, the user must have the password "password".
+func (s *testServer) login(email string) {
+	resp, body := s.post("/user?login", url.Values{"email": {email}, "password": {"password"}})
+	if resp.StatusCode != http.StatusFound {
+		s.t.Fatalf("login of %s: %d %s", email, resp.StatusCode, body)
+	}
+}
+
+func TestRegistration(t *testing.T) {
+	s := newTestServer(t, nil)
+	form := url.Values{
+		"name":           {"user"},
+		"email":          {"user@example.com"},
+		"password":       {"password"},
+		"retry-password": {"password"},
+	}
+	resp, body := s.post("/user?registration", form)
+	if resp.StatusCode != http.StatusOK || !strings.Contains(body, "check your email") {
+		t.Fatalf("registration: %d %s", resp.StatusCode, body)
+	}
+	if resp, _ = s.post("/user?registration", form); resp.StatusCode != http.StatusUnprocessableEntity {
+		t.Errorf("registration of a taken name: %d", resp.StatusCode)
+	}
+	link := sentLink(t, s.mail, "registration")
+
+	// mail scanners follow links
+	resp, body = s.get(link)
+	if resp.StatusCode != http.StatusOK || !strings.Contains(body, "Confirm registration") {
+		t.Fatalf("GET of the link: %d %s", resp.StatusCode, body)
+	}
+	if u, _ := s.b.GetUserByName("user"); u.Enable {
+		t.Error("GET confirmed the registration")
+	}
+	resp, body = s.post(link, nil)
+	if resp.StatusCode != http.StatusOK || !strings.Contains(body, "Confirmed") {
+		t.Fatalf("POST of the link: %d %s", resp.StatusCode, body)
+	}
+	if u, _ := s.b.GetUserByName("user"); !u.Enable {
+		t.Error("registration is not confirmed")
+	}
+	if _, body = s.get("/user?base"); !strings.Contains(body, "user@example.com") {
+		t.Errorf("not logged in after the confirmation: %s", body)
+	}
+	if resp, _ = s.post(link, nil); resp.StatusCode != http.StatusForbidden {
+		t.Errorf("second POST of the link: %d", resp.StatusCode)
+	}
+}
+
+func TestRegistrationCallback(t *testing.T) {
+	var link string
+	s := newTestServer(t, &HandlerConfig{
+		ConfirmRegistration: func(w http.ResponseWriter, r *http.Request, l string) {
+			link = l
+			io.WriteString(w, "custom page")
+		},
+	})
+	resp, body := s.post("/user?registration", url.Values{
+		"name":           {"user"},
+		"email":          {"user@example.com"},
+		"password":       {"password"},
+		"retry-password": {"password"},
+	})
+	if resp.StatusCode != http.StatusOK || body != "custom page" {
+		t.Errorf("registration: %d %q", resp.StatusCode, body)
+	}
+	if !strings.Contains(link, "/user?registration=") {
+		t.Errorf("link %q", link)
+	}
+	if len(s.mail.Messages()) != 0 {
+		t.Error("mail is sent with the callback")
+	}
+}
+
+func TestLogout(t *testing.T) {
+	s := newTestServer(t, nil)
+	addTestUser(t, s.b, "user", "user@example.com")
+	s.login("user@example.com")
+	phone := s.browser()
+	phone.login("user@example.com")
+	sessionID := s.cookie("session_id")
+
+	resp, _ := s.post("/user?logout", nil)
+	if resp.StatusCode != http.StatusFound || resp.Header.Get("Location") != "/user?cameout" {
+		t.Fatalf("logout: %d %s", resp.StatusCode, resp.Header.Get("Location"))
+	}
+	if s.cookie("session_id") != "" {
+		t.Error("session cookie is kept")
+	}
+
+	// a copy of the cookie is no use after the logout
+	stolen := s.browser()
+	u, _ := url.Parse(s.srv.URL)
+	stolen.client.Jar.SetCookies(u, []*http.Cookie{{Name: "session_id", Value: sessionID}})
+	if _, body := stolen.get("/user?base"); strings.Contains(body, "user@example.com") {
+		t.Error("session works after logout")
+	}
+	if _, err := s.b.GetUserBySessionID(sessionID); err != ErrUserSessionNotFound {
+		t.Errorf("session after logout: %v", err)
+	}
+	if _, body := phone.get("/user?base"); !strings.Contains(body, "user@example.com") {
+		t.Error("logout ended the session on another device")
+	}
+
+	// logout without a session
+	if resp, _ = s.post("/user?logout", nil); resp.StatusCode != http.StatusFound {
+		t.Errorf("logout without a session: %d", resp.StatusCode)
+	}
+}
+
+func TestSafeNext(t *testing.T) {
+	for next, want := range map[string]string{
+		"/private":            "/private",
+		"/private?a=1&b=/c#d": "/private?a=1&b=/c#d",
+		"":                    "",
+		"private":             "",
+		"//evil.com":          "",
+		"//evil.com/private":  "",
+		"/\\evil.com":         "",
+		"/\t/evil.com":        "",
+		"/\n/evil.com":        "",
+		"https://evil.com":    "",
+		"http:/evil.com":      "",
+		"javascript:alert(1)": "",
+	} {
+		if got := safeNext(next); got != want {
+			t.Errorf("safeNext(%q) = %q, want %q", next, got, want)
+		}
+	}
+}
+
+func TestLoginNext(t *testing.T) {
+	s := newTestServer(t, nil)
+	addTestUser(t, s.b, "user", "user@example.com")
+	for next, want := range map[string]string{
+		"/private?a=1":     "/private?a=1",
+		"//evil.com":       "/user?base",
+		"https://evil.com": "/user?base",
+	} {
+		b := s.browser()
+		if _, body := b.get("/user?login&next=" + url.QueryEscape(next)); !strings.Contains(body, `name="next"`) {
+			t.Errorf("no next in the login form: %s", body)
+		}
+		resp, _ := b.post("/user?login", url.Values{"email": {"user@example.com"}, "password": {"password"}, "next": {next}})
+		if resp.StatusCode != http.StatusFound || resp.Header.Get("Location") != want {
+			t.Errorf("login with next %q: %d %s", next, resp.StatusCode, resp.Header.Get("Location"))
+		}
+	}
+}
+
+func TestUserFromContext(t *testing.T) {
+	if _, ok := UserFromContext(context.Background()); ok {
+		t.Error("user in an empty context")
+	}
+	b := newTestBaxtep(t)
+	u := addTestUser(t, b, "user", "user@example.com")
+	ctx := WithUser(context.Background(), u)
+	if got, ok := UserFromContext(ctx); !ok || got.GetID() != u.GetID() {
+		t.Errorf("UserFromContext of WithUser: %+v, %v", got, ok)
+	}
+	// the key is not a plain string other packages could collide with
+	if ctx.Value("user") != nil {
+		t.Error("user under a string key")
+	}
+
+	s := newTestServer(t, &HandlerConfig{Baxter: b, ContextName: "user"})
+	type seen struct {
+		typed, named bool
+		name         string
+	}
+	var got seen
+	s.serve(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		u, ok := UserFromContext(r.Context())
+		_, named := r.Context().Value("user").(User)
+		got = seen{ok, named, u.Name}
+	}))
+	s.get("/page")
+	if got != (seen{}) {
+		t.Errorf("anonymous request: %+v", got)
+	}
+	s.login("user@example.com")
+	s.get("/page")
+	if got != (seen{true, true, "user"}) {
+		t.Errorf("logged in request: %+v", got)
+	}
+}
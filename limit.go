@@ -0,0 +1,164 @@
+package baxtep
+
+import (
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// SetLockout locks a user for d after failures wrong passwords or 2FA
+// codes in a row,
+// 10 failures and 15 minutes by default. Zero failures turns it off.
+func (b *Baxtep) SetLockout(failures int, d time.Duration) {
+	b.maxFailures = failures
+	b.lockDuration = d
+}
+
+// checkLock returns failed logins in a row, or ErrUserLocked.
+func (u *User) checkLock() (int, error) {
+	failures, until, err := u.b.store.GetUserLock(u.id)
+	if err != nil {
+		return 0, err
+	}
+	if time.Now().UTC().Before(until) {
+		return failures, ErrUserLocked
+	}
+	return failures, nil
+}
+
+// loginFailed counts a wrong password and locks the user on the last allowed one.
+func (u *User) loginFailed() error {
+	failures, err := u.b.store.AddUserFailure(u.id)
+	if err != nil {
+		return err
+	}
+	if u.b.maxFailures > 0 && failures >= u.b.maxFailures {
+		return u.b.store.SetUserLock(u.id, 0, time.Now().UTC().Add(u.b.lockDuration))
+	}
+	return nil
+}
+
+// Unlock ends the lockout and forgets failed logins.
+func (u *User) Unlock() error {
+	return u.b.store.SetUserLock(u.id, 0, time.Unix(0, 0))
+}
+
+// Limiter throttles login attempts by key, like "ip:192.0.2.1" or
+// "email:user@host". Allow counts an attempt and reports if it is
+// within the limit, Reset forgets the key.
+type Limiter interface {
+	Allow(key string) bool
+	Reset(key string)
+}
+
+// MemoryLimiter allows Limit attempts per key in a Window, for a single
+// process. Use a shared Limiter, Redis for example, for several ones.
+type MemoryLimiter struct {
+	Limit  int
+	Window time.Duration
+	mu     sync.Mutex
+	keys   map[string]*limitWindow
+	swept  time.Time
+}
+
+type limitWindow struct {
+	start    time.Time
+	attempts int
+}
+
+func NewMemoryLimiter(limit int, window time.Duration) *MemoryLimiter {
+	return &MemoryLimiter{Limit: limit, Window: window}
+}
+
+func (l *MemoryLimiter) Allow(key string) bool {
+	now := time.Now()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.keys == nil {
+		l.keys = map[string]*limitWindow{}
+	}
+	// drop finished windows now and then so the map does not grow forever
+	if now.Sub(l.swept) > l.Window {
+		for k, w := range l.keys {
+			if now.Sub(w.start) > l.Window {
+				delete(l.keys, k)
+			}
+		}
+		l.swept = now
+	}
+	w, ok := l.keys[key]
+	if !ok || now.Sub(w.start) > l.Window {
+		w = &limitWindow{start: now}
+		l.keys[key] = w
+	}
+	w.attempts++
+	return w.attempts <= l.Limit
+}
+
+func (l *MemoryLimiter) Reset(key string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.keys, key)
+}
+
+// allowLogin counts a login attempt for the client IP and the email.
+func (uh *Handler) allowLogin(r *http.Request, email string) bool {
+	ok := true
+	if uh.Config.IPLimiter != nil {
+		ok = uh.Config.IPLimiter.Allow("ip:" + uh.clientIP(r))
+	}
+	if uh.Config.EmailLimiter != nil {
+		// count the email even if the IP is over the limit
+		ok = uh.Config.EmailLimiter.Allow("email:"+strings.ToLower(email)) && ok
+	}
+	return ok
+}
+
+// clientIP returns the address of the client. Behind TrustedProxies it is
+// the last X-Forwarded-For address that is not a trusted proxy, or X-Real-IP.
+func (uh *Handler) clientIP(r *http.Request) string {
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		ip = r.RemoteAddr
+	}
+	if !uh.trustedProxy(ip) {
+		return ip
+	}
+	if len(r.Header["X-Forwarded-For"]) == 0 {
+		if realIP := strings.TrimSpace(r.Header.Get("X-Real-IP")); net.ParseIP(realIP) != nil {
+			return realIP
+		}
+		return ip
+	}
+	forwarded := strings.Split(strings.Join(r.Header["X-Forwarded-For"], ","), ",")
+	for i := len(forwarded) - 1; i >= 0; i-- {
+		addr := strings.TrimSpace(forwarded[i])
+		if net.ParseIP(addr) == nil {
+			break
+		}
+		ip = addr
+		if !uh.trustedProxy(addr) {
+			break
+		}
+	}
+	return ip
+}
+
+func (uh *Handler) trustedProxy(ip string) bool {
+	addr := net.ParseIP(ip)
+	if addr == nil {
+		return false
+	}
+	for _, proxy := range uh.Config.TrustedProxies {
+		if _, network, err := net.ParseCIDR(proxy); err == nil {
+			if network.Contains(addr) {
+				return true
+			}
+		} else if proxyAddr := net.ParseIP(proxy); proxyAddr != nil && proxyAddr.Equal(addr) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,156 @@
+package baxtep
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLockout(t *testing.T) {
+	b := newTestBaxtep(t)
+	b.SetLockout(3, time.Hour)
+	addTestUser(t, b, "user", "user@example.com")
+	for i := 0; i < 3; i++ {
+		if _, err := b.GetUserByEmailPassword("user@example.com", "wrong"); err != ErrUserBadPassword {
+			t.Fatalf("wrong password %d: %v", i+1, err)
+		}
+	}
+	// the right password does not help while locked
+	if _, err := b.GetUserByEmailPassword("user@example.com", "password"); err != ErrUserLocked {
+		t.Fatalf("locked user: %v", err)
+	}
+	u, _ := b.GetUserByEmail("user@example.com")
+	if err := u.Unlock(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := b.GetUserByEmailPassword("user@example.com", "password"); err != nil {
+		t.Fatalf("unlocked user: %v", err)
+	}
+
+	// a successful login forgets earlier failures
+	b.GetUserByEmailPassword("user@example.com", "wrong")
+	b.GetUserByEmailPassword("user@example.com", "wrong")
+	b.GetUserByEmailPassword("user@example.com", "password")
+	b.GetUserByEmailPassword("user@example.com", "wrong")
+	if _, err := b.GetUserByEmailPassword("user@example.com", "password"); err != nil {
+		t.Errorf("failures are not reset by a login: %v", err)
+	}
+
+	b.SetLockout(0, time.Hour)
+	for i := 0; i < 5; i++ {
+		b.GetUserByEmailPassword("user@example.com", "wrong")
+	}
+	if _, err := b.GetUserByEmailPassword("user@example.com", "password"); err != nil {
+		t.Errorf("lockout is off: %v", err)
+	}
+}
+
+func TestLoginLockout(t *testing.T) {
+	b := newTestBaxtep(t)
+	b.SetLockout(2, time.Hour)
+	s := newTestServer(t, &HandlerConfig{Baxter: b})
+	addTestUser(t, b, "user", "user@example.com")
+	wrong := url.Values{"email": {"user@example.com"}, "password": {"wrong"}}
+	s.post("/user?login", wrong)
+	s.post("/user?login", wrong)
+	resp, body := s.post("/user?login", url.Values{"email": {"user@example.com"}, "password": {"password"}})
+	if resp.StatusCode != http.StatusForbidden || !strings.Contains(body, "the account is locked") {
+		t.Errorf("login of a locked user: %d %s", resp.StatusCode, body)
+	}
+}
+
+func TestLoginLimiter(t *testing.T) {
+	s := newTestServer(t, &HandlerConfig{
+		IPLimiter:    NewMemoryLimiter(100, time.Hour),
+		EmailLimiter: NewMemoryLimiter(2, time.Hour),
+	})
+	addTestUser(t, s.b, "user", "user@example.com")
+	wrong := url.Values{"email": {"User@Example.com"}, "password": {"wrong"}}
+	s.post("/user?login", wrong)
+	s.post("/user?login", wrong)
+	resp, body := s.post("/user?login", url.Values{"email": {"user@example.com"}, "password": {"password"}})
+	if resp.StatusCode != http.StatusTooManyRequests || !strings.Contains(body, "Too many login attempts") {
+		t.Errorf("login over the email limit: %d", resp.StatusCode)
+	}
+
+	s = newTestServer(t, &HandlerConfig{
+		IPLimiter:    NewMemoryLimiter(1, time.Hour),
+		EmailLimiter: NewMemoryLimiter(100, time.Hour),
+	})
+	addTestUser(t, s.b, "user", "user@example.com")
+	s.login("user@example.com")
+	resp, _ = s.post("/user?login", url.Values{"email": {"other@example.com"}, "password": {"password"}})
+	if resp.StatusCode != http.StatusTooManyRequests {
+		t.Errorf("login over the IP limit: %d", resp.StatusCode)
+	}
+}
+
+func TestMemoryLimiter(t *testing.T) {
+	l := NewMemoryLimiter(2, time.Hour)
+	if !l.Allow("a") || !l.Allow("a") {
+		t.Fatal("attempts within the limit")
+	}
+	if l.Allow("a") {
+		t.Error("attempt over the limit")
+	}
+	if !l.Allow("b") {
+		t.Error("keys share the limit")
+	}
+	l.Reset("a")
+	if !l.Allow("a") {
+		t.Error("attempt after Reset")
+	}
+
+	l = NewMemoryLimiter(1, 10*time.Millisecond)
+	l.Allow("a")
+	if l.Allow("a") {
+		t.Error("attempt over the limit")
+	}
+	time.Sleep(20 * time.Millisecond)
+	if !l.Allow("a") {
+		t.Error("attempt in a new window")
+	}
+	l.Allow("b")
+	time.Sleep(20 * time.Millisecond)
+	l.Allow("c")
+	if _, ok := l.keys["b"]; ok {
+		t.Error("finished window is kept")
+	}
+}
+
+func TestClientIP(t *testing.T) {
+	uh := NewHandler(&HandlerConfig{TrustedProxies: []string{"10.0.0.0/8", "192.0.2.1"}})
+	for _, test := range []struct {
+		name      string
+		remote    string
+		forwarded []string
+		realIP    string
+		want      string
+	}{
+		{"direct", "198.51.100.7:1234", nil, "", "198.51.100.7"},
+		{"headers of an untrusted client", "198.51.100.7:1234", []string{"203.0.113.9"}, "203.0.113.8", "198.51.100.7"},
+		{"trusted proxy", "192.0.2.1:1234", []string{"203.0.113.9"}, "", "203.0.113.9"},
+		{"trusted proxy range", "10.1.2.3:1234", []string{"203.0.113.9"}, "", "203.0.113.9"},
+		{"X-Real-IP", "10.1.2.3:1234", nil, "203.0.113.8", "203.0.113.8"},
+		{"bad X-Real-IP", "10.1.2.3:1234", nil, "bad", "10.1.2.3"},
+		{"spoofed first address", "10.1.2.3:1234", []string{"1.1.1.1, 203.0.113.9"}, "", "203.0.113.9"},
+		{"chain of proxies", "10.1.2.3:1234", []string{"203.0.113.9, 10.5.5.5", "192.0.2.1"}, "", "203.0.113.9"},
+		{"bad address", "10.1.2.3:1234", []string{"203.0.113.9, bad"}, "", "10.1.2.3"},
+		{"only proxies", "10.1.2.3:1234", []string{"10.5.5.5"}, "", "10.5.5.5"},
+	} {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.RemoteAddr = test.remote
+		for _, f := range test.forwarded {
+			r.Header.Add("X-Forwarded-For", f)
+		}
+		if test.realIP != "" {
+			r.Header.Set("X-Real-IP", test.realIP)
+		}
+		if ip := uh.clientIP(r); ip != test.want {
+			t.Errorf("%s: %s, want %s", test.name, ip, test.want)
+		}
+	}
+}
@@ -0,0 +1,127 @@
+package baxtep
+
+import (
+	"net/http"
+	"time"
+)
+
+const tokenMagic = "magic"
+
+// SetMagicLinkDuration sets how long magic login links are valid,
+// 15 minutes by default.
+func (b *Baxtep) SetMagicLinkDuration(d time.Duration) {
+	b.magicDuration = d
+}
+
+// RequestMagicLink returns the user with the email and a single-use
+// token for LoginMagicLink. Earlier tokens of the user stop working.
+func (b *Baxtep) RequestMagicLink(email string) (User, string, error) {
+	u, err := b.GetUserByEmail(email)
+	if err != nil {
+		return u, "", err
+	}
+	token, err := b.newToken(u.id, tokenMagic, "", b.magicDuration)
+	return u, token, err
+}
+
+// LoginMagicLink uses up a RequestMagicLink token and returns its user.
+// The link proves the email like a registration confirmation, it does
+// not replace the second factor.
+func (b *Baxtep) LoginMagicLink(token string) (User, error) {
+	u, _, err := b.takeToken(token, tokenMagic)
+	if err != nil {
+		return u, err
+	}
+	if !u.Enable {
+		return u, ErrUserDisabled
+	}
+	return u, nil
+}
+
+func (uh *Handler) magic(w http.ResponseWriter, r *http.Request) {
+	if token := r.URL.Query().Get("magic"); token != "" {
+		uh.magicLogin(w, r, token)
+		return
+	}
+	userdata, v := uh.getFormData(w, r, "email")
+	status := http.StatusOK
+	if r.Method == "POST" {
+		v.required(r, "email")
+		if !v.Valid() {
+			status = http.StatusUnprocessableEntity
+		} else if !uh.allowLogin(r, r.FormValue("email")) {
+			v.Add("", "Too many login attempts, try again later")
+			status = http.StatusTooManyRequests
+		} else {
+			u, token, err := uh.Config.Baxter.RequestMagicLink(r.FormValue("email"))
+			switch {
+			case err == nil && u.Enable:
+				link := uh.link(r, "magic", token)
+				if uh.Config.MagicLink != nil {
+					err = uh.Config.MagicLink(r, u, link)
+				} else {
+					err = uh.sendMail("magic", u.Email, u, map[string]interface{}{"Link": link})
+				}
+			case err == nil, err == ErrUserWithEmailNotFound:
+				// don't tell if the email is registered
+				err = nil
+			}
+			if err != nil {
+				uh.logPrintf("Magic RequestMagicLink error: %s", err)
+				http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+				return
+			}
+			userdata["_Sent"] = true
+		}
+	}
+	uh.magicPage(w, status, userdata)
+}
+
+// magicLogin logs in by a magic link. GET shows a button only, the token
+// is used up by its POST: mail scanners follow links.
+func (uh *Handler) magicLogin(w http.ResponseWriter, r *http.Request, token string) {
+	if r.Method == "POST" {
+		u, err := uh.Config.Baxter.LoginMagicLink(token)
+		switch err {
+		case nil:
+			uh.authenticated(w, r, u)
+		case ErrUserTokenNotFound, ErrUserTokenExpired:
+			http.Error(w, "Bad login link", http.StatusForbidden)
+		case ErrUserDisabled:
+			http.Error(w, "User is disabled", http.StatusForbidden)
+		default:
+			uh.logPrintf("Magic LoginMagicLink error: %s", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	_, _, err := uh.Config.Baxter.checkToken(token, tokenMagic)
+	if err == ErrUserTokenNotFound || err == ErrUserTokenExpired {
+		http.Error(w, "Bad login link", http.StatusForbidden)
+		return
+	}
+	if err != nil {
+		uh.logPrintf("Magic checkToken error: %s", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	uh.magicPage(w, http.StatusOK, map[string]interface{}{"_Token": token, "_CSRF": uh.CSRFToken(w, r)})
+}
+
+func (uh *Handler) magicPage(w http.ResponseWriter, status int, data map[string]interface{}) {
+	w.Header().Set("Content-Type", "text/html")
+	err := uh.checkTemplate()
+	if err != nil {
+		uh.logPrintf("Magic template error: %s", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(status)
+	err = uh.Config.tmpl.ExecuteTemplate(w, "_usermagic", data)
+	if err != nil {
+		uh.logPrintf("Magic template execute error: %s", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+}
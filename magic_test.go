@@ -0,0 +1,65 @@
+package baxtep
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestMagicLink(t *testing.T) {
+	s := newTestServer(t, nil)
+	addTestUser(t, s.b, "user", "user@example.com")
+	s.b.RegisterUser("disabled", "disabled@example.com", "password")
+
+	// don't tell if the email is registered or disabled
+	for _, email := range []string{"nobody@example.com", "disabled@example.com"} {
+		resp, body := s.post("/user?magic", url.Values{"email": {email}})
+		if resp.StatusCode != http.StatusOK || !strings.Contains(body, "If this email is registered") {
+			t.Errorf("magic link for %s: %d %s", email, resp.StatusCode, body)
+		}
+	}
+	if len(s.mail.Messages()) != 0 {
+		t.Error("mail to an unknown or disabled user")
+	}
+	resp, body := s.post("/user?magic", url.Values{"email": {"user@example.com"}})
+	if resp.StatusCode != http.StatusOK || !strings.Contains(body, "If this email is registered") {
+		t.Fatalf("magic link: %d %s", resp.StatusCode, body)
+	}
+	link := sentLink(t, s.mail, "magic")
+
+	// mail scanners follow links
+	for i := 0; i < 2; i++ {
+		if resp, body = s.get(link); resp.StatusCode != http.StatusOK || !strings.Contains(body, `value="Log in"`) {
+			t.Fatalf("GET of the link: %d %s", resp.StatusCode, body)
+		}
+	}
+	if s.cookie("session_id") != "" {
+		t.Fatal("GET of the link logged in")
+	}
+	if resp, _ = s.post(link, nil); resp.StatusCode != http.StatusFound {
+		t.Fatalf("POST of the link: %d", resp.StatusCode)
+	}
+	if _, body = s.get("/user?base"); !strings.Contains(body, "user@example.com") {
+		t.Error("not logged in by the link")
+	}
+	if resp, _ = s.browser().post(link, nil); resp.StatusCode != http.StatusForbidden {
+		t.Errorf("second POST of the link: %d", resp.StatusCode)
+	}
+}
+
+func TestLoginMagicLinkDisabled(t *testing.T) {
+	b := newTestBaxtep(t)
+	u := addTestUser(t, b, "user", "user@example.com")
+	_, token, err := b.RequestMagicLink("user@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	u.SetDisable()
+	if _, err = b.LoginMagicLink(token); err != ErrUserDisabled {
+		t.Errorf("login of a disabled user: %v", err)
+	}
+	if _, _, err = b.RequestMagicLink("nobody@example.com"); err != ErrUserWithEmailNotFound {
+		t.Errorf("unknown email: %v", err)
+	}
+}
@@ -0,0 +1,356 @@
+package baxtep
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"html/template"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net"
+	"net/smtp"
+	"net/textproto"
+	"os"
+	"strings"
+	"sync"
+	texttemplate "text/template"
+	"time"
+)
+
+// Message is an email to a user, HTML is optional.
+type Message struct {
+	To      string
+	Subject string
+	Text    string
+	HTML    string
+}
+
+// Mailer sends registration confirmations, password reset links
+// and other emails to users.
+type Mailer interface {
+	Send(m Message) error
+}
+
+// bytes returns the message in RFC 5322 format.
+func (m Message) bytes(from string) ([]byte, error) {
+	var buf bytes.Buffer
+	if strings.ContainsAny(from+m.To, "\r\n") {
+		return nil, errors.New("line break in mail address")
+	}
+	id := make([]byte, 16)
+	_, err := rand.Read(id)
+	if err != nil {
+		return nil, err
+	}
+	domain := "localhost"
+	if i := strings.LastIndex(from, "@"); i >= 0 {
+		domain = strings.Trim(from[i+1:], ">")
+	}
+	fmt.Fprintf(&buf, "From: %s\r\n", from)
+	fmt.Fprintf(&buf, "To: %s\r\n", m.To)
+	fmt.Fprintf(&buf, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", m.Subject))
+	fmt.Fprintf(&buf, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	fmt.Fprintf(&buf, "Message-ID: <%s@%s>\r\n", hex.EncodeToString(id), domain)
+	fmt.Fprint(&buf, "MIME-Version: 1.0\r\n")
+	if m.HTML == "" {
+		fmt.Fprint(&buf, "Content-Type: text/plain; charset=utf-8\r\n")
+		fmt.Fprint(&buf, "Content-Transfer-Encoding: quoted-printable\r\n\r\n")
+		err = writeQuotedPrintable(&buf, m.Text)
+		return buf.Bytes(), err
+	}
+	mw := multipart.NewWriter(&buf)
+	fmt.Fprintf(&buf, "Content-Type: multipart/alternative; boundary=%s\r\n\r\n", mw.Boundary())
+	for _, part := range []struct{ contentType, body string }{
+		{"text/plain; charset=utf-8", m.Text},
+		{"text/html; charset=utf-8", m.HTML},
+	} {
+		w, err := mw.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {part.contentType},
+			"Content-Transfer-Encoding": {"quoted-printable"},
+		})
+		if err != nil {
+			return nil, err
+		}
+		err = writeQuotedPrintable(w, part.body)
+		if err != nil {
+			return nil, err
+		}
+	}
+	err = mw.Close()
+	return buf.Bytes(), err
+}
+
+func writeQuotedPrintable(w io.Writer, s string) error {
+	qp := quotedprintable.NewWriter(w)
+	_, err := qp.Write([]byte(s))
+	if err != nil {
+		return err
+	}
+	return qp.Close()
+}
+
+// SMTPMailer sends mail through an SMTP server. STARTTLS is used when the
+// server offers it, with RequireTLS mail is not sent in cleartext to a
+// server without it. With ImplicitTLS the connection is TLS from the start
+// (usually port 465). Username and Password enable PLAIN auth.
+type SMTPMailer struct {
+	Addr        string // host:port
+	From        string
+	Username    string
+	Password    string
+	ImplicitTLS bool
+	RequireTLS  bool
+	TLSConfig   *tls.Config
+	// Timeout limits the whole session with the server, 30 seconds
+	// when it is 0
+	Timeout time.Duration
+}
+
+func (s *SMTPMailer) Send(m Message) error {
+	host, _, err := net.SplitHostPort(s.Addr)
+	if err != nil {
+		return err
+	}
+	tlsConfig := s.TLSConfig
+	if tlsConfig == nil {
+		tlsConfig = &tls.Config{ServerName: host}
+	}
+	timeout := s.Timeout
+	if timeout == 0 {
+		timeout = 30 * time.Second
+	}
+	dialer := &net.Dialer{Timeout: timeout}
+	var conn net.Conn
+	if s.ImplicitTLS {
+		conn, err = tls.DialWithDialer(dialer, "tcp", s.Addr, tlsConfig)
+	} else {
+		conn, err = dialer.Dial("tcp", s.Addr)
+	}
+	if err != nil {
+		return err
+	}
+	// a stalled server must not hang the request that sends the mail
+	err = conn.SetDeadline(time.Now().Add(timeout))
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	c, err := smtp.NewClient(conn, host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer c.Close()
+	if !s.ImplicitTLS {
+		ok, _ := c.Extension("STARTTLS")
+		if ok {
+			err = c.StartTLS(tlsConfig)
+			if err != nil {
+				return err
+			}
+		} else if s.RequireTLS {
+			return ErrMailNoTLS
+		}
+	}
+	if s.Username != "" {
+		err = c.Auth(smtp.PlainAuth("", s.Username, s.Password, host))
+		if err != nil {
+			return err
+		}
+	}
+	msg, err := m.bytes(s.From)
+	if err != nil {
+		return err
+	}
+	err = c.Mail(addressOnly(s.From))
+	if err != nil {
+		return err
+	}
+	err = c.Rcpt(addressOnly(m.To))
+	if err != nil {
+		return err
+	}
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(msg)
+	if err != nil {
+		return err
+	}
+	err = w.Close()
+	if err != nil {
+		return err
+	}
+	return c.Quit()
+}
+
+// addressOnly returns "user@host" of "Name <user@host>".
+func addressOnly(addr string) string {
+	if i := strings.LastIndex(addr, "<"); i >= 0 {
+		return strings.TrimSuffix(addr[i+1:], ">")
+	}
+	return addr
+}
+
+// MboxMailer appends messages to an mbox file instead of sending them,
+// for development.
+type MboxMailer struct {
+	Path string
+	From string
+	mu   sync.Mutex
+}
+
+func (s *MboxMailer) Send(m Message) error {
+	msg, err := m.bytes(s.From)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	f, err := os.OpenFile(s.Path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	// mbox: "From " lines in the body are escaped, CRLF becomes LF
+	body := strings.Replace(string(msg), "\r\n", "\n", -1)
+	body = strings.Replace(body, "\nFrom ", "\n>From ", -1)
+	_, err = fmt.Fprintf(f, "From %s %s\n%s\n\n", addressOnly(s.From), time.Now().UTC().Format(time.ANSIC), body)
+	return err
+}
+
+// RecordingMailer keeps sent messages in memory, for tests.
+type RecordingMailer struct {
+	mu       sync.Mutex
+	messages []Message
+}
+
+func (s *RecordingMailer) Send(m Message) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.messages = append(s.messages, m)
+	return nil
+}
+
+// Messages returns messages sent so far.
+func (s *RecordingMailer) Messages() []Message {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Message(nil), s.messages...)
+}
+
+// Last returns the last sent message.
+func (s *RecordingMailer) Last() (Message, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.messages) == 0 {
+		return Message{}, false
+	}
+	return s.messages[len(s.messages)-1], true
+}
+
+// mail sends m with the configured Mailer.
+func (uh *Handler) mail(m Message) error {
+	if uh.Config.Mailer == nil {
+		return errors.New("no Mailer in HandlerConfig")
+	}
+	return uh.Config.Mailer.Send(m)
+}
+
+// SetCustomMailTemplate replaces the default mail templates, nil keeps
+// the default. See defaultMailTemplate for the template names.
+func (uh *Handler) SetCustomMailTemplate(text *texttemplate.Template, html *template.Template) {
+	if text != nil {
+		uh.Config.mailText = text
+	}
+	if html != nil {
+		uh.Config.mailHTML = html
+	}
+}
+
+func (uh *Handler) checkMailTemplate() error {
+	var err error
+	if uh.Config.mailText == nil {
+		uh.Config.mailText, err = texttemplate.New("_UserMail").Parse(defaultMailTemplate)
+		if err != nil {
+			return err
+		}
+	}
+	if uh.Config.mailHTML == nil {
+		uh.Config.mailHTML, err = template.New("_UserMailHTML").Parse(defaultMailHTMLTemplate)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// localized returns the name of the template for locale, "ru-RU" falls
+// back to "ru" and then to the name without suffix.
+func localized(lookup func(string) bool, name, locale string) string {
+	for locale != "" {
+		if lookup(name + "." + locale) {
+			return name + "." + locale
+		}
+		i := strings.LastIndexAny(locale, "-_")
+		if i < 0 {
+			break
+		}
+		locale = locale[:i]
+	}
+	return name
+}
+
+// mailMessage renders the "_mail<kind>" templates in the user's locale.
+// data gets User and may have Link, Email and OldEmail.
+func (uh *Handler) mailMessage(kind, to string, u User, data map[string]interface{}) (Message, error) {
+	m := Message{To: to}
+	err := uh.checkMailTemplate()
+	if err != nil {
+		return m, err
+	}
+	locale, err := u.GetLocale()
+	if err != nil {
+		return m, err
+	}
+	data["User"] = u
+	textLookup := func(name string) bool { return uh.Config.mailText.Lookup(name) != nil }
+	htmlLookup := func(name string) bool { return uh.Config.mailHTML.Lookup(name) != nil }
+
+	var buf bytes.Buffer
+	err = uh.Config.mailText.ExecuteTemplate(&buf, localized(textLookup, "_mail"+kind+"_subject", locale), data)
+	if err != nil {
+		return m, err
+	}
+	m.Subject = strings.TrimSpace(buf.String())
+	buf.Reset()
+	err = uh.Config.mailText.ExecuteTemplate(&buf, localized(textLookup, "_mail"+kind+"_text", locale), data)
+	if err != nil {
+		return m, err
+	}
+	m.Text = buf.String()
+	if name := localized(htmlLookup, "_mail"+kind+"_html", locale); htmlLookup(name) {
+		buf.Reset()
+		err = uh.Config.mailHTML.ExecuteTemplate(&buf, name, data)
+		if err != nil {
+			return m, err
+		}
+		m.HTML = buf.String()
+	}
+	return m, nil
+}
+
+// sendMail renders and sends the "_mail<kind>" message.
+func (uh *Handler) sendMail(kind, to string, u User, data map[string]interface{}) error {
+	m, err := uh.mailMessage(kind, to, u, data)
+	if err != nil {
+		return err
+	}
+	return uh.mail(m)
+}
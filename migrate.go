@@ -0,0 +1,83 @@
+package baxtep
+
+import (
+	"context"
+	"database/sql"
+	"strconv"
+)
+
+// migration is one schema version: statements applied together in one
+// transaction. Slices of migrations are ordered, version N is at index N-1.
+type migration []string
+
+// metaQueries are the per-driver statements for the meta table holding the
+// applied schema version under the name "db_version".
+type metaQueries struct {
+	create string
+	get    string
+	insert string
+	update string
+}
+
+func schemaVersion(conn *sql.DB, meta metaQueries) (int, error) {
+	var version string
+	err := conn.QueryRow(meta.get, "db_version").Scan(&version)
+	if err == sql.ErrNoRows {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	return strconv.Atoi(version)
+}
+
+func migrate(ctx context.Context, conn *sql.DB, meta metaQueries, migrations []migration) error {
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	_, err = tx.ExecContext(ctx, meta.create)
+	if err != nil {
+		return err
+	}
+	err = tx.Commit()
+	if err != nil {
+		return err
+	}
+
+	version, err := schemaVersion(conn, meta)
+	if err != nil {
+		return err
+	}
+	for ; version < len(migrations); version++ {
+		err = migrateStep(ctx, conn, meta, version+1, migrations[version])
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func migrateStep(ctx context.Context, conn *sql.DB, meta metaQueries, version int, m migration) error {
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	for i := range m {
+		_, err = tx.ExecContext(ctx, m[i])
+		if err != nil {
+			return err
+		}
+	}
+	if version == 1 {
+		_, err = tx.ExecContext(ctx, meta.insert, "db_version", strconv.Itoa(version))
+	} else {
+		_, err = tx.ExecContext(ctx, meta.update, strconv.Itoa(version), "db_version")
+	}
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
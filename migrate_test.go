@@ -0,0 +1,92 @@
+package baxtep
+
+import (
+	"context"
+	"database/sql"
+	"path/filepath"
+	"testing"
+)
+
+func TestMigrate(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "baxtep.db")
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := NewSQLiteStore(db, "user").(*sqliteStore)
+	migrations := s.migrations()
+	if version, _ := s.SchemaVersion(); version != 0 {
+		t.Errorf("version of an empty database: %d", version)
+	}
+
+	// a deployment upgrading at every version
+	for n := 1; n <= len(migrations); n++ {
+		err = migrate(ctx, db, s.meta(), migrations[:n])
+		if err != nil {
+			t.Fatalf("migration %d: %s", n, err)
+		}
+		if version, err := s.SchemaVersion(); err != nil || version != n {
+			t.Fatalf("after migration %d: version %d, %v", n, version, err)
+		}
+		if n == 1 {
+			// users of the old schema: logged in, pending confirmation
+			// and with a role param
+			for _, q := range []string{
+				"INSERT INTO `user` (`name`, `email`, `enable`, `session_id`, `session_time`) VALUES ('active', 'active@example.com', 1, 'session', '2020-01-01 00:00:00')",
+				"INSERT INTO `user` (`name`, `email`, `enable`, `session_id`) VALUES ('pending', 'pending@example.com', 0, 'confirm')",
+				"INSERT INTO `user_param` (`user_id`, `key`, `val`) VALUES (1, 'role', 'admin')",
+			} {
+				if _, err = db.Exec(q); err != nil {
+					t.Fatal(err)
+				}
+			}
+		}
+	}
+
+	if _, err = s.GetUserByConfirm("session"); err != sql.ErrNoRows {
+		t.Errorf("old session ID is a confirmation code: %v", err)
+	}
+	if d, err := s.GetUserByConfirm("confirm"); err != nil || d.Name != "pending" {
+		t.Errorf("pending confirmation: %+v, %v", d, err)
+	}
+	if roles, err := s.GetUserRoles(1); err != nil || len(roles) != 1 || roles[0] != "admin" {
+		t.Errorf("role param is not a role: %v, %v", roles, err)
+	}
+	db.Close()
+
+	// ALTER TABLE of a migration fails when it runs again
+	db, err = sql.Open("sqlite", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	s = NewSQLiteStore(db, "user").(*sqliteStore)
+	for i := 0; i < 2; i++ {
+		err = s.Migrate(ctx)
+		if err != nil {
+			t.Fatalf("migration of an up to date schema: %s", err)
+		}
+	}
+	if version, err := s.SchemaVersion(); err != nil || version != len(migrations) {
+		t.Errorf("version after reopen: %d, %v", version, err)
+	}
+	if d, err := s.GetUserByName("active"); err != nil || !d.Enable {
+		t.Errorf("user after reopen: %+v, %v", d, err)
+	}
+
+	// a failed migration leaves neither its tables nor its version
+	bad := append(migrations, migration{
+		"CREATE TABLE `user_new` (`id` integer)",
+		"bad statement",
+	})
+	if err = migrate(ctx, db, s.meta(), bad); err == nil {
+		t.Fatal("bad migration is applied")
+	}
+	if version, _ := s.SchemaVersion(); version != len(migrations) {
+		t.Errorf("version after a failed migration: %d", version)
+	}
+	if _, err = db.Exec("SELECT * FROM `user_new`"); err == nil {
+		t.Error("table of a failed migration exists")
+	}
+}
@@ -1,8 +1,6 @@
 package baxtep
 
 import (
-	"math/rand"
-	"time"
 	"crypto/sha256"
 	"encoding/hex"
 	"errors"
@@ -19,22 +17,34 @@ var (
 	ErrUserEmailExist        = errors.New("this email exist")
 	ErrUserSessionNotFound   = errors.New("user session not found")
 	ErrUserSessionExpired    = errors.New("user session expired")
+	ErrUserTokenNotFound     = errors.New("token not found")
+	ErrUserTokenExpired      = errors.New("token expired")
+	ErrUserLocked            = errors.New("user temporarily locked")
+	ErrUserBadCode           = errors.New("bad one-time code")
+	ErrNoSecretKey           = errors.New("no secret key, see Baxtep.SetSecretKey")
+	ErrUserPasskeyNotFound   = errors.New("passkey not found")
+	ErrUserBadPasskey        = errors.New("bad passkey")
+	ErrGroupNotFound         = errors.New("group not found")
+	ErrGroupNameExist        = errors.New("this group name exist")
+	ErrOrgNotFound           = errors.New("organization not found")
+	ErrOrgNameExist          = errors.New("this organization name exist")
+	ErrUserNotInOrg          = errors.New("user is not a member of the organization")
+	ErrMailNoTLS             = errors.New("SMTP server does not offer STARTTLS")
 )
 
-var passwordRunes = []rune("abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789_-!$")
-
-func generateRandomString(lenght int) string {
-	b := make([]rune, lenght)
-	rnd := rand.New(rand.NewSource(time.Now().UnixNano()))
-	for i := range b {
-		b[i] = passwordRunes[rnd.Intn(len(passwordRunes))]
-	}
-	return string(b)
-}
-
+// getPasswordHash is the legacy unsalted SHA-256 password hash,
+// kept only to verify and upgrade old rows.
 func getPasswordHash(password string) string {
 	hash := sha256.New()
 	hash.Write([]byte(password))
 	return hex.EncodeToString(hash.Sum(nil))
 }
 
+func contains(list []string, s string) bool {
+	for i := range list {
+		if list[i] == s {
+			return true
+		}
+	}
+	return false
+}
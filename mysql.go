@@ -0,0 +1,880 @@
+package baxtep
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+)
+
+type mysqlStore struct {
+	conn   *sql.DB
+	prefix string
+}
+
+// NewMySQLStore returns a Store for MySQL and TiDB.
+// The connection must be opened with parseTime=true.
+func NewMySQLStore(db *sql.DB, prefix string) Store {
+	return &mysqlStore{conn: db, prefix: prefix}
+}
+
+func (s *mysqlStore) Migrate(ctx context.Context) error {
+	return migrate(ctx, s.conn, s.meta(), s.migrations())
+}
+
+func (s *mysqlStore) SchemaVersion() (int, error) {
+	return schemaVersion(s.conn, s.meta())
+}
+
+func (s *mysqlStore) meta() metaQueries {
+	return metaQueries{
+		create: "CREATE TABLE IF NOT EXISTS `" + s.prefix + "_meta` (" +
+			" `name` varchar(100) NOT NULL," +
+			" `value` varchar(250) NOT NULL," +
+			" PRIMARY KEY (name)) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;",
+		get:    "SELECT `value` FROM `" + s.prefix + "_meta` WHERE `name`=?",
+		insert: "INSERT INTO `" + s.prefix + "_meta` (`name`, `value`) VALUES (?, ?)",
+		update: "UPDATE `" + s.prefix + "_meta` SET `value`=? WHERE `name`=?",
+	}
+}
+
+// migrations for MySQL, note that MySQL commits DDL statements implicitly.
+func (s *mysqlStore) migrations() []migration {
+	return []migration{
+		// 1: users and params
+		{
+			"SET SQL_MODE = \"NO_AUTO_VALUE_ON_ZERO\";",
+			"SET time_zone = \"+00:00\";",
+			fmt.Sprintf(
+				"CREATE TABLE IF NOT EXISTS `%s` ("+
+					" `id` int(11) NOT NULL AUTO_INCREMENT,"+
+					" `name` varchar(100) NOT NULL,"+
+					" `password` varchar(64) NOT NULL,"+
+					" `email` varchar(100) NOT NULL,"+
+					" `registration_time` timestamp NOT NULL DEFAULT '0000-00-00 00:00:00',"+
+					" `enable` tinyint(1) NOT NULL,"+
+					" `session_id` varchar(64) NOT NULL,"+
+					" `session_time` timestamp NOT NULL DEFAULT '0000-00-00 00:00:00',"+
+					" PRIMARY KEY (id)) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;",
+				s.prefix),
+			fmt.Sprintf("CREATE TABLE IF NOT EXISTS `%s_param` ("+
+				" `id` int(11) NOT NULL AUTO_INCREMENT,"+
+				" `user_id` int(11) NOT NULL,"+
+				" `key` varchar(100) NOT NULL,"+
+				" `val` varchar(250) NOT NULL,"+
+				" PRIMARY KEY (id)) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;",
+				s.prefix),
+		},
+		// 2: room for PasswordHasher hashes
+		{
+			"ALTER TABLE `" + s.prefix + "` MODIFY `password` varchar(255) NOT NULL DEFAULT ''",
+		},
+		// 3: sessions move to their own table, session_id stays
+		// for registration confirmation codes only
+		{
+			"CREATE TABLE IF NOT EXISTS `" + s.prefix + "_session` (" +
+				" `id` varchar(64) NOT NULL," +
+				" `user_id` int(11) NOT NULL," +
+				" `created` timestamp NULL," +
+				" `last_seen` timestamp NULL," +
+				" `user_agent` varchar(250) NOT NULL DEFAULT ''," +
+				" `ip` varchar(45) NOT NULL DEFAULT ''," +
+				" PRIMARY KEY (id), KEY `user_id` (`user_id`)) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;",
+			"UPDATE `" + s.prefix + "` SET `session_id`='' WHERE `enable`=1 OR `session_time`<>'0000-00-00 00:00:00'",
+		},
+		// 4: single-use tokens
+		{
+			"CREATE TABLE IF NOT EXISTS `" + s.prefix + "_token` (" +
+				" `id` varchar(64) NOT NULL," +
+				" `user_id` int(11) NOT NULL," +
+				" `kind` varchar(20) NOT NULL," +
+				" `expires` timestamp NULL," +
+				" PRIMARY KEY (id), KEY `user_id` (`user_id`)) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;",
+		},
+		// 5: token payload
+		{
+			"ALTER TABLE `" + s.prefix + "_token` ADD COLUMN `data` varchar(250) NOT NULL DEFAULT ''",
+		},
+		// 6: failed logins and lockout, locked_until is unix time
+		{
+			"ALTER TABLE `" + s.prefix + "` ADD COLUMN `failed_logins` int(11) NOT NULL DEFAULT 0," +
+				" ADD COLUMN `locked_until` bigint NOT NULL DEFAULT 0",
+		},
+		// 7: encrypted TOTP secret and the last used time step
+		{
+			"ALTER TABLE `" + s.prefix + "` ADD COLUMN `totp_secret` varchar(255) NOT NULL DEFAULT ''," +
+				" ADD COLUMN `totp_step` bigint NOT NULL DEFAULT 0",
+		},
+		// 8: 2FA recovery codes
+		{
+			"CREATE TABLE IF NOT EXISTS `" + s.prefix + "_recovery` (" +
+				" `id` varchar(64) NOT NULL," +
+				" `user_id` int(11) NOT NULL," +
+				" PRIMARY KEY (id), KEY `user_id` (`user_id`)) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;",
+		},
+		// 9: WebAuthn credentials, id is the base64url credential ID
+		{
+			"CREATE TABLE IF NOT EXISTS `" + s.prefix + "_credential` (" +
+				" `id` varchar(255) NOT NULL," +
+				" `user_id` int(11) NOT NULL," +
+				" `name` varchar(100) NOT NULL DEFAULT ''," +
+				" `public_key` text NOT NULL," +
+				" `sign_count` bigint NOT NULL DEFAULT 0," +
+				" `created` timestamp NULL," +
+				" `last_used` timestamp NULL," +
+				" PRIMARY KEY (id), KEY `user_id` (`user_id`)) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;",
+		},
+		// 10: roles of users and permissions of roles, "role" params become roles
+		{
+			"CREATE TABLE IF NOT EXISTS `" + s.prefix + "_role_permission` (" +
+				" `role` varchar(100) NOT NULL," +
+				" `permission` varchar(100) NOT NULL," +
+				" PRIMARY KEY (`role`, `permission`)) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;",
+			"CREATE TABLE IF NOT EXISTS `" + s.prefix + "_user_role` (" +
+				" `user_id` int(11) NOT NULL," +
+				" `role` varchar(100) NOT NULL," +
+				" PRIMARY KEY (`user_id`, `role`)) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;",
+			"INSERT INTO `" + s.prefix + "_user_role` (`user_id`, `role`)" +
+				" SELECT DISTINCT `user_id`, `val` FROM `" + s.prefix + "_param` WHERE `key`='role' AND CHAR_LENGTH(`val`) <= 100;",
+		},
+		// 11: groups of users with their params and roles
+		{
+			"CREATE TABLE IF NOT EXISTS `" + s.prefix + "_group` (" +
+				" `id` int(11) NOT NULL AUTO_INCREMENT," +
+				" `name` varchar(100) NOT NULL," +
+				" `created` timestamp NULL," +
+				" PRIMARY KEY (id), UNIQUE KEY `name` (`name`)) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;",
+			"CREATE TABLE IF NOT EXISTS `" + s.prefix + "_group_member` (" +
+				" `group_id` int(11) NOT NULL," +
+				" `user_id` int(11) NOT NULL," +
+				" PRIMARY KEY (`group_id`, `user_id`), KEY `user_id` (`user_id`)) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;",
+			"CREATE TABLE IF NOT EXISTS `" + s.prefix + "_group_param` (" +
+				" `id` int(11) NOT NULL AUTO_INCREMENT," +
+				" `group_id` int(11) NOT NULL," +
+				" `key` varchar(100) NOT NULL," +
+				" `val` varchar(250) NOT NULL," +
+				" PRIMARY KEY (id), KEY `group_id` (`group_id`)) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;",
+			"CREATE TABLE IF NOT EXISTS `" + s.prefix + "_group_role` (" +
+				" `group_id` int(11) NOT NULL," +
+				" `role` varchar(100) NOT NULL," +
+				" PRIMARY KEY (`group_id`, `role`)) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;",
+		},
+		// 12: organizations with per-org roles and params, the active org of sessions
+		{
+			"CREATE TABLE IF NOT EXISTS `" + s.prefix + "_org` (" +
+				" `id` int(11) NOT NULL AUTO_INCREMENT," +
+				" `name` varchar(100) NOT NULL," +
+				" `created` timestamp NULL," +
+				" PRIMARY KEY (id), UNIQUE KEY `name` (`name`)) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;",
+			"CREATE TABLE IF NOT EXISTS `" + s.prefix + "_org_member` (" +
+				" `org_id` int(11) NOT NULL," +
+				" `user_id` int(11) NOT NULL," +
+				" PRIMARY KEY (`org_id`, `user_id`), KEY `user_id` (`user_id`)) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;",
+			"CREATE TABLE IF NOT EXISTS `" + s.prefix + "_org_role` (" +
+				" `org_id` int(11) NOT NULL," +
+				" `user_id` int(11) NOT NULL," +
+				" `role` varchar(100) NOT NULL," +
+				" PRIMARY KEY (`org_id`, `user_id`, `role`)) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;",
+			"CREATE TABLE IF NOT EXISTS `" + s.prefix + "_org_param` (" +
+				" `id` int(11) NOT NULL AUTO_INCREMENT," +
+				" `org_id` int(11) NOT NULL," +
+				" `user_id` int(11) NOT NULL," +
+				" `key` varchar(100) NOT NULL," +
+				" `val` varchar(250) NOT NULL," +
+				" PRIMARY KEY (id), KEY `org_user` (`org_id`, `user_id`)) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;",
+			"ALTER TABLE `" + s.prefix + "_session` ADD COLUMN `org_id` int(11) NOT NULL DEFAULT 0",
+		},
+	}
+}
+
+func (s *mysqlStore) exec(query string, args ...interface{}) error {
+	tx, err := s.conn.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	_, err = tx.Exec(query, args...)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// replace runs del and ins with the same arguments in a transaction,
+// so that adding a row twice keeps one.
+func (s *mysqlStore) replace(del, ins string, args ...interface{}) error {
+	tx, err := s.conn.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	_, err = tx.Exec(del, args...)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(ins, args...)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s *mysqlStore) AddUser(name, email, passhash, confirm string, registered time.Time) (int64, error) {
+	tx, err := s.conn.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+	res, err := tx.Exec("INSERT INTO `"+s.prefix+"`(`name`, `password`, `email`, `registration_time`, `session_id`, `enable`) VALUES (?, ?, ?, ?, ?, FALSE)", name, passhash, email, registered, confirm)
+	if err != nil {
+		return 0, err
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+	return id, tx.Commit()
+}
+
+func (s *mysqlStore) DeleteUser(id int64) error {
+	tx, err := s.conn.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	for _, table := range []string{"_param", "_org_member", "_org_role", "_org_param"} {
+		_, err = tx.Exec("DELETE FROM `"+s.prefix+table+"` WHERE `user_id`=?", id)
+		if err != nil {
+			return err
+		}
+	}
+	_, err = tx.Exec("DELETE FROM `"+s.prefix+"_group_member` WHERE `user_id`=?", id)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec("DELETE FROM `"+s.prefix+"_token` WHERE `user_id`=?", id)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec("DELETE FROM `"+s.prefix+"_user_role` WHERE `user_id`=?", id)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec("DELETE FROM `"+s.prefix+"_credential` WHERE `user_id`=?", id)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec("DELETE FROM `"+s.prefix+"_recovery` WHERE `user_id`=?", id)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec("DELETE FROM `"+s.prefix+"_session` WHERE `user_id`=?", id)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec("DELETE FROM `"+s.prefix+"` WHERE `id`=?", id)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s *mysqlStore) getUser(where string, arg interface{}) (UserData, error) {
+	var u UserData
+	err := s.conn.QueryRow("SELECT `id`, `name`, `email`, `enable` FROM `"+s.prefix+"` WHERE "+where, arg).Scan(&u.ID, &u.Name, &u.Email, &u.Enable)
+	return u, err
+}
+
+func (s *mysqlStore) GetUserByID(id int64) (UserData, error) {
+	return s.getUser("`id`=?", id)
+}
+
+func (s *mysqlStore) GetUserByName(name string) (UserData, error) {
+	return s.getUser("`name`=?", name)
+}
+
+func (s *mysqlStore) GetUserByEmail(email string) (UserData, error) {
+	return s.getUser("`email`=?", email)
+}
+
+func (s *mysqlStore) GetUserByConfirm(confirm string) (UserData, error) {
+	return s.getUser("`session_id`=?", confirm)
+}
+
+func (s *mysqlStore) CountUserName(name string) (int64, error) {
+	var count int64
+	err := s.conn.QueryRow("SELECT COUNT(*) FROM `"+s.prefix+"` WHERE `name`=?", name).Scan(&count)
+	return count, err
+}
+
+func (s *mysqlStore) CountUserEmail(email string) (int64, error) {
+	var count int64
+	err := s.conn.QueryRow("SELECT COUNT(*) FROM `"+s.prefix+"` WHERE `email`=?", email).Scan(&count)
+	return count, err
+}
+
+func (s *mysqlStore) SetUserEnable(id int64, enable bool) error {
+	return s.exec("UPDATE `"+s.prefix+"` SET `enable`=? WHERE `id`=?", enable, id)
+}
+
+func (s *mysqlStore) SetUserEmail(id int64, email string) error {
+	return s.exec("UPDATE `"+s.prefix+"` SET `email`=? WHERE `id`=?", email, id)
+}
+
+func (s *mysqlStore) GetUserPassword(id int64) (string, error) {
+	var passhash string
+	err := s.conn.QueryRow("SELECT `password` FROM `"+s.prefix+"` WHERE `id`=?", id).Scan(&passhash)
+	return passhash, err
+}
+
+func (s *mysqlStore) SetUserPassword(id int64, passhash string) error {
+	return s.exec("UPDATE `"+s.prefix+"` SET `password`=? WHERE `id`=?", passhash, id)
+}
+
+func (s *mysqlStore) GetUserLock(id int64) (int, time.Time, error) {
+	var failures int
+	var until int64
+	err := s.conn.QueryRow("SELECT `failed_logins`, `locked_until` FROM `"+s.prefix+"` WHERE `id`=?", id).Scan(&failures, &until)
+	return failures, time.Unix(until, 0).UTC(), err
+}
+
+func (s *mysqlStore) AddUserFailure(id int64) (int, error) {
+	var failures int
+	tx, err := s.conn.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+	_, err = tx.Exec("UPDATE `"+s.prefix+"` SET `failed_logins`=`failed_logins`+1 WHERE `id`=?", id)
+	if err != nil {
+		return 0, err
+	}
+	err = tx.QueryRow("SELECT `failed_logins` FROM `"+s.prefix+"` WHERE `id`=?", id).Scan(&failures)
+	if err != nil {
+		return 0, err
+	}
+	return failures, tx.Commit()
+}
+
+func (s *mysqlStore) SetUserLock(id int64, failures int, until time.Time) error {
+	return s.exec("UPDATE `"+s.prefix+"` SET `failed_logins`=?, `locked_until`=? WHERE `id`=?", failures, until.Unix(), id)
+}
+
+func (s *mysqlStore) GetUserTOTP(id int64) (string, int64, error) {
+	var secret string
+	var step int64
+	err := s.conn.QueryRow("SELECT `totp_secret`, `totp_step` FROM `"+s.prefix+"` WHERE `id`=?", id).Scan(&secret, &step)
+	return secret, step, err
+}
+
+func (s *mysqlStore) SetUserTOTP(id int64, secret string, step int64) error {
+	return s.exec("UPDATE `"+s.prefix+"` SET `totp_secret`=?, `totp_step`=? WHERE `id`=?", secret, step, id)
+}
+
+func (s *mysqlStore) SetUserConfirm(id int64, confirm string) error {
+	return s.exec("UPDATE `"+s.prefix+"` SET `session_id`=? WHERE `id`=?", confirm, id)
+}
+
+func (s *mysqlStore) AddSession(session Session) error {
+	return s.exec("INSERT INTO `"+s.prefix+"_session` (`id`, `user_id`, `created`, `last_seen`, `user_agent`, `ip`, `org_id`) VALUES (?, ?, ?, ?, ?, ?, ?)",
+		session.ID, session.UserID, session.Created, session.LastSeen, session.UserAgent, session.IP, session.OrgID)
+}
+
+func (s *mysqlStore) GetSession(id string) (Session, error) {
+	var session Session
+	err := s.conn.QueryRow("SELECT `id`, `user_id`, `created`, `last_seen`, `user_agent`, `ip`, `org_id` FROM `"+s.prefix+"_session` WHERE `id`=?", id).
+		Scan(&session.ID, &session.UserID, &session.Created, &session.LastSeen, &session.UserAgent, &session.IP, &session.OrgID)
+	return session, err
+}
+
+func (s *mysqlStore) GetSessions(userID int64) ([]Session, error) {
+	rows, err := s.conn.Query("SELECT `id`, `user_id`, `created`, `last_seen`, `user_agent`, `ip`, `org_id` FROM `"+s.prefix+"_session` WHERE `user_id`=? ORDER BY `created`", userID)
+	if err != nil {
+		return nil, err
+	}
+	return scanSessions(rows)
+}
+
+func (s *mysqlStore) TouchSession(id string, t time.Time) error {
+	return s.exec("UPDATE `"+s.prefix+"_session` SET `last_seen`=? WHERE `id`=?", t, id)
+}
+
+func (s *mysqlStore) DeleteSession(userID int64, id string) error {
+	return s.exec("DELETE FROM `"+s.prefix+"_session` WHERE `user_id`=? AND `id`=?", userID, id)
+}
+
+func (s *mysqlStore) DeleteSessions(userID int64) error {
+	return s.exec("DELETE FROM `"+s.prefix+"_session` WHERE `user_id`=?", userID)
+}
+
+func (s *mysqlStore) SetSessionOrg(id string, orgID int64) error {
+	return s.exec("UPDATE `"+s.prefix+"_session` SET `org_id`=? WHERE `id`=?", orgID, id)
+}
+
+func (s *mysqlStore) AddParams(userID int64, params ...map[string]string) error {
+	tx, err := s.conn.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	for i := range params {
+		for k, v := range params[i] {
+			_, err = tx.Exec("INSERT INTO `"+s.prefix+"_param` (`user_id`, `key`, `val`) VALUES (?, ?, ?)", userID, k, v)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return tx.Commit()
+}
+
+func (s *mysqlStore) HasParam(userID int64, key string) (bool, error) {
+	var cnt int64
+	err := s.conn.QueryRow("SELECT COUNT(*) FROM `"+s.prefix+"_param` WHERE `user_id`=? AND `key`=?", userID, key).Scan(&cnt)
+	return cnt > 0, err
+}
+
+func (s *mysqlStore) HasParamValue(userID int64, key, value string) (bool, error) {
+	var cnt int64
+	err := s.conn.QueryRow("SELECT COUNT(*) FROM `"+s.prefix+"_param` WHERE `user_id`=? AND `key`=? AND `val`=?", userID, key, value).Scan(&cnt)
+	return cnt > 0, err
+}
+
+func (s *mysqlStore) GetParam(userID int64, key string) ([]string, error) {
+	rows, err := s.conn.Query("SELECT `val` FROM `"+s.prefix+"_param` WHERE `user_id`=? AND `key`=?", userID, key)
+	if err != nil {
+		return nil, err
+	}
+	return scanParam(rows)
+}
+
+func (s *mysqlStore) GetParams(userID int64) (map[string][]string, error) {
+	rows, err := s.conn.Query("SELECT `key`, `val` FROM `"+s.prefix+"_param` WHERE `user_id`=?", userID)
+	if err != nil {
+		return map[string][]string{}, err
+	}
+	return scanParams(rows)
+}
+
+func (s *mysqlStore) DeleteParams(userID int64, keys ...string) error {
+	if len(keys) == 0 {
+		return nil
+	}
+	params := []interface{}{userID}
+	for i := range keys {
+		params = append(params, keys[i])
+	}
+	placeholders := strings.TrimLeft(strings.Repeat(", ?", len(keys)), ", ")
+	return s.exec("DELETE FROM `"+s.prefix+"_param` WHERE `user_id`=? AND `key` IN ("+placeholders+")", params...)
+}
+
+func (s *mysqlStore) AddToken(t Token) error {
+	return s.exec("INSERT INTO `"+s.prefix+"_token` (`id`, `user_id`, `kind`, `expires`, `data`) VALUES (?, ?, ?, ?, ?)", t.ID, t.UserID, t.Kind, t.Expires, t.Data)
+}
+
+func (s *mysqlStore) GetToken(id, kind string) (Token, error) {
+	var t Token
+	err := s.conn.QueryRow("SELECT `id`, `user_id`, `kind`, `expires`, `data` FROM `"+s.prefix+"_token` WHERE `id`=? AND `kind`=?", id, kind).
+		Scan(&t.ID, &t.UserID, &t.Kind, &t.Expires, &t.Data)
+	return t, err
+}
+
+func (s *mysqlStore) TakeToken(id, kind string) (Token, error) {
+	var t Token
+	tx, err := s.conn.Begin()
+	if err != nil {
+		return t, err
+	}
+	defer tx.Rollback()
+	err = tx.QueryRow("SELECT `id`, `user_id`, `kind`, `expires`, `data` FROM `"+s.prefix+"_token` WHERE `id`=? AND `kind`=?", id, kind).
+		Scan(&t.ID, &t.UserID, &t.Kind, &t.Expires, &t.Data)
+	if err != nil {
+		return t, err
+	}
+	res, err := tx.Exec("DELETE FROM `"+s.prefix+"_token` WHERE `id`=?", id)
+	if err != nil {
+		return t, err
+	}
+	if n, err := res.RowsAffected(); err != nil || n != 1 {
+		return t, sql.ErrNoRows
+	}
+	return t, tx.Commit()
+}
+
+func (s *mysqlStore) DeleteTokens(userID int64, kind string) error {
+	return s.exec("DELETE FROM `"+s.prefix+"_token` WHERE `user_id`=? AND `kind`=?", userID, kind)
+}
+
+func (s *mysqlStore) DeleteExpiredTokens(kind string, now time.Time) error {
+	return s.exec("DELETE FROM `"+s.prefix+"_token` WHERE `kind`=? AND `expires`<?", kind, now)
+}
+
+func (s *mysqlStore) SetRecoveryCodes(userID int64, ids []string) error {
+	tx, err := s.conn.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	_, err = tx.Exec("DELETE FROM `"+s.prefix+"_recovery` WHERE `user_id`=?", userID)
+	if err != nil {
+		return err
+	}
+	for i := range ids {
+		_, err = tx.Exec("INSERT INTO `"+s.prefix+"_recovery` (`id`, `user_id`) VALUES (?, ?)", ids[i], userID)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func (s *mysqlStore) TakeRecoveryCode(userID int64, id string) error {
+	res, err := s.conn.Exec("DELETE FROM `"+s.prefix+"_recovery` WHERE `id`=? AND `user_id`=?", id, userID)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil || n != 1 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+func (s *mysqlStore) CountRecoveryCodes(userID int64) (int, error) {
+	var count int
+	err := s.conn.QueryRow("SELECT count(*) FROM `"+s.prefix+"_recovery` WHERE `user_id`=?", userID).Scan(&count)
+	return count, err
+}
+
+func (s *mysqlStore) AddCredential(c Credential) error {
+	return s.exec("INSERT INTO `"+s.prefix+"_credential` (`id`, `user_id`, `name`, `public_key`, `sign_count`, `created`, `last_used`) VALUES (?, ?, ?, ?, ?, ?, ?)",
+		c.ID, c.UserID, c.Name, c.PublicKey, c.SignCount, c.Created, c.LastUsed)
+}
+
+func (s *mysqlStore) GetCredential(id string) (Credential, error) {
+	var c Credential
+	err := s.conn.QueryRow("SELECT `id`, `user_id`, `name`, `public_key`, `sign_count`, `created`, `last_used` FROM `"+s.prefix+"_credential` WHERE `id`=?", id).
+		Scan(&c.ID, &c.UserID, &c.Name, &c.PublicKey, &c.SignCount, &c.Created, &c.LastUsed)
+	return c, err
+}
+
+func (s *mysqlStore) GetCredentials(userID int64) ([]Credential, error) {
+	rows, err := s.conn.Query("SELECT `id`, `user_id`, `name`, `public_key`, `sign_count`, `created`, `last_used` FROM `"+s.prefix+"_credential` WHERE `user_id`=? ORDER BY `created`", userID)
+	if err != nil {
+		return nil, err
+	}
+	return scanCredentials(rows)
+}
+
+func (s *mysqlStore) UseCredential(id string, signCount int64, used time.Time) error {
+	return s.exec("UPDATE `"+s.prefix+"_credential` SET `sign_count`=?, `last_used`=? WHERE `id`=?", signCount, used, id)
+}
+
+func (s *mysqlStore) RenameCredential(userID int64, id, name string) error {
+	return s.exec("UPDATE `"+s.prefix+"_credential` SET `name`=? WHERE `id`=? AND `user_id`=?", name, id, userID)
+}
+
+func (s *mysqlStore) DeleteCredential(userID int64, id string) error {
+	return s.exec("DELETE FROM `"+s.prefix+"_credential` WHERE `id`=? AND `user_id`=?", id, userID)
+}
+
+func (s *mysqlStore) AddRolePermission(role, permission string) error {
+	return s.replace("DELETE FROM `"+s.prefix+"_role_permission` WHERE `role`=? AND `permission`=?", "INSERT INTO `"+s.prefix+"_role_permission` (`role`, `permission`) VALUES (?, ?)", role, permission)
+}
+
+func (s *mysqlStore) DeleteRolePermission(role, permission string) error {
+	return s.exec("DELETE FROM `"+s.prefix+"_role_permission` WHERE `role`=? AND `permission`=?", role, permission)
+}
+
+func (s *mysqlStore) GetRolePermissions(role string) ([]string, error) {
+	rows, err := s.conn.Query("SELECT `permission` FROM `"+s.prefix+"_role_permission` WHERE `role`=? ORDER BY `permission`", role)
+	if err != nil {
+		return nil, err
+	}
+	return scanParam(rows)
+}
+
+func (s *mysqlStore) AddUserRole(userID int64, role string) error {
+	return s.replace("DELETE FROM `"+s.prefix+"_user_role` WHERE `user_id`=? AND `role`=?", "INSERT INTO `"+s.prefix+"_user_role` (`user_id`, `role`) VALUES (?, ?)", userID, role)
+}
+
+func (s *mysqlStore) DeleteUserRole(userID int64, role string) error {
+	return s.exec("DELETE FROM `"+s.prefix+"_user_role` WHERE `user_id`=? AND `role`=?", userID, role)
+}
+
+func (s *mysqlStore) GetUserRoles(userID int64) ([]string, error) {
+	rows, err := s.conn.Query("SELECT `role` FROM `"+s.prefix+"_user_role` WHERE `user_id`=? ORDER BY `role`", userID)
+	if err != nil {
+		return nil, err
+	}
+	return scanParam(rows)
+}
+
+func (s *mysqlStore) AddGroup(name string, created time.Time) (int64, error) {
+	tx, err := s.conn.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+	res, err := tx.Exec("INSERT INTO `"+s.prefix+"_group` (`name`, `created`) VALUES (?, ?)", name, created)
+	if err != nil {
+		return 0, err
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+	return id, tx.Commit()
+}
+
+func (s *mysqlStore) DeleteGroup(id int64) error {
+	tx, err := s.conn.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	for _, table := range []string{"_group_member", "_group_param", "_group_role"} {
+		_, err = tx.Exec("DELETE FROM `"+s.prefix+table+"` WHERE `group_id`=?", id)
+		if err != nil {
+			return err
+		}
+	}
+	_, err = tx.Exec("DELETE FROM `"+s.prefix+"_group` WHERE `id`=?", id)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s *mysqlStore) GetGroupByID(id int64) (GroupData, error) {
+	var g GroupData
+	err := s.conn.QueryRow("SELECT `id`, `name` FROM `"+s.prefix+"_group` WHERE `id`=?", id).Scan(&g.ID, &g.Name)
+	return g, err
+}
+
+func (s *mysqlStore) GetGroupByName(name string) (GroupData, error) {
+	var g GroupData
+	err := s.conn.QueryRow("SELECT `id`, `name` FROM `"+s.prefix+"_group` WHERE `name`=?", name).Scan(&g.ID, &g.Name)
+	return g, err
+}
+
+func (s *mysqlStore) AddGroupMember(groupID, userID int64) error {
+	return s.replace("DELETE FROM `"+s.prefix+"_group_member` WHERE `group_id`=? AND `user_id`=?", "INSERT INTO `"+s.prefix+"_group_member` (`group_id`, `user_id`) VALUES (?, ?)", groupID, userID)
+}
+
+func (s *mysqlStore) DeleteGroupMember(groupID, userID int64) error {
+	return s.exec("DELETE FROM `"+s.prefix+"_group_member` WHERE `group_id`=? AND `user_id`=?", groupID, userID)
+}
+
+func (s *mysqlStore) GetGroupMembers(groupID int64) ([]int64, error) {
+	rows, err := s.conn.Query("SELECT `user_id` FROM `"+s.prefix+"_group_member` WHERE `group_id`=? ORDER BY `user_id`", groupID)
+	if err != nil {
+		return nil, err
+	}
+	return scanIDs(rows)
+}
+
+func (s *mysqlStore) GetUserGroups(userID int64) ([]int64, error) {
+	rows, err := s.conn.Query("SELECT `group_id` FROM `"+s.prefix+"_group_member` WHERE `user_id`=? ORDER BY `group_id`", userID)
+	if err != nil {
+		return nil, err
+	}
+	return scanIDs(rows)
+}
+
+func (s *mysqlStore) AddGroupParams(groupID int64, params ...map[string]string) error {
+	tx, err := s.conn.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	for i := range params {
+		for k, v := range params[i] {
+			_, err = tx.Exec("INSERT INTO `"+s.prefix+"_group_param` (`group_id`, `key`, `val`) VALUES (?, ?, ?)", groupID, k, v)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return tx.Commit()
+}
+
+func (s *mysqlStore) GetGroupParams(groupID int64) (map[string][]string, error) {
+	rows, err := s.conn.Query("SELECT `key`, `val` FROM `"+s.prefix+"_group_param` WHERE `group_id`=?", groupID)
+	if err != nil {
+		return map[string][]string{}, err
+	}
+	return scanParams(rows)
+}
+
+func (s *mysqlStore) DeleteGroupParams(groupID int64, keys ...string) error {
+	if len(keys) == 0 {
+		return nil
+	}
+	params := []interface{}{groupID}
+	for i := range keys {
+		params = append(params, keys[i])
+	}
+	placeholders := strings.TrimLeft(strings.Repeat(", ?", len(keys)), ", ")
+	return s.exec("DELETE FROM `"+s.prefix+"_group_param` WHERE `group_id`=? AND `key` IN ("+placeholders+")", params...)
+}
+
+func (s *mysqlStore) AddGroupRole(groupID int64, role string) error {
+	return s.replace("DELETE FROM `"+s.prefix+"_group_role` WHERE `group_id`=? AND `role`=?", "INSERT INTO `"+s.prefix+"_group_role` (`group_id`, `role`) VALUES (?, ?)", groupID, role)
+}
+
+func (s *mysqlStore) DeleteGroupRole(groupID int64, role string) error {
+	return s.exec("DELETE FROM `"+s.prefix+"_group_role` WHERE `group_id`=? AND `role`=?", groupID, role)
+}
+
+func (s *mysqlStore) GetGroupRoles(groupID int64) ([]string, error) {
+	rows, err := s.conn.Query("SELECT `role` FROM `"+s.prefix+"_group_role` WHERE `group_id`=? ORDER BY `role`", groupID)
+	if err != nil {
+		return nil, err
+	}
+	return scanParam(rows)
+}
+
+func (s *mysqlStore) AddOrg(name string, created time.Time) (int64, error) {
+	tx, err := s.conn.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+	res, err := tx.Exec("INSERT INTO `"+s.prefix+"_org` (`name`, `created`) VALUES (?, ?)", name, created)
+	if err != nil {
+		return 0, err
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+	return id, tx.Commit()
+}
+
+func (s *mysqlStore) DeleteOrg(id int64) error {
+	tx, err := s.conn.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	for _, table := range []string{"_org_member", "_org_role", "_org_param"} {
+		_, err = tx.Exec("DELETE FROM `"+s.prefix+table+"` WHERE `org_id`=?", id)
+		if err != nil {
+			return err
+		}
+	}
+	_, err = tx.Exec("UPDATE `"+s.prefix+"_session` SET `org_id`=0 WHERE `org_id`=?", id)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec("DELETE FROM `"+s.prefix+"_org` WHERE `id`=?", id)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s *mysqlStore) GetOrgByID(id int64) (OrgData, error) {
+	var o OrgData
+	err := s.conn.QueryRow("SELECT `id`, `name` FROM `"+s.prefix+"_org` WHERE `id`=?", id).Scan(&o.ID, &o.Name)
+	return o, err
+}
+
+func (s *mysqlStore) GetOrgByName(name string) (OrgData, error) {
+	var o OrgData
+	err := s.conn.QueryRow("SELECT `id`, `name` FROM `"+s.prefix+"_org` WHERE `name`=?", name).Scan(&o.ID, &o.Name)
+	return o, err
+}
+
+func (s *mysqlStore) AddOrgMember(orgID, userID int64) error {
+	return s.replace("DELETE FROM `"+s.prefix+"_org_member` WHERE `org_id`=? AND `user_id`=?", "INSERT INTO `"+s.prefix+"_org_member` (`org_id`, `user_id`) VALUES (?, ?)", orgID, userID)
+}
+
+func (s *mysqlStore) DeleteOrgMember(orgID, userID int64) error {
+	tx, err := s.conn.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	for _, table := range []string{"_org_member", "_org_role", "_org_param"} {
+		_, err = tx.Exec("DELETE FROM `"+s.prefix+table+"` WHERE `org_id`=? AND `user_id`=?", orgID, userID)
+		if err != nil {
+			return err
+		}
+	}
+	_, err = tx.Exec("UPDATE `"+s.prefix+"_session` SET `org_id`=0 WHERE `org_id`=? AND `user_id`=?", orgID, userID)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s *mysqlStore) GetOrgMembers(orgID int64) ([]int64, error) {
+	rows, err := s.conn.Query("SELECT `user_id` FROM `"+s.prefix+"_org_member` WHERE `org_id`=? ORDER BY `user_id`", orgID)
+	if err != nil {
+		return nil, err
+	}
+	return scanIDs(rows)
+}
+
+func (s *mysqlStore) GetUserOrgs(userID int64) ([]int64, error) {
+	rows, err := s.conn.Query("SELECT `org_id` FROM `"+s.prefix+"_org_member` WHERE `user_id`=? ORDER BY `org_id`", userID)
+	if err != nil {
+		return nil, err
+	}
+	return scanIDs(rows)
+}
+
+func (s *mysqlStore) AddOrgRole(orgID, userID int64, role string) error {
+	return s.replace("DELETE FROM `"+s.prefix+"_org_role` WHERE `org_id`=? AND `user_id`=? AND `role`=?", "INSERT INTO `"+s.prefix+"_org_role` (`org_id`, `user_id`, `role`) VALUES (?, ?, ?)", orgID, userID, role)
+}
+
+func (s *mysqlStore) DeleteOrgRole(orgID, userID int64, role string) error {
+	return s.exec("DELETE FROM `"+s.prefix+"_org_role` WHERE `org_id`=? AND `user_id`=? AND `role`=?", orgID, userID, role)
+}
+
+func (s *mysqlStore) GetOrgRoles(orgID, userID int64) ([]string, error) {
+	rows, err := s.conn.Query("SELECT `role` FROM `"+s.prefix+"_org_role` WHERE `org_id`=? AND `user_id`=? ORDER BY `role`", orgID, userID)
+	if err != nil {
+		return nil, err
+	}
+	return scanParam(rows)
+}
+
+func (s *mysqlStore) AddOrgParams(orgID, userID int64, params ...map[string]string) error {
+	tx, err := s.conn.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	for i := range params {
+		for k, v := range params[i] {
+			_, err = tx.Exec("INSERT INTO `"+s.prefix+"_org_param` (`org_id`, `user_id`, `key`, `val`) VALUES (?, ?, ?, ?)", orgID, userID, k, v)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return tx.Commit()
+}
+
+func (s *mysqlStore) GetOrgParams(orgID, userID int64) (map[string][]string, error) {
+	rows, err := s.conn.Query("SELECT `key`, `val` FROM `"+s.prefix+"_org_param` WHERE `org_id`=? AND `user_id`=?", orgID, userID)
+	if err != nil {
+		return map[string][]string{}, err
+	}
+	return scanParams(rows)
+}
+
+func (s *mysqlStore) DeleteOrgParams(orgID, userID int64, keys ...string) error {
+	if len(keys) == 0 {
+		return nil
+	}
+	params := []interface{}{orgID, userID}
+	for i := range keys {
+		params = append(params, keys[i])
+	}
+	placeholders := strings.TrimLeft(strings.Repeat(", ?", len(keys)), ", ")
+	return s.exec("DELETE FROM `"+s.prefix+"_org_param` WHERE `org_id`=? AND `user_id`=? AND `key` IN ("+placeholders+")", params...)
+}
@@ -0,0 +1,213 @@
+package baxtep
+
+import (
+	"database/sql"
+	"time"
+)
+
+// Org is an organization (tenant) in one table set. A user can be a member
+// of several orgs with roles and params in each. A user scoped to an org,
+// found by Org.GetUserByEmail, User.InOrg or by a session with an active
+// org, sees the roles and params of the org on top of its own ones.
+type Org struct {
+	b    *Baxtep
+	id   int64
+	Name string
+}
+
+func (b *Baxtep) newOrg(d OrgData) Org {
+	return Org{b: b, id: d.ID, Name: d.Name}
+}
+
+// CreateOrg adds an org, org names are unique.
+func (b *Baxtep) CreateOrg(name string) (Org, error) {
+	_, err := b.store.GetOrgByName(name)
+	if err == nil {
+		return Org{b: b}, ErrOrgNameExist
+	}
+	if err != sql.ErrNoRows {
+		return Org{b: b}, err
+	}
+	id, err := b.store.AddOrg(name, time.Now().UTC())
+	if err != nil {
+		return Org{b: b}, err
+	}
+	return Org{b: b, id: id, Name: name}, nil
+}
+
+func (b *Baxtep) GetOrgByID(id int64) (Org, error) {
+	d, err := b.store.GetOrgByID(id)
+	if err == sql.ErrNoRows {
+		return Org{b: b}, ErrOrgNotFound
+	}
+	if err != nil {
+		return Org{b: b}, err
+	}
+	return b.newOrg(d), nil
+}
+
+func (b *Baxtep) GetOrgByName(name string) (Org, error) {
+	d, err := b.store.GetOrgByName(name)
+	if err == sql.ErrNoRows {
+		return Org{b: b}, ErrOrgNotFound
+	}
+	if err != nil {
+		return Org{b: b}, err
+	}
+	return b.newOrg(d), nil
+}
+
+// DeleteOrg deletes the org with the roles and params of its members.
+func (b *Baxtep) DeleteOrg(id int64) error {
+	return b.store.DeleteOrg(id)
+}
+
+func (o *Org) GetID() int64 {
+	return o.id
+}
+
+// AddMember adds the user to the org, adding it twice is not an error.
+func (o *Org) AddMember(userID int64) error {
+	_, err := o.b.GetUserByID(userID)
+	if err != nil {
+		return err
+	}
+	return o.b.store.AddOrgMember(o.id, userID)
+}
+
+// RemoveMember removes the user with its roles and params in the org.
+func (o *Org) RemoveMember(userID int64) error {
+	return o.b.store.DeleteOrgMember(o.id, userID)
+}
+
+func (o *Org) IsMember(userID int64) (bool, error) {
+	ids, err := o.b.store.GetUserOrgs(userID)
+	if err != nil {
+		return false, err
+	}
+	for _, id := range ids {
+		if id == o.id {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// Members returns the members scoped to the org.
+func (o *Org) Members() ([]User, error) {
+	ids, err := o.b.store.GetOrgMembers(o.id)
+	if err != nil {
+		return nil, err
+	}
+	var users []User
+	for _, id := range ids {
+		u, err := o.b.GetUserByID(id)
+		if err == ErrUserWithIDNotFound {
+			continue
+		}
+		if err != nil {
+			return users, err
+		}
+		u.org = o.id
+		users = append(users, u)
+	}
+	return users, nil
+}
+
+// GrantRole gives a member the role within the org only.
+func (o *Org) GrantRole(userID int64, role string) error {
+	member, err := o.IsMember(userID)
+	if err != nil {
+		return err
+	}
+	if !member {
+		return ErrUserNotInOrg
+	}
+	return o.b.store.AddOrgRole(o.id, userID, role)
+}
+
+func (o *Org) RevokeRole(userID int64, role string) error {
+	return o.b.store.DeleteOrgRole(o.id, userID, role)
+}
+
+// scope returns u scoped to the org, users of other orgs are not found.
+func (o *Org) scope(u User, err, notFound error) (User, error) {
+	if err != nil {
+		return u, err
+	}
+	member, err := o.IsMember(u.id)
+	if err != nil {
+		return User{b: o.b}, err
+	}
+	if !member {
+		return User{b: o.b}, notFound
+	}
+	u.org = o.id
+	return u, nil
+}
+
+func (o *Org) GetUserByID(id int64) (User, error) {
+	u, err := o.b.GetUserByID(id)
+	return o.scope(u, err, ErrUserWithIDNotFound)
+}
+
+func (o *Org) GetUserByEmail(email string) (User, error) {
+	u, err := o.b.GetUserByEmail(email)
+	return o.scope(u, err, ErrUserWithEmailNotFound)
+}
+
+// Orgs returns the orgs the user is a member of.
+func (u *User) Orgs() ([]Org, error) {
+	ids, err := u.b.store.GetUserOrgs(u.id)
+	if err != nil {
+		return nil, err
+	}
+	var orgs []Org
+	for _, id := range ids {
+		o, err := u.b.GetOrgByID(id)
+		if err == ErrOrgNotFound {
+			continue
+		}
+		if err != nil {
+			return orgs, err
+		}
+		orgs = append(orgs, o)
+	}
+	return orgs, nil
+}
+
+// OrgID returns the ID of the org the user is scoped to, 0 for none.
+func (u *User) OrgID() int64 {
+	return u.org
+}
+
+// InOrg returns the user scoped to the org, orgID 0 removes the scope.
+func (u *User) InOrg(orgID int64) (User, error) {
+	scoped := *u
+	scoped.org = 0
+	if orgID == 0 {
+		return scoped, nil
+	}
+	o, err := u.b.GetOrgByID(orgID)
+	if err != nil {
+		return scoped, err
+	}
+	return o.scope(scoped, nil, ErrUserNotInOrg)
+}
+
+// SetActiveOrg scopes the user to the org and keeps it as the active org
+// of the session the user was found by, orgID 0 removes the scope.
+func (u *User) SetActiveOrg(orgID int64) error {
+	scoped, err := u.InOrg(orgID)
+	if err != nil {
+		return err
+	}
+	if u.session != "" {
+		err = u.b.store.SetSessionOrg(u.session, orgID)
+		if err != nil {
+			return err
+		}
+	}
+	u.org = scoped.org
+	return nil
+}
@@ -0,0 +1,112 @@
+package baxtep
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestOrgIsolation(t *testing.T) {
+	b := newTestBaxtep(t)
+	u := addTestUser(t, b, "user", "user@example.com")
+	outsider := addTestUser(t, b, "outsider", "outsider@example.com")
+	b.AddPermissions("owner", "billing.edit")
+	b.AddPermissions("member", "doc.view")
+
+	acme, err := b.CreateOrg("acme")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = b.CreateOrg("acme"); err != ErrOrgNameExist {
+		t.Errorf("taken org name: %v", err)
+	}
+	other, _ := b.CreateOrg("other")
+	acme.AddMember(u.GetID())
+	other.AddMember(u.GetID())
+	other.AddMember(outsider.GetID())
+	if err = acme.GrantRole(outsider.GetID(), "owner"); err != ErrUserNotInOrg {
+		t.Errorf("role for a non-member: %v", err)
+	}
+	acme.GrantRole(u.GetID(), "owner")
+	b.GrantRole(u.GetID(), "member")
+
+	// users of other orgs are not found
+	if _, err = acme.GetUserByEmail("outsider@example.com"); err != ErrUserWithEmailNotFound {
+		t.Errorf("user of another org by email: %v", err)
+	}
+	if _, err = acme.GetUserByID(outsider.GetID()); err != ErrUserWithIDNotFound {
+		t.Errorf("user of another org by ID: %v", err)
+	}
+	if _, err = outsider.InOrg(acme.GetID()); err != ErrUserNotInOrg {
+		t.Errorf("outsider in the org: %v", err)
+	}
+	if members, err := acme.Members(); err != nil || len(members) != 1 || members[0].OrgID() != acme.GetID() {
+		t.Errorf("members: %v, %v", members, err)
+	}
+
+	// org roles and params only in the org
+	scoped, err := acme.GetUserByEmail("user@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	scoped.AddParams(map[string]string{"title": "CEO"})
+	if ok, _ := scoped.HasPermission("billing.edit"); !ok {
+		t.Error("org role in the org")
+	}
+	if ok, _ := scoped.HasPermission("doc.view"); !ok {
+		t.Error("own role in the org")
+	}
+	for name, user := range map[string]User{"unscoped": u, "other org": mustInOrg(t, u, other.GetID())} {
+		if ok, _ := user.HasPermission("billing.edit"); ok {
+			t.Errorf("%s user has the org role", name)
+		}
+		if ok, _ := user.HasParam("title"); ok {
+			t.Errorf("%s user has the org param", name)
+		}
+	}
+	if params, _ := scoped.GetParams(); !reflect.DeepEqual(params["title"], []string{"CEO"}) {
+		t.Errorf("org params: %v", params)
+	}
+
+	// the active org of a session
+	sessionID, _ := u.NewSession("", "")
+	logged, _ := b.GetUserBySessionID(sessionID)
+	outsiders, _ := b.CreateOrg("outsiders")
+	outsiders.AddMember(outsider.GetID())
+	if err = logged.SetActiveOrg(outsiders.GetID()); err != ErrUserNotInOrg {
+		t.Errorf("active org the user is not in: %v", err)
+	}
+	if err = logged.SetActiveOrg(acme.GetID()); err != nil {
+		t.Fatal(err)
+	}
+	logged, _ = b.GetUserBySessionID(sessionID)
+	if logged.OrgID() != acme.GetID() {
+		t.Errorf("active org of the session: %d", logged.OrgID())
+	}
+
+	acme.RemoveMember(u.GetID())
+	acme.AddMember(u.GetID())
+	scoped, _ = acme.GetUserByEmail("user@example.com")
+	if ok, _ := scoped.HasPermission("billing.edit"); ok {
+		t.Error("org role is kept after RemoveMember")
+	}
+	if ok, _ := scoped.HasParam("title"); ok {
+		t.Error("org param is kept after RemoveMember")
+	}
+	if err = b.DeleteOrg(acme.GetID()); err != nil {
+		t.Fatal(err)
+	}
+	if orgs, _ := u.Orgs(); len(orgs) != 1 || orgs[0].Name != "other" {
+		t.Errorf("orgs after DeleteOrg: %v", orgs)
+	}
+	if logged, _ = b.GetUserBySessionID(sessionID); logged.OrgID() != 0 {
+		t.Errorf("deleted org is active: %d", logged.OrgID())
+	}
+}
+
+func mustInOrg(t *testing.T, u User, orgID int64) User {
+	scoped, err := u.InOrg(orgID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return scoped
+}
@@ -0,0 +1,169 @@
+package baxtep
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+var ErrUnknownPasswordHash = errors.New("unknown password hash format")
+
+// PasswordHasher hashes and verifies user passwords. Hashes made by the
+// built-in hashers and legacy SHA-256 hashes are verified whatever hasher
+// is set, so switching hashers keeps existing users able to log in.
+type PasswordHasher interface {
+	Hash(password string) (string, error)
+	Verify(password, hash string) (bool, error)
+	// NeedsRehash reports whether hash should be replaced on next login.
+	NeedsRehash(hash string) bool
+}
+
+// Argon2idHasher stores passwords as PHC strings:
+// $argon2id$v=19$m=<memory>,t=<time>,p=<threads>$<salt>$<key>
+type Argon2idHasher struct {
+	Time    uint32
+	Memory  uint32 // KiB
+	Threads uint8
+	KeyLen  uint32
+	SaltLen uint32
+}
+
+func NewArgon2idHasher() *Argon2idHasher {
+	return &Argon2idHasher{
+		Time:    1,
+		Memory:  64 * 1024,
+		Threads: 4,
+		KeyLen:  32,
+		SaltLen: 16,
+	}
+}
+
+func (h *Argon2idHasher) Hash(password string) (string, error) {
+	salt := make([]byte, h.SaltLen)
+	_, err := rand.Read(salt)
+	if err != nil {
+		return "", err
+	}
+	key := argon2.IDKey([]byte(password), salt, h.Time, h.Memory, h.Threads, h.KeyLen)
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2.Version, h.Memory, h.Time, h.Threads,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key)), nil
+}
+
+func (h *Argon2idHasher) Verify(password, hash string) (bool, error) {
+	p, salt, key, err := parseArgon2id(hash)
+	if err != nil {
+		return false, err
+	}
+	other := argon2.IDKey([]byte(password), salt, p.Time, p.Memory, p.Threads, uint32(len(key)))
+	return subtle.ConstantTimeCompare(key, other) == 1, nil
+}
+
+func (h *Argon2idHasher) NeedsRehash(hash string) bool {
+	p, salt, key, err := parseArgon2id(hash)
+	if err != nil {
+		return true
+	}
+	return p.Time != h.Time || p.Memory != h.Memory || p.Threads != h.Threads ||
+		uint32(len(key)) != h.KeyLen || uint32(len(salt)) != h.SaltLen
+}
+
+func parseArgon2id(hash string) (p Argon2idHasher, salt, key []byte, err error) {
+	var version int
+	parts := strings.Split(hash, "$")
+	if len(parts) != 6 || parts[0] != "" || parts[1] != "argon2id" {
+		return p, nil, nil, ErrUnknownPasswordHash
+	}
+	_, err = fmt.Sscanf(parts[2], "v=%d", &version)
+	if err != nil || version != argon2.Version {
+		return p, nil, nil, ErrUnknownPasswordHash
+	}
+	_, err = fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &p.Memory, &p.Time, &p.Threads)
+	if err != nil || p.Memory == 0 || p.Time == 0 || p.Threads == 0 {
+		return p, nil, nil, ErrUnknownPasswordHash
+	}
+	salt, err = base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil || len(salt) == 0 {
+		return p, nil, nil, ErrUnknownPasswordHash
+	}
+	// a short key matches too many passwords
+	key, err = base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil || len(key) < 16 {
+		return p, nil, nil, ErrUnknownPasswordHash
+	}
+	return p, salt, key, nil
+}
+
+// BcryptHasher stores passwords in the bcrypt $2a$ format.
+type BcryptHasher struct {
+	Cost int
+}
+
+func NewBcryptHasher() *BcryptHasher {
+	return &BcryptHasher{Cost: bcrypt.DefaultCost}
+}
+
+func (h *BcryptHasher) Hash(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), h.Cost)
+	return string(hash), err
+}
+
+func (h *BcryptHasher) Verify(password, hash string) (bool, error) {
+	err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
+	if err == bcrypt.ErrMismatchedHashAndPassword {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (h *BcryptHasher) NeedsRehash(hash string) bool {
+	cost, err := bcrypt.Cost([]byte(hash))
+	return err != nil || cost != h.Cost
+}
+
+func isBcryptHash(hash string) bool {
+	return strings.HasPrefix(hash, "$2a$") || strings.HasPrefix(hash, "$2b$") || strings.HasPrefix(hash, "$2y$")
+}
+
+// isLegacyHash reports whether hash is an unsalted hex SHA-256 from
+// versions before PasswordHasher.
+func isLegacyHash(hash string) bool {
+	if len(hash) != 64 {
+		return false
+	}
+	for _, c := range hash {
+		if (c < '0' || c > '9') && (c < 'a' || c > 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+// verifyPassword checks password against hash in any known format and
+// reports whether the hash should be upgraded with h.
+func verifyPassword(h PasswordHasher, password, hash string) (ok, rehash bool, err error) {
+	switch {
+	case hash == "":
+		return false, false, nil
+	case isLegacyHash(hash):
+		ok = subtle.ConstantTimeCompare([]byte(getPasswordHash(password)), []byte(hash)) == 1
+		return ok, ok, nil
+	case strings.HasPrefix(hash, "$argon2id$"):
+		ok, err = (&Argon2idHasher{}).Verify(password, hash)
+	case isBcryptHash(hash):
+		ok, err = (&BcryptHasher{}).Verify(password, hash)
+	default:
+		ok, err = h.Verify(password, hash)
+	}
+	if err != nil || !ok {
+		return false, false, err
+	}
+	return true, h.NeedsRehash(hash), nil
+}
@@ -0,0 +1,65 @@
+package baxtep
+
+import (
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestVerifyPassword(t *testing.T) {
+	// cheap parameters, the defaults make the test slow
+	argon := &Argon2idHasher{Time: 1, Memory: 1024, Threads: 1, KeyLen: 32, SaltLen: 16}
+	otherArgon := &Argon2idHasher{Time: 2, Memory: 1024, Threads: 1, KeyLen: 32, SaltLen: 16}
+	bcryptHasher := &BcryptHasher{Cost: bcrypt.MinCost}
+	hash := func(h PasswordHasher) string {
+		hash, err := h.Hash("password")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return hash
+	}
+	argonHash, otherArgonHash, bcryptHash := hash(argon), hash(otherArgon), hash(bcryptHasher)
+	if !strings.HasPrefix(argonHash, "$argon2id$v=19$m=1024,t=1,p=1$") {
+		t.Fatalf("argon2id hash %s", argonHash)
+	}
+
+	for _, test := range []struct {
+		name     string
+		hasher   PasswordHasher
+		password string
+		hash     string
+		ok       bool
+		rehash   bool
+		err      error
+	}{
+		{"no hash", argon, "password", "", false, false, nil},
+		{"legacy", argon, "password", getPasswordHash("password"), true, true, nil},
+		{"legacy wrong password", argon, "wrong", getPasswordHash("password"), false, false, nil},
+		{"argon2id", argon, "password", argonHash, true, false, nil},
+		{"argon2id wrong password", argon, "wrong", argonHash, false, false, nil},
+		{"argon2id other parameters", argon, "password", otherArgonHash, true, true, nil},
+		{"bcrypt under argon2id", argon, "password", bcryptHash, true, true, nil},
+		{"bcrypt wrong password", argon, "wrong", bcryptHash, false, false, nil},
+		{"bcrypt", bcryptHasher, "password", bcryptHash, true, false, nil},
+		{"argon2id under bcrypt", bcryptHasher, "password", argonHash, true, true, nil},
+		{"PHC without threads", argon, "password", "$argon2id$v=19$m=1024,t=1$c2FsdA$MDEyMzQ1Njc4OWFiY2RlZg", false, false, ErrUnknownPasswordHash},
+		{"PHC of other version", argon, "password", "$argon2id$v=16$m=1024,t=1,p=1$c2FsdA$MDEyMzQ1Njc4OWFiY2RlZg", false, false, ErrUnknownPasswordHash},
+		{"PHC with bad salt", argon, "password", "$argon2id$v=19$m=1024,t=1,p=1$!!$MDEyMzQ1Njc4OWFiY2RlZg", false, false, ErrUnknownPasswordHash},
+		{"PHC with bad key", argon, "password", "$argon2id$v=19$m=1024,t=1,p=1$c2FsdA$!!", false, false, ErrUnknownPasswordHash},
+		{"PHC without key", argon, "password", "$argon2id$v=19$m=1024,t=1,p=1$c2FsdA", false, false, ErrUnknownPasswordHash},
+		{"PHC with empty key", argon, "password", "$argon2id$v=19$m=1024,t=1,p=1$c2FsdA$", false, false, ErrUnknownPasswordHash},
+		{"PHC with short key", argon, "password", "$argon2id$v=19$m=1024,t=1,p=1$c2FsdA$a2V5", false, false, ErrUnknownPasswordHash},
+		{"PHC with empty salt", argon, "password", "$argon2id$v=19$m=1024,t=1,p=1$$MDEyMzQ1Njc4OWFiY2RlZg", false, false, ErrUnknownPasswordHash},
+		{"PHC with p=0", argon, "password", "$argon2id$v=19$m=1024,t=1,p=0$c2FsdA$MDEyMzQ1Njc4OWFiY2RlZg", false, false, ErrUnknownPasswordHash},
+		{"PHC with t=0", argon, "password", "$argon2id$v=19$m=1024,t=0,p=1$c2FsdA$MDEyMzQ1Njc4OWFiY2RlZg", false, false, ErrUnknownPasswordHash},
+		{"PHC with m=0", argon, "password", "$argon2id$v=19$m=0,t=1,p=1$c2FsdA$MDEyMzQ1Njc4OWFiY2RlZg", false, false, ErrUnknownPasswordHash},
+		{"unknown format", argon, "password", "$argon2i$v=19$m=1024,t=1,p=1$c2FsdA$MDEyMzQ1Njc4OWFiY2RlZg", false, false, ErrUnknownPasswordHash},
+	} {
+		ok, rehash, err := verifyPassword(test.hasher, test.password, test.hash)
+		if ok != test.ok || rehash != test.rehash || err != test.err {
+			t.Errorf("%s: ok %v, rehash %v, err %v; want %v, %v, %v",
+				test.name, ok, rehash, err, test.ok, test.rehash, test.err)
+		}
+	}
+}
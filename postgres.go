@@ -0,0 +1,850 @@
+package baxtep
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+type postgresStore struct {
+	conn   *sql.DB
+	prefix string
+}
+
+// NewPostgresStore returns a Store for PostgreSQL (lib/pq or pgx stdlib).
+func NewPostgresStore(db *sql.DB, prefix string) Store {
+	return &postgresStore{conn: db, prefix: prefix}
+}
+
+// table returns the quoted name of the users table with an optional suffix.
+func (s *postgresStore) table(suffix string) string {
+	return `"` + s.prefix + suffix + `"`
+}
+
+func (s *postgresStore) Migrate(ctx context.Context) error {
+	return migrate(ctx, s.conn, s.meta(), s.migrations())
+}
+
+func (s *postgresStore) SchemaVersion() (int, error) {
+	return schemaVersion(s.conn, s.meta())
+}
+
+func (s *postgresStore) meta() metaQueries {
+	return metaQueries{
+		create: "CREATE TABLE IF NOT EXISTS " + s.table("_meta") + " (" +
+			" name varchar(100) PRIMARY KEY," +
+			" value varchar(250) NOT NULL" +
+			");",
+		get:    "SELECT value FROM " + s.table("_meta") + " WHERE name=$1",
+		insert: "INSERT INTO " + s.table("_meta") + " (name, value) VALUES ($1, $2)",
+		update: "UPDATE " + s.table("_meta") + " SET value=$1 WHERE name=$2",
+	}
+}
+
+func (s *postgresStore) migrations() []migration {
+	return []migration{
+		// 1: users and params
+		{
+			"CREATE TABLE IF NOT EXISTS " + s.table("") + " (" +
+				" id bigserial PRIMARY KEY," +
+				" name varchar(100) NOT NULL," +
+				" password varchar(64) NOT NULL DEFAULT ''," +
+				" email varchar(100) NOT NULL," +
+				" registration_time timestamp with time zone," +
+				" enable boolean NOT NULL DEFAULT FALSE," +
+				" session_id varchar(64) NOT NULL DEFAULT ''," +
+				" session_time timestamp with time zone" +
+				");",
+			"CREATE TABLE IF NOT EXISTS " + s.table("_param") + " (" +
+				" id bigserial PRIMARY KEY," +
+				" user_id bigint NOT NULL," +
+				" key varchar(100) NOT NULL," +
+				" val varchar(250) NOT NULL" +
+				");",
+		},
+		// 2: room for PasswordHasher hashes
+		{
+			"ALTER TABLE " + s.table("") + " ALTER COLUMN password TYPE varchar(255)",
+		},
+		// 3: sessions move to their own table, session_id stays
+		// for registration confirmation codes only
+		{
+			"CREATE TABLE IF NOT EXISTS " + s.table("_session") + " (" +
+				" id varchar(64) PRIMARY KEY," +
+				" user_id bigint NOT NULL," +
+				" created timestamp with time zone NOT NULL," +
+				" last_seen timestamp with time zone NOT NULL," +
+				" user_agent varchar(250) NOT NULL DEFAULT ''," +
+				" ip varchar(45) NOT NULL DEFAULT ''" +
+				");",
+			"CREATE INDEX IF NOT EXISTS " + s.table("_session_user_id") + " ON " + s.table("_session") + " (user_id);",
+			"UPDATE " + s.table("") + " SET session_id='' WHERE enable OR session_time IS NOT NULL",
+		},
+		// 4: single-use tokens
+		{
+			"CREATE TABLE IF NOT EXISTS " + s.table("_token") + " (" +
+				" id varchar(64) PRIMARY KEY," +
+				" user_id bigint NOT NULL," +
+				" kind varchar(20) NOT NULL," +
+				" expires timestamp with time zone NOT NULL" +
+				");",
+			"CREATE INDEX IF NOT EXISTS " + s.table("_token_user_id") + " ON " + s.table("_token") + " (user_id);",
+		},
+		// 5: token payload
+		{
+			"ALTER TABLE " + s.table("_token") + " ADD COLUMN IF NOT EXISTS data varchar(250) NOT NULL DEFAULT ''",
+		},
+		// 6: failed logins and lockout, locked_until is unix time
+		{
+			"ALTER TABLE " + s.table("") + " ADD COLUMN IF NOT EXISTS failed_logins integer NOT NULL DEFAULT 0," +
+				" ADD COLUMN IF NOT EXISTS locked_until bigint NOT NULL DEFAULT 0",
+		},
+		// 7: encrypted TOTP secret and the last used time step
+		{
+			"ALTER TABLE " + s.table("") + " ADD COLUMN IF NOT EXISTS totp_secret varchar(255) NOT NULL DEFAULT ''," +
+				" ADD COLUMN IF NOT EXISTS totp_step bigint NOT NULL DEFAULT 0",
+		},
+		// 8: 2FA recovery codes
+		{
+			"CREATE TABLE IF NOT EXISTS " + s.table("_recovery") + " (" +
+				" id varchar(64) PRIMARY KEY," +
+				" user_id bigint NOT NULL" +
+				");",
+			"CREATE INDEX IF NOT EXISTS " + s.table("_recovery_user_id") + " ON " + s.table("_recovery") + " (user_id);",
+		},
+		// 9: WebAuthn credentials, id is the base64url credential ID
+		{
+			"CREATE TABLE IF NOT EXISTS " + s.table("_credential") + " (" +
+				" id varchar(255) PRIMARY KEY," +
+				" user_id bigint NOT NULL," +
+				" name varchar(100) NOT NULL DEFAULT ''," +
+				" public_key text NOT NULL," +
+				" sign_count bigint NOT NULL DEFAULT 0," +
+				" created timestamp with time zone NOT NULL," +
+				" last_used timestamp with time zone NOT NULL" +
+				");",
+			"CREATE INDEX IF NOT EXISTS " + s.table("_credential_user_id") + " ON " + s.table("_credential") + " (user_id);",
+		},
+		// 10: roles of users and permissions of roles, "role" params become roles
+		{
+			"CREATE TABLE IF NOT EXISTS " + s.table("_role_permission") + " (" +
+				" role varchar(100) NOT NULL," +
+				" permission varchar(100) NOT NULL," +
+				" PRIMARY KEY (role, permission)" +
+				");",
+			"CREATE TABLE IF NOT EXISTS " + s.table("_user_role") + " (" +
+				" user_id bigint NOT NULL," +
+				" role varchar(100) NOT NULL," +
+				" PRIMARY KEY (user_id, role)" +
+				");",
+			"INSERT INTO " + s.table("_user_role") + " (user_id, role)" +
+				" SELECT DISTINCT user_id, val FROM " + s.table("_param") + " WHERE key='role' AND length(val) <= 100;",
+		},
+		// 11: groups of users with their params and roles
+		{
+			"CREATE TABLE IF NOT EXISTS " + s.table("_group") + " (" +
+				" id bigserial PRIMARY KEY," +
+				" name varchar(100) NOT NULL UNIQUE," +
+				" created timestamp with time zone" +
+				");",
+			"CREATE TABLE IF NOT EXISTS " + s.table("_group_member") + " (" +
+				" group_id bigint NOT NULL," +
+				" user_id bigint NOT NULL," +
+				" PRIMARY KEY (group_id, user_id)" +
+				");",
+			"CREATE INDEX IF NOT EXISTS " + s.table("_group_member_user_id") + " ON " + s.table("_group_member") + " (user_id);",
+			"CREATE TABLE IF NOT EXISTS " + s.table("_group_param") + " (" +
+				" id bigserial PRIMARY KEY," +
+				" group_id bigint NOT NULL," +
+				" key varchar(100) NOT NULL," +
+				" val varchar(250) NOT NULL" +
+				");",
+			"CREATE INDEX IF NOT EXISTS " + s.table("_group_param_group_id") + " ON " + s.table("_group_param") + " (group_id);",
+			"CREATE TABLE IF NOT EXISTS " + s.table("_group_role") + " (" +
+				" group_id bigint NOT NULL," +
+				" role varchar(100) NOT NULL," +
+				" PRIMARY KEY (group_id, role)" +
+				");",
+		},
+		// 12: organizations with per-org roles and params, the active org of sessions
+		{
+			"CREATE TABLE IF NOT EXISTS " + s.table("_org") + " (" +
+				" id bigserial PRIMARY KEY," +
+				" name varchar(100) NOT NULL UNIQUE," +
+				" created timestamp with time zone" +
+				");",
+			"CREATE TABLE IF NOT EXISTS " + s.table("_org_member") + " (" +
+				" org_id bigint NOT NULL," +
+				" user_id bigint NOT NULL," +
+				" PRIMARY KEY (org_id, user_id)" +
+				");",
+			"CREATE INDEX IF NOT EXISTS " + s.table("_org_member_user_id") + " ON " + s.table("_org_member") + " (user_id);",
+			"CREATE TABLE IF NOT EXISTS " + s.table("_org_role") + " (" +
+				" org_id bigint NOT NULL," +
+				" user_id bigint NOT NULL," +
+				" role varchar(100) NOT NULL," +
+				" PRIMARY KEY (org_id, user_id, role)" +
+				");",
+			"CREATE TABLE IF NOT EXISTS " + s.table("_org_param") + " (" +
+				" id bigserial PRIMARY KEY," +
+				" org_id bigint NOT NULL," +
+				" user_id bigint NOT NULL," +
+				" key varchar(100) NOT NULL," +
+				" val varchar(250) NOT NULL" +
+				");",
+			"CREATE INDEX IF NOT EXISTS " + s.table("_org_param_org_user") + " ON " + s.table("_org_param") + " (org_id, user_id);",
+			"ALTER TABLE " + s.table("_session") + " ADD COLUMN IF NOT EXISTS org_id bigint NOT NULL DEFAULT 0",
+		},
+	}
+}
+
+func (s *postgresStore) exec(query string, args ...interface{}) error {
+	tx, err := s.conn.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	_, err = tx.Exec(query, args...)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// replace runs del and ins with the same arguments in a transaction,
+// so that adding a row twice keeps one.
+func (s *postgresStore) replace(del, ins string, args ...interface{}) error {
+	tx, err := s.conn.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	_, err = tx.Exec(del, args...)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(ins, args...)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s *postgresStore) AddUser(name, email, passhash, confirm string, registered time.Time) (int64, error) {
+	var id int64
+	tx, err := s.conn.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+	err = tx.QueryRow("INSERT INTO "+s.table("")+" (name, password, email, registration_time, session_id, enable) VALUES ($1, $2, $3, $4, $5, FALSE) RETURNING id", name, passhash, email, registered, confirm).Scan(&id)
+	if err != nil {
+		return 0, err
+	}
+	return id, tx.Commit()
+}
+
+func (s *postgresStore) DeleteUser(id int64) error {
+	tx, err := s.conn.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	for _, table := range []string{"_param", "_org_member", "_org_role", "_org_param"} {
+		_, err = tx.Exec("DELETE FROM "+s.table(table)+" WHERE user_id=$1", id)
+		if err != nil {
+			return err
+		}
+	}
+	_, err = tx.Exec("DELETE FROM "+s.table("_group_member")+" WHERE user_id=$1", id)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec("DELETE FROM "+s.table("_token")+" WHERE user_id=$1", id)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec("DELETE FROM "+s.table("_user_role")+" WHERE user_id=$1", id)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec("DELETE FROM "+s.table("_credential")+" WHERE user_id=$1", id)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec("DELETE FROM "+s.table("_recovery")+" WHERE user_id=$1", id)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec("DELETE FROM "+s.table("_session")+" WHERE user_id=$1", id)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec("DELETE FROM "+s.table("")+" WHERE id=$1", id)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s *postgresStore) getUser(where string, arg interface{}) (UserData, error) {
+	var u UserData
+	err := s.conn.QueryRow("SELECT id, name, email, enable FROM "+s.table("")+" WHERE "+where, arg).Scan(&u.ID, &u.Name, &u.Email, &u.Enable)
+	return u, err
+}
+
+func (s *postgresStore) GetUserByID(id int64) (UserData, error) {
+	return s.getUser("id=$1", id)
+}
+
+func (s *postgresStore) GetUserByName(name string) (UserData, error) {
+	return s.getUser("name=$1", name)
+}
+
+func (s *postgresStore) GetUserByEmail(email string) (UserData, error) {
+	return s.getUser("email=$1", email)
+}
+
+func (s *postgresStore) GetUserByConfirm(confirm string) (UserData, error) {
+	return s.getUser("session_id=$1", confirm)
+}
+
+func (s *postgresStore) CountUserName(name string) (int64, error) {
+	var count int64
+	err := s.conn.QueryRow("SELECT COUNT(*) FROM "+s.table("")+" WHERE name=$1", name).Scan(&count)
+	return count, err
+}
+
+func (s *postgresStore) CountUserEmail(email string) (int64, error) {
+	var count int64
+	err := s.conn.QueryRow("SELECT COUNT(*) FROM "+s.table("")+" WHERE email=$1", email).Scan(&count)
+	return count, err
+}
+
+func (s *postgresStore) SetUserEnable(id int64, enable bool) error {
+	return s.exec("UPDATE "+s.table("")+" SET enable=$1 WHERE id=$2", enable, id)
+}
+
+func (s *postgresStore) SetUserEmail(id int64, email string) error {
+	return s.exec("UPDATE "+s.table("")+" SET email=$1 WHERE id=$2", email, id)
+}
+
+func (s *postgresStore) GetUserPassword(id int64) (string, error) {
+	var passhash string
+	err := s.conn.QueryRow("SELECT password FROM "+s.table("")+" WHERE id=$1", id).Scan(&passhash)
+	return passhash, err
+}
+
+func (s *postgresStore) SetUserPassword(id int64, passhash string) error {
+	return s.exec("UPDATE "+s.table("")+" SET password=$1 WHERE id=$2", passhash, id)
+}
+
+func (s *postgresStore) GetUserLock(id int64) (int, time.Time, error) {
+	var failures int
+	var until int64
+	err := s.conn.QueryRow("SELECT failed_logins, locked_until FROM "+s.table("")+" WHERE id=$1", id).Scan(&failures, &until)
+	return failures, time.Unix(until, 0).UTC(), err
+}
+
+func (s *postgresStore) AddUserFailure(id int64) (int, error) {
+	var failures int
+	err := s.conn.QueryRow("UPDATE "+s.table("")+" SET failed_logins=failed_logins+1 WHERE id=$1 RETURNING failed_logins", id).Scan(&failures)
+	return failures, err
+}
+
+func (s *postgresStore) SetUserLock(id int64, failures int, until time.Time) error {
+	return s.exec("UPDATE "+s.table("")+" SET failed_logins=$1, locked_until=$2 WHERE id=$3", failures, until.Unix(), id)
+}
+
+func (s *postgresStore) GetUserTOTP(id int64) (string, int64, error) {
+	var secret string
+	var step int64
+	err := s.conn.QueryRow("SELECT totp_secret, totp_step FROM "+s.table("")+" WHERE id=$1", id).Scan(&secret, &step)
+	return secret, step, err
+}
+
+func (s *postgresStore) SetUserTOTP(id int64, secret string, step int64) error {
+	return s.exec("UPDATE "+s.table("")+" SET totp_secret=$1, totp_step=$2 WHERE id=$3", secret, step, id)
+}
+
+func (s *postgresStore) SetUserConfirm(id int64, confirm string) error {
+	return s.exec("UPDATE "+s.table("")+" SET session_id=$1 WHERE id=$2", confirm, id)
+}
+
+func (s *postgresStore) AddSession(session Session) error {
+	return s.exec("INSERT INTO "+s.table("_session")+" (id, user_id, created, last_seen, user_agent, ip, org_id) VALUES ($1, $2, $3, $4, $5, $6, $7)",
+		session.ID, session.UserID, session.Created, session.LastSeen, session.UserAgent, session.IP, session.OrgID)
+}
+
+func (s *postgresStore) GetSession(id string) (Session, error) {
+	var session Session
+	err := s.conn.QueryRow("SELECT id, user_id, created, last_seen, user_agent, ip, org_id FROM "+s.table("_session")+" WHERE id=$1", id).
+		Scan(&session.ID, &session.UserID, &session.Created, &session.LastSeen, &session.UserAgent, &session.IP, &session.OrgID)
+	return session, err
+}
+
+func (s *postgresStore) GetSessions(userID int64) ([]Session, error) {
+	rows, err := s.conn.Query("SELECT id, user_id, created, last_seen, user_agent, ip, org_id FROM "+s.table("_session")+" WHERE user_id=$1 ORDER BY created", userID)
+	if err != nil {
+		return nil, err
+	}
+	return scanSessions(rows)
+}
+
+func (s *postgresStore) TouchSession(id string, t time.Time) error {
+	return s.exec("UPDATE "+s.table("_session")+" SET last_seen=$1 WHERE id=$2", t, id)
+}
+
+func (s *postgresStore) DeleteSession(userID int64, id string) error {
+	return s.exec("DELETE FROM "+s.table("_session")+" WHERE user_id=$1 AND id=$2", userID, id)
+}
+
+func (s *postgresStore) DeleteSessions(userID int64) error {
+	return s.exec("DELETE FROM "+s.table("_session")+" WHERE user_id=$1", userID)
+}
+
+func (s *postgresStore) SetSessionOrg(id string, orgID int64) error {
+	return s.exec("UPDATE "+s.table("_session")+" SET org_id=$1 WHERE id=$2", orgID, id)
+}
+
+func (s *postgresStore) AddParams(userID int64, params ...map[string]string) error {
+	tx, err := s.conn.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	for i := range params {
+		for k, v := range params[i] {
+			_, err = tx.Exec("INSERT INTO "+s.table("_param")+" (user_id, key, val) VALUES ($1, $2, $3)", userID, k, v)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return tx.Commit()
+}
+
+func (s *postgresStore) HasParam(userID int64, key string) (bool, error) {
+	var cnt int64
+	err := s.conn.QueryRow("SELECT COUNT(*) FROM "+s.table("_param")+" WHERE user_id=$1 AND key=$2", userID, key).Scan(&cnt)
+	return cnt > 0, err
+}
+
+func (s *postgresStore) HasParamValue(userID int64, key, value string) (bool, error) {
+	var cnt int64
+	err := s.conn.QueryRow("SELECT COUNT(*) FROM "+s.table("_param")+" WHERE user_id=$1 AND key=$2 AND val=$3", userID, key, value).Scan(&cnt)
+	return cnt > 0, err
+}
+
+func (s *postgresStore) GetParam(userID int64, key string) ([]string, error) {
+	rows, err := s.conn.Query("SELECT val FROM "+s.table("_param")+" WHERE user_id=$1 AND key=$2", userID, key)
+	if err != nil {
+		return nil, err
+	}
+	return scanParam(rows)
+}
+
+func (s *postgresStore) GetParams(userID int64) (map[string][]string, error) {
+	rows, err := s.conn.Query("SELECT key, val FROM "+s.table("_param")+" WHERE user_id=$1", userID)
+	if err != nil {
+		return map[string][]string{}, err
+	}
+	return scanParams(rows)
+}
+
+func (s *postgresStore) DeleteParams(userID int64, keys ...string) error {
+	if len(keys) == 0 {
+		return nil
+	}
+	params := []interface{}{userID}
+	for i := range keys {
+		params = append(params, keys[i])
+	}
+	return s.exec("DELETE FROM "+s.table("_param")+" WHERE user_id=$1 AND key IN ("+numberedPlaceholders(2, len(keys))+")", params...)
+}
+
+func (s *postgresStore) AddToken(t Token) error {
+	return s.exec("INSERT INTO "+s.table("_token")+" (id, user_id, kind, expires, data) VALUES ($1, $2, $3, $4, $5)", t.ID, t.UserID, t.Kind, t.Expires, t.Data)
+}
+
+func (s *postgresStore) GetToken(id, kind string) (Token, error) {
+	var t Token
+	err := s.conn.QueryRow("SELECT id, user_id, kind, expires, data FROM "+s.table("_token")+" WHERE id=$1 AND kind=$2", id, kind).
+		Scan(&t.ID, &t.UserID, &t.Kind, &t.Expires, &t.Data)
+	return t, err
+}
+
+func (s *postgresStore) TakeToken(id, kind string) (Token, error) {
+	var t Token
+	tx, err := s.conn.Begin()
+	if err != nil {
+		return t, err
+	}
+	defer tx.Rollback()
+	err = tx.QueryRow("SELECT id, user_id, kind, expires, data FROM "+s.table("_token")+" WHERE id=$1 AND kind=$2", id, kind).
+		Scan(&t.ID, &t.UserID, &t.Kind, &t.Expires, &t.Data)
+	if err != nil {
+		return t, err
+	}
+	res, err := tx.Exec("DELETE FROM "+s.table("_token")+" WHERE id=$1", id)
+	if err != nil {
+		return t, err
+	}
+	if n, err := res.RowsAffected(); err != nil || n != 1 {
+		return t, sql.ErrNoRows
+	}
+	return t, tx.Commit()
+}
+
+func (s *postgresStore) DeleteTokens(userID int64, kind string) error {
+	return s.exec("DELETE FROM "+s.table("_token")+" WHERE user_id=$1 AND kind=$2", userID, kind)
+}
+
+func (s *postgresStore) DeleteExpiredTokens(kind string, now time.Time) error {
+	return s.exec("DELETE FROM "+s.table("_token")+" WHERE kind=$1 AND expires<$2", kind, now)
+}
+
+func (s *postgresStore) SetRecoveryCodes(userID int64, ids []string) error {
+	tx, err := s.conn.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	_, err = tx.Exec("DELETE FROM "+s.table("_recovery")+" WHERE user_id=$1", userID)
+	if err != nil {
+		return err
+	}
+	for i := range ids {
+		_, err = tx.Exec("INSERT INTO "+s.table("_recovery")+" (id, user_id) VALUES ($1, $2)", ids[i], userID)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func (s *postgresStore) TakeRecoveryCode(userID int64, id string) error {
+	res, err := s.conn.Exec("DELETE FROM "+s.table("_recovery")+" WHERE id=$1 AND user_id=$2", id, userID)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil || n != 1 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+func (s *postgresStore) CountRecoveryCodes(userID int64) (int, error) {
+	var count int
+	err := s.conn.QueryRow("SELECT count(*) FROM "+s.table("_recovery")+" WHERE user_id=$1", userID).Scan(&count)
+	return count, err
+}
+
+func (s *postgresStore) AddCredential(c Credential) error {
+	return s.exec("INSERT INTO "+s.table("_credential")+" (id, user_id, name, public_key, sign_count, created, last_used) VALUES ($1, $2, $3, $4, $5, $6, $7)",
+		c.ID, c.UserID, c.Name, c.PublicKey, c.SignCount, c.Created, c.LastUsed)
+}
+
+func (s *postgresStore) GetCredential(id string) (Credential, error) {
+	var c Credential
+	err := s.conn.QueryRow("SELECT id, user_id, name, public_key, sign_count, created, last_used FROM "+s.table("_credential")+" WHERE id=$1", id).
+		Scan(&c.ID, &c.UserID, &c.Name, &c.PublicKey, &c.SignCount, &c.Created, &c.LastUsed)
+	return c, err
+}
+
+func (s *postgresStore) GetCredentials(userID int64) ([]Credential, error) {
+	rows, err := s.conn.Query("SELECT id, user_id, name, public_key, sign_count, created, last_used FROM "+s.table("_credential")+" WHERE user_id=$1 ORDER BY created", userID)
+	if err != nil {
+		return nil, err
+	}
+	return scanCredentials(rows)
+}
+
+func (s *postgresStore) UseCredential(id string, signCount int64, used time.Time) error {
+	return s.exec("UPDATE "+s.table("_credential")+" SET sign_count=$1, last_used=$2 WHERE id=$3", signCount, used, id)
+}
+
+func (s *postgresStore) RenameCredential(userID int64, id, name string) error {
+	return s.exec("UPDATE "+s.table("_credential")+" SET name=$1 WHERE id=$2 AND user_id=$3", name, id, userID)
+}
+
+func (s *postgresStore) DeleteCredential(userID int64, id string) error {
+	return s.exec("DELETE FROM "+s.table("_credential")+" WHERE id=$1 AND user_id=$2", id, userID)
+}
+
+func (s *postgresStore) AddRolePermission(role, permission string) error {
+	return s.replace("DELETE FROM "+s.table("_role_permission")+" WHERE role=$1 AND permission=$2", "INSERT INTO "+s.table("_role_permission")+" (role, permission) VALUES ($1, $2)", role, permission)
+}
+
+func (s *postgresStore) DeleteRolePermission(role, permission string) error {
+	return s.exec("DELETE FROM "+s.table("_role_permission")+" WHERE role=$1 AND permission=$2", role, permission)
+}
+
+func (s *postgresStore) GetRolePermissions(role string) ([]string, error) {
+	rows, err := s.conn.Query("SELECT permission FROM "+s.table("_role_permission")+" WHERE role=$1 ORDER BY permission", role)
+	if err != nil {
+		return nil, err
+	}
+	return scanParam(rows)
+}
+
+func (s *postgresStore) AddUserRole(userID int64, role string) error {
+	return s.replace("DELETE FROM "+s.table("_user_role")+" WHERE user_id=$1 AND role=$2", "INSERT INTO "+s.table("_user_role")+" (user_id, role) VALUES ($1, $2)", userID, role)
+}
+
+func (s *postgresStore) DeleteUserRole(userID int64, role string) error {
+	return s.exec("DELETE FROM "+s.table("_user_role")+" WHERE user_id=$1 AND role=$2", userID, role)
+}
+
+func (s *postgresStore) GetUserRoles(userID int64) ([]string, error) {
+	rows, err := s.conn.Query("SELECT role FROM "+s.table("_user_role")+" WHERE user_id=$1 ORDER BY role", userID)
+	if err != nil {
+		return nil, err
+	}
+	return scanParam(rows)
+}
+
+func (s *postgresStore) AddGroup(name string, created time.Time) (int64, error) {
+	var id int64
+	err := s.conn.QueryRow("INSERT INTO "+s.table("_group")+" (name, created) VALUES ($1, $2) RETURNING id", name, created).Scan(&id)
+	return id, err
+}
+
+func (s *postgresStore) DeleteGroup(id int64) error {
+	tx, err := s.conn.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	for _, table := range []string{"_group_member", "_group_param", "_group_role"} {
+		_, err = tx.Exec("DELETE FROM "+s.table(table)+" WHERE group_id=$1", id)
+		if err != nil {
+			return err
+		}
+	}
+	_, err = tx.Exec("DELETE FROM "+s.table("_group")+" WHERE id=$1", id)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s *postgresStore) GetGroupByID(id int64) (GroupData, error) {
+	var g GroupData
+	err := s.conn.QueryRow("SELECT id, name FROM "+s.table("_group")+" WHERE id=$1", id).Scan(&g.ID, &g.Name)
+	return g, err
+}
+
+func (s *postgresStore) GetGroupByName(name string) (GroupData, error) {
+	var g GroupData
+	err := s.conn.QueryRow("SELECT id, name FROM "+s.table("_group")+" WHERE name=$1", name).Scan(&g.ID, &g.Name)
+	return g, err
+}
+
+func (s *postgresStore) AddGroupMember(groupID, userID int64) error {
+	return s.replace("DELETE FROM "+s.table("_group_member")+" WHERE group_id=$1 AND user_id=$2", "INSERT INTO "+s.table("_group_member")+" (group_id, user_id) VALUES ($1, $2)", groupID, userID)
+}
+
+func (s *postgresStore) DeleteGroupMember(groupID, userID int64) error {
+	return s.exec("DELETE FROM "+s.table("_group_member")+" WHERE group_id=$1 AND user_id=$2", groupID, userID)
+}
+
+func (s *postgresStore) GetGroupMembers(groupID int64) ([]int64, error) {
+	rows, err := s.conn.Query("SELECT user_id FROM "+s.table("_group_member")+" WHERE group_id=$1 ORDER BY user_id", groupID)
+	if err != nil {
+		return nil, err
+	}
+	return scanIDs(rows)
+}
+
+func (s *postgresStore) GetUserGroups(userID int64) ([]int64, error) {
+	rows, err := s.conn.Query("SELECT group_id FROM "+s.table("_group_member")+" WHERE user_id=$1 ORDER BY group_id", userID)
+	if err != nil {
+		return nil, err
+	}
+	return scanIDs(rows)
+}
+
+func (s *postgresStore) AddGroupParams(groupID int64, params ...map[string]string) error {
+	tx, err := s.conn.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	for i := range params {
+		for k, v := range params[i] {
+			_, err = tx.Exec("INSERT INTO "+s.table("_group_param")+" (group_id, key, val) VALUES ($1, $2, $3)", groupID, k, v)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return tx.Commit()
+}
+
+func (s *postgresStore) GetGroupParams(groupID int64) (map[string][]string, error) {
+	rows, err := s.conn.Query("SELECT key, val FROM "+s.table("_group_param")+" WHERE group_id=$1", groupID)
+	if err != nil {
+		return map[string][]string{}, err
+	}
+	return scanParams(rows)
+}
+
+func (s *postgresStore) DeleteGroupParams(groupID int64, keys ...string) error {
+	if len(keys) == 0 {
+		return nil
+	}
+	params := []interface{}{groupID}
+	for i := range keys {
+		params = append(params, keys[i])
+	}
+	return s.exec("DELETE FROM "+s.table("_group_param")+" WHERE group_id=$1 AND key IN ("+numberedPlaceholders(2, len(keys))+")", params...)
+}
+
+func (s *postgresStore) AddGroupRole(groupID int64, role string) error {
+	return s.replace("DELETE FROM "+s.table("_group_role")+" WHERE group_id=$1 AND role=$2", "INSERT INTO "+s.table("_group_role")+" (group_id, role) VALUES ($1, $2)", groupID, role)
+}
+
+func (s *postgresStore) DeleteGroupRole(groupID int64, role string) error {
+	return s.exec("DELETE FROM "+s.table("_group_role")+" WHERE group_id=$1 AND role=$2", groupID, role)
+}
+
+func (s *postgresStore) GetGroupRoles(groupID int64) ([]string, error) {
+	rows, err := s.conn.Query("SELECT role FROM "+s.table("_group_role")+" WHERE group_id=$1 ORDER BY role", groupID)
+	if err != nil {
+		return nil, err
+	}
+	return scanParam(rows)
+}
+
+func (s *postgresStore) AddOrg(name string, created time.Time) (int64, error) {
+	var id int64
+	err := s.conn.QueryRow("INSERT INTO "+s.table("_org")+" (name, created) VALUES ($1, $2) RETURNING id", name, created).Scan(&id)
+	return id, err
+}
+
+func (s *postgresStore) DeleteOrg(id int64) error {
+	tx, err := s.conn.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	for _, table := range []string{"_org_member", "_org_role", "_org_param"} {
+		_, err = tx.Exec("DELETE FROM "+s.table(table)+" WHERE org_id=$1", id)
+		if err != nil {
+			return err
+		}
+	}
+	_, err = tx.Exec("UPDATE "+s.table("_session")+" SET org_id=0 WHERE org_id=$1", id)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec("DELETE FROM "+s.table("_org")+" WHERE id=$1", id)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s *postgresStore) GetOrgByID(id int64) (OrgData, error) {
+	var o OrgData
+	err := s.conn.QueryRow("SELECT id, name FROM "+s.table("_org")+" WHERE id=$1", id).Scan(&o.ID, &o.Name)
+	return o, err
+}
+
+func (s *postgresStore) GetOrgByName(name string) (OrgData, error) {
+	var o OrgData
+	err := s.conn.QueryRow("SELECT id, name FROM "+s.table("_org")+" WHERE name=$1", name).Scan(&o.ID, &o.Name)
+	return o, err
+}
+
+func (s *postgresStore) AddOrgMember(orgID, userID int64) error {
+	return s.replace("DELETE FROM "+s.table("_org_member")+" WHERE org_id=$1 AND user_id=$2", "INSERT INTO "+s.table("_org_member")+" (org_id, user_id) VALUES ($1, $2)", orgID, userID)
+}
+
+func (s *postgresStore) DeleteOrgMember(orgID, userID int64) error {
+	tx, err := s.conn.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	for _, table := range []string{"_org_member", "_org_role", "_org_param"} {
+		_, err = tx.Exec("DELETE FROM "+s.table(table)+" WHERE org_id=$1 AND user_id=$2", orgID, userID)
+		if err != nil {
+			return err
+		}
+	}
+	_, err = tx.Exec("UPDATE "+s.table("_session")+" SET org_id=0 WHERE org_id=$1 AND user_id=$2", orgID, userID)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s *postgresStore) GetOrgMembers(orgID int64) ([]int64, error) {
+	rows, err := s.conn.Query("SELECT user_id FROM "+s.table("_org_member")+" WHERE org_id=$1 ORDER BY user_id", orgID)
+	if err != nil {
+		return nil, err
+	}
+	return scanIDs(rows)
+}
+
+func (s *postgresStore) GetUserOrgs(userID int64) ([]int64, error) {
+	rows, err := s.conn.Query("SELECT org_id FROM "+s.table("_org_member")+" WHERE user_id=$1 ORDER BY org_id", userID)
+	if err != nil {
+		return nil, err
+	}
+	return scanIDs(rows)
+}
+
+func (s *postgresStore) AddOrgRole(orgID, userID int64, role string) error {
+	return s.replace("DELETE FROM "+s.table("_org_role")+" WHERE org_id=$1 AND user_id=$2 AND role=$3", "INSERT INTO "+s.table("_org_role")+" (org_id, user_id, role) VALUES ($1, $2, $3)", orgID, userID, role)
+}
+
+func (s *postgresStore) DeleteOrgRole(orgID, userID int64, role string) error {
+	return s.exec("DELETE FROM "+s.table("_org_role")+" WHERE org_id=$1 AND user_id=$2 AND role=$3", orgID, userID, role)
+}
+
+func (s *postgresStore) GetOrgRoles(orgID, userID int64) ([]string, error) {
+	rows, err := s.conn.Query("SELECT role FROM "+s.table("_org_role")+" WHERE org_id=$1 AND user_id=$2 ORDER BY role", orgID, userID)
+	if err != nil {
+		return nil, err
+	}
+	return scanParam(rows)
+}
+
+func (s *postgresStore) AddOrgParams(orgID, userID int64, params ...map[string]string) error {
+	tx, err := s.conn.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	for i := range params {
+		for k, v := range params[i] {
+			_, err = tx.Exec("INSERT INTO "+s.table("_org_param")+" (org_id, user_id, key, val) VALUES ($1, $2, $3, $4)", orgID, userID, k, v)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return tx.Commit()
+}
+
+func (s *postgresStore) GetOrgParams(orgID, userID int64) (map[string][]string, error) {
+	rows, err := s.conn.Query("SELECT key, val FROM "+s.table("_org_param")+" WHERE org_id=$1 AND user_id=$2", orgID, userID)
+	if err != nil {
+		return map[string][]string{}, err
+	}
+	return scanParams(rows)
+}
+
+func (s *postgresStore) DeleteOrgParams(orgID, userID int64, keys ...string) error {
+	if len(keys) == 0 {
+		return nil
+	}
+	params := []interface{}{orgID, userID}
+	for i := range keys {
+		params = append(params, keys[i])
+	}
+	return s.exec("DELETE FROM "+s.table("_org_param")+" WHERE org_id=$1 AND user_id=$2 AND key IN ("+numberedPlaceholders(3, len(keys))+")", params...)
+}
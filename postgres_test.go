@@ -0,0 +1,56 @@
+package baxtep
+
+import (
+	"context"
+	"database/sql"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestPostgresSchema(t *testing.T) {
+	s := NewPostgresStore(nil, "user").(*postgresStore)
+	meta := s.meta()
+	queries := []string{meta.create, meta.get, meta.insert, meta.update}
+	for _, m := range s.migrations() {
+		queries = append(queries, m...)
+	}
+	if n := len(s.migrations()); n != len(NewSQLiteStore(nil, "user").(*sqliteStore).migrations()) {
+		t.Errorf("%d migrations, other stores have a different number", n)
+	}
+	for _, q := range queries {
+		// MySQL quoting and placeholders
+		if strings.ContainsAny(q, "`?") {
+			t.Errorf("not PostgreSQL: %s", q)
+		}
+	}
+}
+
+// TestPostgresStore needs an empty database, BAXTEP_TEST_POSTGRES is its DSN,
+// and a "postgres" or "pgx" driver must be linked into the test.
+func TestPostgresStore(t *testing.T) {
+	dsn := os.Getenv("BAXTEP_TEST_POSTGRES")
+	if dsn == "" {
+		t.Skip("BAXTEP_TEST_POSTGRES is not set")
+	}
+	driver := ""
+	for _, d := range sql.Drivers() {
+		if d == "postgres" || d == "pgx" {
+			driver = d
+		}
+	}
+	if driver == "" {
+		t.Skip("no PostgreSQL driver")
+	}
+	db, err := sql.Open(driver, dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	s := NewPostgresStore(db, "baxtep_test")
+	err = s.Migrate(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	testStore(t, s)
+}
@@ -0,0 +1,868 @@
+package baxtep
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+type qlStore struct {
+	conn   *sql.DB
+	prefix string
+}
+
+// NewQLStore returns a Store for the cznic/ql "ql" and "ql-mem" drivers.
+func NewQLStore(db *sql.DB, prefix string) Store {
+	return &qlStore{conn: db, prefix: prefix}
+}
+
+func (s *qlStore) Migrate(ctx context.Context) error {
+	return migrate(ctx, s.conn, s.meta(), s.migrations())
+}
+
+func (s *qlStore) SchemaVersion() (int, error) {
+	return schemaVersion(s.conn, s.meta())
+}
+
+func (s *qlStore) meta() metaQueries {
+	return metaQueries{
+		create: "CREATE TABLE IF NOT EXISTS " + s.prefix + "_meta (name string, value string);",
+		get:    "SELECT value FROM " + s.prefix + "_meta WHERE name=$1",
+		insert: "INSERT INTO " + s.prefix + "_meta (name, value) VALUES ($1, $2)",
+		update: "UPDATE " + s.prefix + "_meta SET value=$1 WHERE name=$2",
+	}
+}
+
+func (s *qlStore) migrations() []migration {
+	return []migration{
+		// 1: users and params
+		{
+			fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s ("+
+				" name string,"+
+				" password string,"+
+				" email string,"+
+				" registration_time time,"+
+				" enable bool,"+
+				" session_id string,"+
+				" session_time time"+
+				");",
+				s.prefix),
+			fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s_param ("+
+				" user_id int,"+
+				" key string,"+
+				" val string"+
+				");",
+				s.prefix),
+		},
+		// 2: ql strings are unbounded
+		{},
+		// 3: sessions move to their own table, session_id stays
+		// for registration confirmation codes only
+		{
+			"CREATE TABLE IF NOT EXISTS " + s.prefix + "_session (" +
+				" id string," +
+				" user_id int," +
+				" created time," +
+				" last_seen time," +
+				" user_agent string," +
+				" ip string" +
+				");",
+			"UPDATE " + s.prefix + " SET session_id=\"\" WHERE enable OR session_time IS NOT NULL;",
+		},
+		// 4: single-use tokens
+		{
+			"CREATE TABLE IF NOT EXISTS " + s.prefix + "_token (" +
+				" id string," +
+				" user_id int," +
+				" kind string," +
+				" expires time" +
+				");",
+		},
+		// 5: token payload
+		{
+			"ALTER TABLE " + s.prefix + "_token ADD data string;",
+			"UPDATE " + s.prefix + "_token SET data=\"\";",
+		},
+		// 6: failed logins and lockout, locked_until is unix time
+		{
+			"ALTER TABLE " + s.prefix + " ADD failed_logins int64;",
+			"ALTER TABLE " + s.prefix + " ADD locked_until int64;",
+			"UPDATE " + s.prefix + " SET failed_logins=0, locked_until=0;",
+		},
+		// 7: encrypted TOTP secret and the last used time step
+		{
+			"ALTER TABLE " + s.prefix + " ADD totp_secret string;",
+			"ALTER TABLE " + s.prefix + " ADD totp_step int64;",
+			"UPDATE " + s.prefix + " SET totp_secret=\"\", totp_step=0;",
+		},
+		// 8: 2FA recovery codes
+		{
+			"CREATE TABLE IF NOT EXISTS " + s.prefix + "_recovery (" +
+				" id string," +
+				" user_id int" +
+				");",
+		},
+		// 9: WebAuthn credentials, id is the base64url credential ID
+		{
+			"CREATE TABLE IF NOT EXISTS " + s.prefix + "_credential (" +
+				" id string," +
+				" user_id int," +
+				" name string," +
+				" public_key string," +
+				" sign_count int64," +
+				" created time," +
+				" last_used time" +
+				");",
+		},
+		// 10: roles of users and permissions of roles, "role" params become roles
+		{
+			"CREATE TABLE IF NOT EXISTS " + s.prefix + "_role_permission (" +
+				" role string," +
+				" permission string" +
+				");",
+			"CREATE TABLE IF NOT EXISTS " + s.prefix + "_user_role (" +
+				" user_id int," +
+				" role string" +
+				");",
+			"INSERT INTO " + s.prefix + "_user_role (user_id, role)" +
+				" SELECT DISTINCT user_id, val FROM " + s.prefix + "_param WHERE key=\"role\";",
+		},
+		// 11: groups of users with their params and roles
+		{
+			"CREATE TABLE IF NOT EXISTS " + s.prefix + "_group (" +
+				" name string," +
+				" created time" +
+				");",
+			"CREATE TABLE IF NOT EXISTS " + s.prefix + "_group_member (" +
+				" group_id int," +
+				" user_id int" +
+				");",
+			"CREATE TABLE IF NOT EXISTS " + s.prefix + "_group_param (" +
+				" group_id int," +
+				" key string," +
+				" val string" +
+				");",
+			"CREATE TABLE IF NOT EXISTS " + s.prefix + "_group_role (" +
+				" group_id int," +
+				" role string" +
+				");",
+		},
+		// 12: organizations with per-org roles and params, the active org of sessions
+		{
+			"CREATE TABLE IF NOT EXISTS " + s.prefix + "_org (" +
+				" name string," +
+				" created time" +
+				");",
+			"CREATE TABLE IF NOT EXISTS " + s.prefix + "_org_member (" +
+				" org_id int," +
+				" user_id int" +
+				");",
+			"CREATE TABLE IF NOT EXISTS " + s.prefix + "_org_role (" +
+				" org_id int," +
+				" user_id int," +
+				" role string" +
+				");",
+			"CREATE TABLE IF NOT EXISTS " + s.prefix + "_org_param (" +
+				" org_id int," +
+				" user_id int," +
+				" key string," +
+				" val string" +
+				");",
+			"ALTER TABLE " + s.prefix + "_session ADD org_id int;",
+			"UPDATE " + s.prefix + "_session SET org_id=0;",
+		},
+	}
+}
+
+func (s *qlStore) exec(query string, args ...interface{}) error {
+	tx, err := s.conn.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	_, err = tx.Exec(query, args...)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// replace runs del and ins with the same arguments in a transaction,
+// so that adding a row twice keeps one.
+func (s *qlStore) replace(del, ins string, args ...interface{}) error {
+	tx, err := s.conn.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	_, err = tx.Exec(del, args...)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(ins, args...)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s *qlStore) AddUser(name, email, passhash, confirm string, registered time.Time) (int64, error) {
+	tx, err := s.conn.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+	res, err := tx.Exec("INSERT INTO "+s.prefix+"(name, password, email, registration_time, session_id, enable) VALUES ($1, $2, $3, $4, $5, false)", name, passhash, email, registered, confirm)
+	if err != nil {
+		return 0, err
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+	return id, tx.Commit()
+}
+
+func (s *qlStore) DeleteUser(id int64) error {
+	tx, err := s.conn.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	for _, table := range []string{"_param", "_org_member", "_org_role", "_org_param"} {
+		_, err = tx.Exec("DELETE FROM "+s.prefix+table+" WHERE user_id=$1", id)
+		if err != nil {
+			return err
+		}
+	}
+	_, err = tx.Exec("DELETE FROM "+s.prefix+"_group_member WHERE user_id=$1", id)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec("DELETE FROM "+s.prefix+"_token WHERE user_id=$1", id)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec("DELETE FROM "+s.prefix+"_user_role WHERE user_id=$1", id)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec("DELETE FROM "+s.prefix+"_credential WHERE user_id=$1", id)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec("DELETE FROM "+s.prefix+"_recovery WHERE user_id=$1", id)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec("DELETE FROM "+s.prefix+"_session WHERE user_id=$1", id)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec("DELETE FROM "+s.prefix+" WHERE id()=$1", id)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s *qlStore) getUser(where string, arg interface{}) (UserData, error) {
+	var u UserData
+	err := s.conn.QueryRow("SELECT id(), name, email, enable FROM "+s.prefix+" WHERE "+where, arg).Scan(&u.ID, &u.Name, &u.Email, &u.Enable)
+	return u, err
+}
+
+func (s *qlStore) GetUserByID(id int64) (UserData, error) {
+	return s.getUser("id()=$1", id)
+}
+
+func (s *qlStore) GetUserByName(name string) (UserData, error) {
+	return s.getUser("name=$1", name)
+}
+
+func (s *qlStore) GetUserByEmail(email string) (UserData, error) {
+	return s.getUser("email=$1", email)
+}
+
+func (s *qlStore) GetUserByConfirm(confirm string) (UserData, error) {
+	return s.getUser("session_id=$1", confirm)
+}
+
+func (s *qlStore) CountUserName(name string) (int64, error) {
+	var count int64
+	err := s.conn.QueryRow("SELECT count(*) FROM "+s.prefix+" WHERE name=$1", name).Scan(&count)
+	return count, err
+}
+
+func (s *qlStore) CountUserEmail(email string) (int64, error) {
+	var count int64
+	err := s.conn.QueryRow("SELECT count(*) FROM "+s.prefix+" WHERE email=$1", email).Scan(&count)
+	return count, err
+}
+
+func (s *qlStore) SetUserEnable(id int64, enable bool) error {
+	return s.exec("UPDATE "+s.prefix+" SET enable=$1 WHERE id()=$2", enable, id)
+}
+
+func (s *qlStore) SetUserEmail(id int64, email string) error {
+	return s.exec("UPDATE "+s.prefix+" SET email=$1 WHERE id()=$2", email, id)
+}
+
+func (s *qlStore) GetUserPassword(id int64) (string, error) {
+	var passhash sql.NullString
+	err := s.conn.QueryRow("SELECT password FROM "+s.prefix+" WHERE id()=$1", id).Scan(&passhash)
+	return passhash.String, err
+}
+
+func (s *qlStore) SetUserPassword(id int64, passhash string) error {
+	return s.exec("UPDATE "+s.prefix+" SET password=$1 WHERE id()=$2", passhash, id)
+}
+
+func (s *qlStore) GetUserLock(id int64) (int, time.Time, error) {
+	var failures, until sql.NullInt64
+	err := s.conn.QueryRow("SELECT failed_logins, locked_until FROM "+s.prefix+" WHERE id()=$1", id).Scan(&failures, &until)
+	return int(failures.Int64), time.Unix(until.Int64, 0).UTC(), err
+}
+
+func (s *qlStore) AddUserFailure(id int64) (int, error) {
+	var failures sql.NullInt64
+	tx, err := s.conn.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+	err = tx.QueryRow("SELECT failed_logins FROM "+s.prefix+" WHERE id()=$1", id).Scan(&failures)
+	if err != nil {
+		return 0, err
+	}
+	_, err = tx.Exec("UPDATE "+s.prefix+" SET failed_logins=$1 WHERE id()=$2", failures.Int64+1, id)
+	if err != nil {
+		return 0, err
+	}
+	return int(failures.Int64) + 1, tx.Commit()
+}
+
+func (s *qlStore) SetUserLock(id int64, failures int, until time.Time) error {
+	return s.exec("UPDATE "+s.prefix+" SET failed_logins=$1, locked_until=$2 WHERE id()=$3", int64(failures), until.Unix(), id)
+}
+
+func (s *qlStore) GetUserTOTP(id int64) (string, int64, error) {
+	var secret sql.NullString
+	var step sql.NullInt64
+	err := s.conn.QueryRow("SELECT totp_secret, totp_step FROM "+s.prefix+" WHERE id()=$1", id).Scan(&secret, &step)
+	return secret.String, step.Int64, err
+}
+
+func (s *qlStore) SetUserTOTP(id int64, secret string, step int64) error {
+	return s.exec("UPDATE "+s.prefix+" SET totp_secret=$1, totp_step=$2 WHERE id()=$3", secret, step, id)
+}
+
+func (s *qlStore) SetUserConfirm(id int64, confirm string) error {
+	return s.exec("UPDATE "+s.prefix+" SET session_id=$1 WHERE id()=$2", confirm, id)
+}
+
+func (s *qlStore) AddSession(session Session) error {
+	return s.exec("INSERT INTO "+s.prefix+"_session (id, user_id, created, last_seen, user_agent, ip, org_id) VALUES ($1, $2, $3, $4, $5, $6, $7)",
+		session.ID, session.UserID, session.Created, session.LastSeen, session.UserAgent, session.IP, session.OrgID)
+}
+
+func (s *qlStore) GetSession(id string) (Session, error) {
+	var session Session
+	err := s.conn.QueryRow("SELECT id, user_id, created, last_seen, user_agent, ip, org_id FROM "+s.prefix+"_session WHERE id=$1", id).
+		Scan(&session.ID, &session.UserID, &session.Created, &session.LastSeen, &session.UserAgent, &session.IP, &session.OrgID)
+	return session, err
+}
+
+func (s *qlStore) GetSessions(userID int64) ([]Session, error) {
+	rows, err := s.conn.Query("SELECT id, user_id, created, last_seen, user_agent, ip, org_id FROM "+s.prefix+"_session WHERE user_id=$1 ORDER BY created", userID)
+	if err != nil {
+		return nil, err
+	}
+	return scanSessions(rows)
+}
+
+func (s *qlStore) TouchSession(id string, t time.Time) error {
+	return s.exec("UPDATE "+s.prefix+"_session SET last_seen=$1 WHERE id=$2", t, id)
+}
+
+func (s *qlStore) DeleteSession(userID int64, id string) error {
+	return s.exec("DELETE FROM "+s.prefix+"_session WHERE user_id=$1 AND id=$2", userID, id)
+}
+
+func (s *qlStore) DeleteSessions(userID int64) error {
+	return s.exec("DELETE FROM "+s.prefix+"_session WHERE user_id=$1", userID)
+}
+
+func (s *qlStore) SetSessionOrg(id string, orgID int64) error {
+	return s.exec("UPDATE "+s.prefix+"_session SET org_id=$1 WHERE id=$2", orgID, id)
+}
+
+func (s *qlStore) AddParams(userID int64, params ...map[string]string) error {
+	tx, err := s.conn.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	for i := range params {
+		for k, v := range params[i] {
+			_, err = tx.Exec("INSERT INTO "+s.prefix+"_param (user_id, key, val) VALUES ($1, $2, $3)", userID, k, v)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return tx.Commit()
+}
+
+func (s *qlStore) HasParam(userID int64, key string) (bool, error) {
+	var cnt int64
+	err := s.conn.QueryRow("SELECT count(*) FROM "+s.prefix+"_param WHERE user_id=$1 AND key=$2", userID, key).Scan(&cnt)
+	return cnt > 0, err
+}
+
+func (s *qlStore) HasParamValue(userID int64, key, value string) (bool, error) {
+	var cnt int64
+	err := s.conn.QueryRow("SELECT count(*) FROM "+s.prefix+"_param WHERE user_id=$1 AND key=$2 AND val=$3", userID, key, value).Scan(&cnt)
+	return cnt > 0, err
+}
+
+func (s *qlStore) GetParam(userID int64, key string) ([]string, error) {
+	rows, err := s.conn.Query("SELECT val FROM "+s.prefix+"_param WHERE user_id=$1 AND key=$2", userID, key)
+	if err != nil {
+		return nil, err
+	}
+	return scanParam(rows)
+}
+
+func (s *qlStore) GetParams(userID int64) (map[string][]string, error) {
+	rows, err := s.conn.Query("SELECT key, val FROM "+s.prefix+"_param WHERE user_id=$1", userID)
+	if err != nil {
+		return map[string][]string{}, err
+	}
+	return scanParams(rows)
+}
+
+func (s *qlStore) DeleteParams(userID int64, keys ...string) error {
+	if len(keys) == 0 {
+		return nil
+	}
+	params := []interface{}{userID}
+	for i := range keys {
+		params = append(params, keys[i])
+	}
+	return s.exec("DELETE FROM "+s.prefix+"_param WHERE user_id=$1 AND key IN ("+numberedPlaceholders(2, len(keys))+")", params...)
+}
+
+func (s *qlStore) AddToken(t Token) error {
+	return s.exec("INSERT INTO "+s.prefix+"_token (id, user_id, kind, expires, data) VALUES ($1, $2, $3, $4, $5)", t.ID, t.UserID, t.Kind, t.Expires, t.Data)
+}
+
+func (s *qlStore) GetToken(id, kind string) (Token, error) {
+	var t Token
+	err := s.conn.QueryRow("SELECT id, user_id, kind, expires, data FROM "+s.prefix+"_token WHERE id=$1 AND kind=$2", id, kind).
+		Scan(&t.ID, &t.UserID, &t.Kind, &t.Expires, &t.Data)
+	return t, err
+}
+
+func (s *qlStore) TakeToken(id, kind string) (Token, error) {
+	var t Token
+	tx, err := s.conn.Begin()
+	if err != nil {
+		return t, err
+	}
+	defer tx.Rollback()
+	err = tx.QueryRow("SELECT id, user_id, kind, expires, data FROM "+s.prefix+"_token WHERE id=$1 AND kind=$2", id, kind).
+		Scan(&t.ID, &t.UserID, &t.Kind, &t.Expires, &t.Data)
+	if err != nil {
+		return t, err
+	}
+	res, err := tx.Exec("DELETE FROM "+s.prefix+"_token WHERE id=$1", id)
+	if err != nil {
+		return t, err
+	}
+	if n, err := res.RowsAffected(); err != nil || n != 1 {
+		return t, sql.ErrNoRows
+	}
+	return t, tx.Commit()
+}
+
+func (s *qlStore) DeleteTokens(userID int64, kind string) error {
+	return s.exec("DELETE FROM "+s.prefix+"_token WHERE user_id=$1 AND kind=$2", userID, kind)
+}
+
+func (s *qlStore) DeleteExpiredTokens(kind string, now time.Time) error {
+	return s.exec("DELETE FROM "+s.prefix+"_token WHERE kind=$1 AND expires<$2", kind, now)
+}
+
+func (s *qlStore) SetRecoveryCodes(userID int64, ids []string) error {
+	tx, err := s.conn.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	_, err = tx.Exec("DELETE FROM "+s.prefix+"_recovery WHERE user_id=$1", userID)
+	if err != nil {
+		return err
+	}
+	for i := range ids {
+		_, err = tx.Exec("INSERT INTO "+s.prefix+"_recovery (id, user_id) VALUES ($1, $2)", ids[i], userID)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func (s *qlStore) TakeRecoveryCode(userID int64, id string) error {
+	tx, err := s.conn.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	res, err := tx.Exec("DELETE FROM "+s.prefix+"_recovery WHERE id=$1 AND user_id=$2", id, userID)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil || n != 1 {
+		return sql.ErrNoRows
+	}
+	return tx.Commit()
+}
+
+func (s *qlStore) CountRecoveryCodes(userID int64) (int, error) {
+	var count int64
+	err := s.conn.QueryRow("SELECT count(*) FROM "+s.prefix+"_recovery WHERE user_id=$1", userID).Scan(&count)
+	return int(count), err
+}
+
+func (s *qlStore) AddCredential(c Credential) error {
+	return s.exec("INSERT INTO "+s.prefix+"_credential (id, user_id, name, public_key, sign_count, created, last_used) VALUES ($1, $2, $3, $4, $5, $6, $7)",
+		c.ID, c.UserID, c.Name, c.PublicKey, c.SignCount, c.Created, c.LastUsed)
+}
+
+func (s *qlStore) GetCredential(id string) (Credential, error) {
+	var c Credential
+	err := s.conn.QueryRow("SELECT id, user_id, name, public_key, sign_count, created, last_used FROM "+s.prefix+"_credential WHERE id=$1", id).
+		Scan(&c.ID, &c.UserID, &c.Name, &c.PublicKey, &c.SignCount, &c.Created, &c.LastUsed)
+	return c, err
+}
+
+func (s *qlStore) GetCredentials(userID int64) ([]Credential, error) {
+	rows, err := s.conn.Query("SELECT id, user_id, name, public_key, sign_count, created, last_used FROM "+s.prefix+"_credential WHERE user_id=$1 ORDER BY created", userID)
+	if err != nil {
+		return nil, err
+	}
+	return scanCredentials(rows)
+}
+
+func (s *qlStore) UseCredential(id string, signCount int64, used time.Time) error {
+	return s.exec("UPDATE "+s.prefix+"_credential SET sign_count=$1, last_used=$2 WHERE id=$3", signCount, used, id)
+}
+
+func (s *qlStore) RenameCredential(userID int64, id, name string) error {
+	return s.exec("UPDATE "+s.prefix+"_credential SET name=$1 WHERE id=$2 AND user_id=$3", name, id, userID)
+}
+
+func (s *qlStore) DeleteCredential(userID int64, id string) error {
+	return s.exec("DELETE FROM "+s.prefix+"_credential WHERE id=$1 AND user_id=$2", id, userID)
+}
+
+func (s *qlStore) AddRolePermission(role, permission string) error {
+	return s.replace("DELETE FROM "+s.prefix+"_role_permission WHERE role=$1 AND permission=$2", "INSERT INTO "+s.prefix+"_role_permission (role, permission) VALUES ($1, $2)", role, permission)
+}
+
+func (s *qlStore) DeleteRolePermission(role, permission string) error {
+	return s.exec("DELETE FROM "+s.prefix+"_role_permission WHERE role=$1 AND permission=$2", role, permission)
+}
+
+func (s *qlStore) GetRolePermissions(role string) ([]string, error) {
+	rows, err := s.conn.Query("SELECT permission FROM "+s.prefix+"_role_permission WHERE role=$1 ORDER BY permission", role)
+	if err != nil {
+		return nil, err
+	}
+	return scanParam(rows)
+}
+
+func (s *qlStore) AddUserRole(userID int64, role string) error {
+	return s.replace("DELETE FROM "+s.prefix+"_user_role WHERE user_id=$1 AND role=$2", "INSERT INTO "+s.prefix+"_user_role (user_id, role) VALUES ($1, $2)", userID, role)
+}
+
+func (s *qlStore) DeleteUserRole(userID int64, role string) error {
+	return s.exec("DELETE FROM "+s.prefix+"_user_role WHERE user_id=$1 AND role=$2", userID, role)
+}
+
+func (s *qlStore) GetUserRoles(userID int64) ([]string, error) {
+	rows, err := s.conn.Query("SELECT role FROM "+s.prefix+"_user_role WHERE user_id=$1 ORDER BY role", userID)
+	if err != nil {
+		return nil, err
+	}
+	return scanParam(rows)
+}
+
+func (s *qlStore) AddGroup(name string, created time.Time) (int64, error) {
+	tx, err := s.conn.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+	res, err := tx.Exec("INSERT INTO "+s.prefix+"_group (name, created) VALUES ($1, $2)", name, created)
+	if err != nil {
+		return 0, err
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+	return id, tx.Commit()
+}
+
+func (s *qlStore) DeleteGroup(id int64) error {
+	tx, err := s.conn.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	for _, table := range []string{"_group_member", "_group_param", "_group_role"} {
+		_, err = tx.Exec("DELETE FROM "+s.prefix+table+" WHERE group_id=$1", id)
+		if err != nil {
+			return err
+		}
+	}
+	_, err = tx.Exec("DELETE FROM "+s.prefix+"_group WHERE id()=$1", id)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s *qlStore) GetGroupByID(id int64) (GroupData, error) {
+	var g GroupData
+	err := s.conn.QueryRow("SELECT id(), name FROM "+s.prefix+"_group WHERE id()=$1", id).Scan(&g.ID, &g.Name)
+	return g, err
+}
+
+func (s *qlStore) GetGroupByName(name string) (GroupData, error) {
+	var g GroupData
+	err := s.conn.QueryRow("SELECT id(), name FROM "+s.prefix+"_group WHERE name=$1", name).Scan(&g.ID, &g.Name)
+	return g, err
+}
+
+func (s *qlStore) AddGroupMember(groupID, userID int64) error {
+	return s.replace("DELETE FROM "+s.prefix+"_group_member WHERE group_id=$1 AND user_id=$2", "INSERT INTO "+s.prefix+"_group_member (group_id, user_id) VALUES ($1, $2)", groupID, userID)
+}
+
+func (s *qlStore) DeleteGroupMember(groupID, userID int64) error {
+	return s.exec("DELETE FROM "+s.prefix+"_group_member WHERE group_id=$1 AND user_id=$2", groupID, userID)
+}
+
+func (s *qlStore) GetGroupMembers(groupID int64) ([]int64, error) {
+	rows, err := s.conn.Query("SELECT user_id FROM "+s.prefix+"_group_member WHERE group_id=$1 ORDER BY user_id", groupID)
+	if err != nil {
+		return nil, err
+	}
+	return scanIDs(rows)
+}
+
+func (s *qlStore) GetUserGroups(userID int64) ([]int64, error) {
+	rows, err := s.conn.Query("SELECT group_id FROM "+s.prefix+"_group_member WHERE user_id=$1 ORDER BY group_id", userID)
+	if err != nil {
+		return nil, err
+	}
+	return scanIDs(rows)
+}
+
+func (s *qlStore) AddGroupParams(groupID int64, params ...map[string]string) error {
+	tx, err := s.conn.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	for i := range params {
+		for k, v := range params[i] {
+			_, err = tx.Exec("INSERT INTO "+s.prefix+"_group_param (group_id, key, val) VALUES ($1, $2, $3)", groupID, k, v)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return tx.Commit()
+}
+
+func (s *qlStore) GetGroupParams(groupID int64) (map[string][]string, error) {
+	rows, err := s.conn.Query("SELECT key, val FROM "+s.prefix+"_group_param WHERE group_id=$1", groupID)
+	if err != nil {
+		return map[string][]string{}, err
+	}
+	return scanParams(rows)
+}
+
+func (s *qlStore) DeleteGroupParams(groupID int64, keys ...string) error {
+	if len(keys) == 0 {
+		return nil
+	}
+	params := []interface{}{groupID}
+	for i := range keys {
+		params = append(params, keys[i])
+	}
+	return s.exec("DELETE FROM "+s.prefix+"_group_param WHERE group_id=$1 AND key IN ("+numberedPlaceholders(2, len(keys))+")", params...)
+}
+
+func (s *qlStore) AddGroupRole(groupID int64, role string) error {
+	return s.replace("DELETE FROM "+s.prefix+"_group_role WHERE group_id=$1 AND role=$2", "INSERT INTO "+s.prefix+"_group_role (group_id, role) VALUES ($1, $2)", groupID, role)
+}
+
+func (s *qlStore) DeleteGroupRole(groupID int64, role string) error {
+	return s.exec("DELETE FROM "+s.prefix+"_group_role WHERE group_id=$1 AND role=$2", groupID, role)
+}
+
+func (s *qlStore) GetGroupRoles(groupID int64) ([]string, error) {
+	rows, err := s.conn.Query("SELECT role FROM "+s.prefix+"_group_role WHERE group_id=$1 ORDER BY role", groupID)
+	if err != nil {
+		return nil, err
+	}
+	return scanParam(rows)
+}
+
+func (s *qlStore) AddOrg(name string, created time.Time) (int64, error) {
+	tx, err := s.conn.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+	res, err := tx.Exec("INSERT INTO "+s.prefix+"_org (name, created) VALUES ($1, $2)", name, created)
+	if err != nil {
+		return 0, err
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+	return id, tx.Commit()
+}
+
+func (s *qlStore) DeleteOrg(id int64) error {
+	tx, err := s.conn.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	for _, table := range []string{"_org_member", "_org_role", "_org_param"} {
+		_, err = tx.Exec("DELETE FROM "+s.prefix+table+" WHERE org_id=$1", id)
+		if err != nil {
+			return err
+		}
+	}
+	_, err = tx.Exec("UPDATE "+s.prefix+"_session SET org_id=0 WHERE org_id=$1", id)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec("DELETE FROM "+s.prefix+"_org WHERE id()=$1", id)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s *qlStore) GetOrgByID(id int64) (OrgData, error) {
+	var o OrgData
+	err := s.conn.QueryRow("SELECT id(), name FROM "+s.prefix+"_org WHERE id()=$1", id).Scan(&o.ID, &o.Name)
+	return o, err
+}
+
+func (s *qlStore) GetOrgByName(name string) (OrgData, error) {
+	var o OrgData
+	err := s.conn.QueryRow("SELECT id(), name FROM "+s.prefix+"_org WHERE name=$1", name).Scan(&o.ID, &o.Name)
+	return o, err
+}
+
+func (s *qlStore) AddOrgMember(orgID, userID int64) error {
+	return s.replace("DELETE FROM "+s.prefix+"_org_member WHERE org_id=$1 AND user_id=$2", "INSERT INTO "+s.prefix+"_org_member (org_id, user_id) VALUES ($1, $2)", orgID, userID)
+}
+
+func (s *qlStore) DeleteOrgMember(orgID, userID int64) error {
+	tx, err := s.conn.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	for _, table := range []string{"_org_member", "_org_role", "_org_param"} {
+		_, err = tx.Exec("DELETE FROM "+s.prefix+table+" WHERE org_id=$1 AND user_id=$2", orgID, userID)
+		if err != nil {
+			return err
+		}
+	}
+	_, err = tx.Exec("UPDATE "+s.prefix+"_session SET org_id=0 WHERE org_id=$1 AND user_id=$2", orgID, userID)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s *qlStore) GetOrgMembers(orgID int64) ([]int64, error) {
+	rows, err := s.conn.Query("SELECT user_id FROM "+s.prefix+"_org_member WHERE org_id=$1 ORDER BY user_id", orgID)
+	if err != nil {
+		return nil, err
+	}
+	return scanIDs(rows)
+}
+
+func (s *qlStore) GetUserOrgs(userID int64) ([]int64, error) {
+	rows, err := s.conn.Query("SELECT org_id FROM "+s.prefix+"_org_member WHERE user_id=$1 ORDER BY org_id", userID)
+	if err != nil {
+		return nil, err
+	}
+	return scanIDs(rows)
+}
+
+func (s *qlStore) AddOrgRole(orgID, userID int64, role string) error {
+	return s.replace("DELETE FROM "+s.prefix+"_org_role WHERE org_id=$1 AND user_id=$2 AND role=$3", "INSERT INTO "+s.prefix+"_org_role (org_id, user_id, role) VALUES ($1, $2, $3)", orgID, userID, role)
+}
+
+func (s *qlStore) DeleteOrgRole(orgID, userID int64, role string) error {
+	return s.exec("DELETE FROM "+s.prefix+"_org_role WHERE org_id=$1 AND user_id=$2 AND role=$3", orgID, userID, role)
+}
+
+func (s *qlStore) GetOrgRoles(orgID, userID int64) ([]string, error) {
+	rows, err := s.conn.Query("SELECT role FROM "+s.prefix+"_org_role WHERE org_id=$1 AND user_id=$2 ORDER BY role", orgID, userID)
+	if err != nil {
+		return nil, err
+	}
+	return scanParam(rows)
+}
+
+func (s *qlStore) AddOrgParams(orgID, userID int64, params ...map[string]string) error {
+	tx, err := s.conn.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	for i := range params {
+		for k, v := range params[i] {
+			_, err = tx.Exec("INSERT INTO "+s.prefix+"_org_param (org_id, user_id, key, val) VALUES ($1, $2, $3, $4)", orgID, userID, k, v)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return tx.Commit()
+}
+
+func (s *qlStore) GetOrgParams(orgID, userID int64) (map[string][]string, error) {
+	rows, err := s.conn.Query("SELECT key, val FROM "+s.prefix+"_org_param WHERE org_id=$1 AND user_id=$2", orgID, userID)
+	if err != nil {
+		return map[string][]string{}, err
+	}
+	return scanParams(rows)
+}
+
+func (s *qlStore) DeleteOrgParams(orgID, userID int64, keys ...string) error {
+	if len(keys) == 0 {
+		return nil
+	}
+	params := []interface{}{orgID, userID}
+	for i := range keys {
+		params = append(params, keys[i])
+	}
+	return s.exec("DELETE FROM "+s.prefix+"_org_param WHERE org_id=$1 AND user_id=$2 AND key IN ("+numberedPlaceholders(3, len(keys))+")", params...)
+}
@@ -0,0 +1,71 @@
+package baxtep
+
+import (
+	"database/sql"
+	"strings"
+)
+
+const recoveryCodes = 10
+
+// recoveryGenerator has no look-alike characters, codes are typed by hand.
+var recoveryGenerator = &TokenGenerator{Alphabet: "abcdefghijkmnpqrstuvwxyz23456789"}
+
+// normalizeRecoveryCode lets users type codes in any case and with
+// or without the dash.
+func normalizeRecoveryCode(code string) string {
+	code = strings.ToLower(code)
+	return strings.NewReplacer("-", "", " ", "").Replace(code)
+}
+
+// NewRecoveryCodes replaces the user's 2FA recovery codes with new ones
+// and returns them, only their hashes are kept. Each code works once.
+func (u *User) NewRecoveryCodes() ([]string, error) {
+	codes := make([]string, recoveryCodes)
+	ids := make([]string, recoveryCodes)
+	for i := range codes {
+		code, err := recoveryGenerator.Generate(10)
+		if err != nil {
+			return nil, err
+		}
+		codes[i] = code[:5] + "-" + code[5:]
+		ids[i] = hashToken(code)
+	}
+	err := u.b.store.SetRecoveryCodes(u.id, ids)
+	if err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// UseRecoveryCode uses up a recovery code in place of a TOTP code.
+// Wrong codes count for the lockout like wrong passwords.
+func (u *User) UseRecoveryCode(code string) error {
+	_, err := u.checkLock()
+	if err != nil {
+		return err
+	}
+	err = u.b.store.TakeRecoveryCode(u.id, hashToken(normalizeRecoveryCode(code)))
+	if err == sql.ErrNoRows {
+		err = u.loginFailed()
+		if err != nil {
+			return err
+		}
+		return ErrUserBadCode
+	}
+	return err
+}
+
+// RecoveryCodesLeft returns the number of unused recovery codes.
+func (u *User) RecoveryCodesLeft() (int, error) {
+	return u.b.store.CountRecoveryCodes(u.id)
+}
+
+// verifySecondFactor takes a TOTP code or, if it does not look like one,
+// a recovery code.
+func (u *User) verifySecondFactor(code string) error {
+	code = strings.TrimSpace(code)
+	if len(code) == totpDigits && strings.Trim(code, "0123456789") == "" {
+		return u.VerifyTOTP(code)
+	}
+	return u.UseRecoveryCode(code)
+}
@@ -0,0 +1,94 @@
+package baxtep
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// RequireLogin lets only logged in users through to h. Browsers of
+// anonymous users are redirected to the login page, API clients get 401.
+func (uh *Handler) RequireLogin(h http.Handler) http.Handler {
+	return uh.RequireFunc(func(User) bool { return true })(h)
+}
+
+// RequireEnabled is RequireLogin for users that are not disabled.
+func (uh *Handler) RequireEnabled(h http.Handler) http.Handler {
+	return uh.RequireFunc(func(u User) bool { return u.Enable })(h)
+}
+
+// RequireFunc is a middleware that lets through logged in users allow
+// returns true for, others get 403.
+func (uh *Handler) RequireFunc(allow func(User) bool) func(http.Handler) http.Handler {
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx := uh.check(w, r)
+			user, ok := UserFromContext(ctx)
+			if !ok {
+				uh.unauthorized(w, r)
+				return
+			}
+			if !allow(user) {
+				uh.forbidden(w, r)
+				return
+			}
+			h.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// Require is a middleware that lets only users with the permission
+// through, see RequireFunc.
+func (uh *Handler) Require(permission string) func(http.Handler) http.Handler {
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx := uh.check(w, r)
+			user, ok := UserFromContext(ctx)
+			if !ok {
+				uh.unauthorized(w, r)
+				return
+			}
+			allowed, err := user.HasPermission(permission)
+			if err != nil {
+				uh.logPrintf("Require HasPermission error: %s", err)
+				http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+				return
+			}
+			if !allowed {
+				uh.forbidden(w, r)
+				return
+			}
+			h.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// unauthorized sends browsers to the login page and back after it.
+func (uh *Handler) unauthorized(w http.ResponseWriter, r *http.Request) {
+	if wantsJSON(r) {
+		uh.jsonError(w, http.StatusUnauthorized, "login required")
+		return
+	}
+	uh.redirect(w, r, "login&next="+url.QueryEscape(r.URL.RequestURI()))
+}
+
+func (uh *Handler) forbidden(w http.ResponseWriter, r *http.Request) {
+	if wantsJSON(r) {
+		uh.jsonError(w, http.StatusForbidden, "forbidden")
+		return
+	}
+	http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+}
+
+// wantsJSON tells API clients from browsers: they ask for JSON, send it
+// or make the request from a script.
+func wantsJSON(r *http.Request) bool {
+	accept := r.Header.Get("Accept")
+	if strings.Contains(accept, "application/json") && !strings.Contains(accept, "text/html") {
+		return true
+	}
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "application/json") {
+		return true
+	}
+	return r.Header.Get("X-Requested-With") == "XMLHttpRequest"
+}
@@ -0,0 +1,86 @@
+package baxtep
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/url"
+	"testing"
+)
+
+func TestRequire(t *testing.T) {
+	s := newTestServer(t, nil)
+	u := addTestUser(t, s.b, "user", "user@example.com")
+	addTestUser(t, s.b, "admin", "admin@example.com")
+	admin, _ := s.b.GetUserByName("admin")
+	s.b.AddPermissions("admin", "user.delete")
+	s.b.GrantRole(admin.GetID(), "admin")
+
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, logged := UserFromContext(r.Context()); !logged {
+			t.Errorf("%s: no user in the context", r.URL.Path)
+		}
+		io.WriteString(w, "ok")
+	})
+	mux := http.NewServeMux()
+	mux.Handle("/private", s.uh.RequireLogin(ok))
+	mux.Handle("/enabled", s.uh.RequireEnabled(ok))
+	mux.Handle("/admin", s.uh.Require("user.delete")(ok))
+	mux.Handle("/named", s.uh.RequireFunc(func(u User) bool { return u.Name == "admin" })(ok))
+	s.serve(mux)
+
+	get := func(s *testServer, path string, header http.Header) (*http.Response, string) {
+		req, _ := http.NewRequest("GET", s.url(path), nil)
+		for k, v := range header {
+			req.Header[k] = v
+		}
+		return s.do(req)
+	}
+	apiHeaders := map[string]http.Header{
+		"Accept":           {"Accept": {"application/json"}},
+		"Content-Type":     {"Content-Type": {"application/json"}},
+		"X-Requested-With": {"X-Requested-With": {"XMLHttpRequest"}},
+	}
+
+	// anonymous browsers go to the login page and back
+	for _, path := range []string{"/private?a=1", "/enabled", "/admin", "/named"} {
+		resp, _ := get(s, path, nil)
+		want := "/user?login&next=" + url.QueryEscape(path)
+		if resp.StatusCode != http.StatusFound || resp.Header.Get("Location") != want {
+			t.Errorf("anonymous %s: %d %s", path, resp.StatusCode, resp.Header.Get("Location"))
+		}
+	}
+	for name, header := range apiHeaders {
+		resp, body := get(s, "/admin", header)
+		var e map[string]string
+		if resp.StatusCode != http.StatusUnauthorized || json.Unmarshal([]byte(body), &e) != nil {
+			t.Errorf("anonymous API client by %s: %d %s", name, resp.StatusCode, body)
+		}
+	}
+
+	user := s.browser()
+	user.login("user@example.com")
+	for path, want := range map[string]int{"/private": 200, "/enabled": 200, "/admin": 403, "/named": 403} {
+		if resp, _ := get(user, path, nil); resp.StatusCode != want {
+			t.Errorf("user %s: %d, want %d", path, resp.StatusCode, want)
+		}
+	}
+	if resp, body := get(user, "/admin", apiHeaders["Accept"]); resp.StatusCode != http.StatusForbidden || body == "" || body[0] != '{' {
+		t.Errorf("user API client: %d %s", resp.StatusCode, body)
+	}
+	adminBrowser := s.browser()
+	adminBrowser.login("admin@example.com")
+	for _, path := range []string{"/private", "/enabled", "/admin", "/named"} {
+		if resp, body := get(adminBrowser, path, nil); resp.StatusCode != http.StatusOK || body != "ok" {
+			t.Errorf("admin %s: %d %s", path, resp.StatusCode, body)
+		}
+	}
+
+	// a disabled user with a session from before
+	u.SetDisable()
+	for path, want := range map[string]int{"/private": 200, "/enabled": 403} {
+		if resp, _ := get(user, path, nil); resp.StatusCode != want {
+			t.Errorf("disabled user %s: %d, want %d", path, resp.StatusCode, want)
+		}
+	}
+}
@@ -0,0 +1,149 @@
+package baxtep
+
+import (
+	"net/http"
+	"time"
+)
+
+const tokenReset = "reset"
+
+// SetTokenDuration sets how long password reset and email change links
+// are valid, an hour by default.
+func (b *Baxtep) SetTokenDuration(d time.Duration) {
+	b.tokenDuration = d
+}
+
+// RequestPasswordReset returns the user with the email and a single-use
+// token for ResetPassword. Earlier tokens of the user stop working.
+func (b *Baxtep) RequestPasswordReset(email string) (User, string, error) {
+	u, err := b.GetUserByEmail(email)
+	if err != nil {
+		return u, "", err
+	}
+	token, err := b.newToken(u.id, tokenReset, "", b.tokenDuration)
+	return u, token, err
+}
+
+// ResetPassword sets a new password by a RequestPasswordReset token
+// and logs the user out everywhere.
+func (b *Baxtep) ResetPassword(token, newPassword string) (User, error) {
+	u, _, err := b.takeToken(token, tokenReset)
+	if err != nil {
+		return u, err
+	}
+	err = u.SetNewPassword(newPassword)
+	if err != nil {
+		return u, err
+	}
+	err = u.RevokeAllSessions()
+	return u, err
+}
+
+func (uh *Handler) forgot(w http.ResponseWriter, r *http.Request) {
+	userdata, v := uh.getFormData(w, r, "email")
+	status := http.StatusOK
+	if r.Method == "POST" {
+		v.required(r, "email")
+		if v.Valid() {
+			u, token, err := uh.Config.Baxter.RequestPasswordReset(r.FormValue("email"))
+			switch err {
+			case nil:
+				link := uh.link(r, "reset", token)
+				if uh.Config.PasswordReset != nil {
+					err = uh.Config.PasswordReset(r, u, link)
+				} else {
+					err = uh.sendMail("reset", u.Email, u, map[string]interface{}{"Link": link})
+				}
+			case ErrUserWithEmailNotFound:
+				// don't tell if the email is registered
+				err = nil
+			}
+			if err != nil {
+				uh.logPrintf("Forgot RequestPasswordReset error: %s", err)
+				http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+				return
+			}
+			userdata["_Sent"] = true
+		} else {
+			status = http.StatusUnprocessableEntity
+		}
+	}
+	w.Header().Set("Content-Type", "text/html")
+	err := uh.checkTemplate()
+	if err != nil {
+		uh.logPrintf("Forgot template error: %s", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(status)
+	err = uh.Config.tmpl.ExecuteTemplate(w, "_userforgot", userdata)
+	if err != nil {
+		uh.logPrintf("Forgot template execute error: %s", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+}
+
+func (uh *Handler) reset(w http.ResponseWriter, r *http.Request) {
+	token := r.URL.Query().Get("reset")
+	userdata, v := uh.getFormData(w, r)
+	userdata["_Token"] = token
+	status := http.StatusOK
+	if r.Method == "POST" {
+		v.required(r, "password", "retry-password")
+		if r.FormValue("retry-password") != "" && r.FormValue("password") != r.FormValue("retry-password") {
+			v.Add("retry-password", "Password does not match")
+		}
+		if !v.Valid() {
+			uh.resetTemplate(w, userdata, http.StatusUnprocessableEntity)
+			return
+		}
+		u, err := uh.Config.Baxter.ResetPassword(token, r.FormValue("password"))
+		switch err {
+		case nil:
+			if uh.Config.Mailer != nil {
+				err = uh.sendMail("passwordchanged", u.Email, u, map[string]interface{}{})
+				if err != nil {
+					uh.logPrintf("Reset alert mail error: %s", err)
+				}
+			}
+			uh.redirect(w, r, "login")
+		case ErrUserTokenNotFound, ErrUserTokenExpired:
+			http.Error(w, "Bad reset link", http.StatusForbidden)
+		default:
+			uh.logPrintf("Reset ResetPassword error: %s", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	// else GET method, don't use up the token: mail scanners follow links
+	_, _, err := uh.Config.Baxter.checkToken(token, tokenReset)
+	if err == ErrUserTokenNotFound || err == ErrUserTokenExpired {
+		http.Error(w, "Bad reset link", http.StatusForbidden)
+		return
+	}
+	if err != nil {
+		uh.logPrintf("Reset checkToken error: %s", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	uh.resetTemplate(w, userdata, status)
+}
+
+func (uh *Handler) resetTemplate(w http.ResponseWriter, userdata map[string]interface{}, status int) {
+	w.Header().Set("Content-Type", "text/html")
+	err := uh.checkTemplate()
+	if err != nil {
+		uh.logPrintf("Reset template error: %s", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(status)
+	err = uh.Config.tmpl.ExecuteTemplate(w, "_userreset", userdata)
+	if err != nil {
+		uh.logPrintf("Reset template execute error: %s", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+}
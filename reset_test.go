@@ -0,0 +1,85 @@
+package baxtep
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPasswordReset(t *testing.T) {
+	s := newTestServer(t, nil)
+	addTestUser(t, s.b, "user", "user@example.com")
+	phone := s.browser()
+	phone.login("user@example.com")
+
+	// don't tell if the email is registered
+	resp, body := s.post("/user?forgot", url.Values{"email": {"nobody@example.com"}})
+	if resp.StatusCode != http.StatusOK || !strings.Contains(body, "If this email is registered") {
+		t.Errorf("forgot of an unknown email: %d %s", resp.StatusCode, body)
+	}
+	if len(s.mail.Messages()) != 0 {
+		t.Error("mail to an unknown email")
+	}
+	if resp, _ = s.post("/user?forgot", nil); resp.StatusCode != http.StatusUnprocessableEntity {
+		t.Errorf("forgot without email: %d", resp.StatusCode)
+	}
+	resp, body = s.post("/user?forgot", url.Values{"email": {"user@example.com"}})
+	if resp.StatusCode != http.StatusOK || !strings.Contains(body, "If this email is registered") {
+		t.Fatalf("forgot: %d %s", resp.StatusCode, body)
+	}
+	link := sentLink(t, s.mail, "reset")
+
+	// mail scanners follow links
+	for i := 0; i < 2; i++ {
+		if resp, body = s.get(link); resp.StatusCode != http.StatusOK || !strings.Contains(body, "New password form") {
+			t.Fatalf("GET of the link: %d %s", resp.StatusCode, body)
+		}
+	}
+	resp, _ = s.post(link, url.Values{"password": {"new password"}, "retry-password": {"other"}})
+	if resp.StatusCode != http.StatusUnprocessableEntity {
+		t.Errorf("passwords don't match: %d", resp.StatusCode)
+	}
+	resp, _ = s.post(link, url.Values{"password": {"new password"}, "retry-password": {"new password"}})
+	if resp.StatusCode != http.StatusFound || resp.Header.Get("Location") != "/user?login" {
+		t.Fatalf("reset: %d %s", resp.StatusCode, resp.Header.Get("Location"))
+	}
+	if _, err := s.b.GetUserByEmailPassword("user@example.com", "new password"); err != nil {
+		t.Errorf("login with the new password: %v", err)
+	}
+	if _, err := s.b.GetUserByEmailPassword("user@example.com", "password"); err == nil {
+		t.Error("login with the old password")
+	}
+	if _, body = phone.get("/user?base"); strings.Contains(body, "user@example.com") {
+		t.Error("session is kept after the reset")
+	}
+	if resp, _ = s.post(link, url.Values{"password": {"again"}, "retry-password": {"again"}}); resp.StatusCode != http.StatusForbidden {
+		t.Errorf("second POST of the link: %d", resp.StatusCode)
+	}
+	if resp, _ = s.get(link); resp.StatusCode != http.StatusForbidden {
+		t.Errorf("GET of a used link: %d", resp.StatusCode)
+	}
+}
+
+func TestPasswordResetExpired(t *testing.T) {
+	b := newTestBaxtep(t)
+	addTestUser(t, b, "user", "user@example.com")
+	b.SetTokenDuration(-time.Minute)
+	_, token, err := b.RequestPasswordReset("user@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = b.ResetPassword(token, "new password"); err != ErrUserTokenExpired {
+		t.Errorf("expired token: %v", err)
+	}
+	b.SetTokenDuration(time.Hour)
+	_, first, _ := b.RequestPasswordReset("user@example.com")
+	_, second, _ := b.RequestPasswordReset("user@example.com")
+	if _, err = b.ResetPassword(first, "new password"); err != ErrUserTokenNotFound {
+		t.Errorf("replaced token: %v", err)
+	}
+	if _, err = b.ResetPassword(second, "new password"); err != nil {
+		t.Errorf("last token: %v", err)
+	}
+}
@@ -0,0 +1,90 @@
+package baxtep
+
+// AddPermissions allows the permissions to users with the role.
+func (b *Baxtep) AddPermissions(role string, permissions ...string) error {
+	for _, permission := range permissions {
+		err := b.store.AddRolePermission(role, permission)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (b *Baxtep) RemovePermissions(role string, permissions ...string) error {
+	for _, permission := range permissions {
+		err := b.store.DeleteRolePermission(role, permission)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Permissions returns the permissions of the role.
+func (b *Baxtep) Permissions(role string) ([]string, error) {
+	return b.store.GetRolePermissions(role)
+}
+
+// GrantRole gives the user the role, granting it twice is not an error.
+func (b *Baxtep) GrantRole(userID int64, role string) error {
+	_, err := b.GetUserByID(userID)
+	if err != nil {
+		return err
+	}
+	return b.store.AddUserRole(userID, role)
+}
+
+func (b *Baxtep) RevokeRole(userID int64, role string) error {
+	return b.store.DeleteUserRole(userID, role)
+}
+
+// Roles returns the roles granted to the user and to its groups, and in
+// the org of a scoped user.
+func (u *User) Roles() ([]string, error) {
+	roles, err := u.b.store.GetUserRoles(u.id)
+	if err != nil {
+		return nil, err
+	}
+	inherited, err := u.groupRoles()
+	if err != nil {
+		return nil, err
+	}
+	if u.org != 0 {
+		scoped, err := u.b.store.GetOrgRoles(u.org, u.id)
+		if err != nil {
+			return nil, err
+		}
+		inherited = append(inherited, scoped...)
+	}
+	for _, role := range inherited {
+		if !contains(roles, role) {
+			roles = append(roles, role)
+		}
+	}
+	return roles, nil
+}
+
+func (u *User) HasRole(role string) (bool, error) {
+	roles, err := u.Roles()
+	return contains(roles, role), err
+}
+
+// HasPermission reports if any role of the user or of its groups has
+// the permission.
+func (u *User) HasPermission(permission string) (bool, error) {
+	roles, err := u.Roles()
+	if err != nil {
+		return false, err
+	}
+	for _, role := range roles {
+		permissions, err := u.b.store.GetRolePermissions(role)
+		if err != nil {
+			return false, err
+		}
+		if contains(permissions, permission) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
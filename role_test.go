@@ -0,0 +1,44 @@
+package baxtep
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestRoles(t *testing.T) {
+	b := newTestBaxtep(t)
+	u := addTestUser(t, b, "user", "user@example.com")
+	other := addTestUser(t, b, "other", "other@example.com")
+	b.AddPermissions("editor", "post.edit", "post.publish")
+	b.AddPermissions("editor", "post.edit")
+	b.AddPermissions("admin", "user.delete")
+
+	if permissions, err := b.Permissions("editor"); err != nil || !reflect.DeepEqual(permissions, []string{"post.edit", "post.publish"}) {
+		t.Errorf("permissions: %v, %v", permissions, err)
+	}
+	if err := b.GrantRole(0, "editor"); err != ErrUserWithIDNotFound {
+		t.Errorf("role of an unknown user: %v", err)
+	}
+	b.GrantRole(u.GetID(), "editor")
+	b.GrantRole(u.GetID(), "editor")
+	if roles, err := u.Roles(); err != nil || !reflect.DeepEqual(roles, []string{"editor"}) {
+		t.Errorf("roles: %v, %v", roles, err)
+	}
+	for permission, want := range map[string]bool{"post.edit": true, "post.publish": true, "user.delete": false} {
+		if ok, err := u.HasPermission(permission); err != nil || ok != want {
+			t.Errorf("permission %s: %v, %v", permission, ok, err)
+		}
+	}
+	if ok, _ := other.HasRole("editor"); ok {
+		t.Error("role of another user")
+	}
+
+	b.RemovePermissions("editor", "post.publish")
+	if ok, _ := u.HasPermission("post.publish"); ok {
+		t.Error("removed permission")
+	}
+	b.RevokeRole(u.GetID(), "editor")
+	if ok, _ := u.HasPermission("post.edit"); ok {
+		t.Error("permission of a revoked role")
+	}
+}
@@ -0,0 +1,194 @@
+package baxtep
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+)
+
+// sqliteStore reuses the MySQL queries: SQLite understands backtick quoted
+// identifiers and "?" placeholders, only the schema differs.
+type sqliteStore struct {
+	mysqlStore
+}
+
+// NewSQLiteStore returns a Store for SQLite ("sqlite3" or "sqlite" drivers),
+// file or ":memory:" databases alike.
+func NewSQLiteStore(db *sql.DB, prefix string) Store {
+	return &sqliteStore{mysqlStore{conn: db, prefix: prefix}}
+}
+
+func (s *sqliteStore) Migrate(ctx context.Context) error {
+	return migrate(ctx, s.conn, s.meta(), s.migrations())
+}
+
+func (s *sqliteStore) SchemaVersion() (int, error) {
+	return schemaVersion(s.conn, s.meta())
+}
+
+func (s *sqliteStore) meta() metaQueries {
+	meta := s.mysqlStore.meta()
+	meta.create = "CREATE TABLE IF NOT EXISTS `" + s.prefix + "_meta` (" +
+		" `name` varchar(100) NOT NULL PRIMARY KEY," +
+		" `value` varchar(250) NOT NULL" +
+		");"
+	return meta
+}
+
+func (s *sqliteStore) migrations() []migration {
+	return []migration{
+		// 1: users and params
+		{
+			fmt.Sprintf(
+				"CREATE TABLE IF NOT EXISTS `%s` ("+
+					" `id` INTEGER PRIMARY KEY AUTOINCREMENT,"+
+					" `name` varchar(100) NOT NULL,"+
+					" `password` varchar(64) NOT NULL DEFAULT '',"+
+					" `email` varchar(100) NOT NULL,"+
+					" `registration_time` timestamp NULL,"+
+					" `enable` tinyint(1) NOT NULL DEFAULT 0,"+
+					" `session_id` varchar(64) NOT NULL DEFAULT '',"+
+					" `session_time` timestamp NULL"+
+					");",
+				s.prefix),
+			fmt.Sprintf("CREATE TABLE IF NOT EXISTS `%s_param` ("+
+				" `id` INTEGER PRIMARY KEY AUTOINCREMENT,"+
+				" `user_id` int(11) NOT NULL,"+
+				" `key` varchar(100) NOT NULL,"+
+				" `val` varchar(250) NOT NULL"+
+				");",
+				s.prefix),
+		},
+		// 2: SQLite does not enforce varchar length
+		{},
+		// 3: sessions move to their own table, session_id stays
+		// for registration confirmation codes only
+		{
+			"CREATE TABLE IF NOT EXISTS `" + s.prefix + "_session` (" +
+				" `id` varchar(64) NOT NULL PRIMARY KEY," +
+				" `user_id` int(11) NOT NULL," +
+				" `created` timestamp NULL," +
+				" `last_seen` timestamp NULL," +
+				" `user_agent` varchar(250) NOT NULL DEFAULT ''," +
+				" `ip` varchar(45) NOT NULL DEFAULT ''" +
+				");",
+			"CREATE INDEX IF NOT EXISTS `" + s.prefix + "_session_user_id` ON `" + s.prefix + "_session` (`user_id`);",
+			"UPDATE `" + s.prefix + "` SET `session_id`='' WHERE `enable`=1 OR `session_time` IS NOT NULL",
+		},
+		// 4: single-use tokens
+		{
+			"CREATE TABLE IF NOT EXISTS `" + s.prefix + "_token` (" +
+				" `id` varchar(64) NOT NULL PRIMARY KEY," +
+				" `user_id` int(11) NOT NULL," +
+				" `kind` varchar(20) NOT NULL," +
+				" `expires` timestamp NULL" +
+				");",
+			"CREATE INDEX IF NOT EXISTS `" + s.prefix + "_token_user_id` ON `" + s.prefix + "_token` (`user_id`);",
+		},
+		// 5: token payload
+		{
+			"ALTER TABLE `" + s.prefix + "_token` ADD COLUMN `data` varchar(250) NOT NULL DEFAULT ''",
+		},
+		// 6: failed logins and lockout, locked_until is unix time
+		{
+			"ALTER TABLE `" + s.prefix + "` ADD COLUMN `failed_logins` integer NOT NULL DEFAULT 0",
+			"ALTER TABLE `" + s.prefix + "` ADD COLUMN `locked_until` bigint NOT NULL DEFAULT 0",
+		},
+		// 7: encrypted TOTP secret and the last used time step
+		{
+			"ALTER TABLE `" + s.prefix + "` ADD COLUMN `totp_secret` varchar(255) NOT NULL DEFAULT ''",
+			"ALTER TABLE `" + s.prefix + "` ADD COLUMN `totp_step` bigint NOT NULL DEFAULT 0",
+		},
+		// 8: 2FA recovery codes
+		{
+			"CREATE TABLE IF NOT EXISTS `" + s.prefix + "_recovery` (" +
+				" `id` varchar(64) NOT NULL PRIMARY KEY," +
+				" `user_id` int(11) NOT NULL" +
+				");",
+			"CREATE INDEX IF NOT EXISTS `" + s.prefix + "_recovery_user_id` ON `" + s.prefix + "_recovery` (`user_id`);",
+		},
+		// 9: WebAuthn credentials, id is the base64url credential ID
+		{
+			"CREATE TABLE IF NOT EXISTS `" + s.prefix + "_credential` (" +
+				" `id` varchar(255) NOT NULL PRIMARY KEY," +
+				" `user_id` int(11) NOT NULL," +
+				" `name` varchar(100) NOT NULL DEFAULT ''," +
+				" `public_key` text NOT NULL," +
+				" `sign_count` bigint NOT NULL DEFAULT 0," +
+				" `created` timestamp NULL," +
+				" `last_used` timestamp NULL" +
+				");",
+			"CREATE INDEX IF NOT EXISTS `" + s.prefix + "_credential_user_id` ON `" + s.prefix + "_credential` (`user_id`);",
+		},
+		// 10: roles of users and permissions of roles, "role" params become roles
+		{
+			"CREATE TABLE IF NOT EXISTS `" + s.prefix + "_role_permission` (" +
+				" `role` varchar(100) NOT NULL," +
+				" `permission` varchar(100) NOT NULL," +
+				" PRIMARY KEY (`role`, `permission`)" +
+				");",
+			"CREATE TABLE IF NOT EXISTS `" + s.prefix + "_user_role` (" +
+				" `user_id` int(11) NOT NULL," +
+				" `role` varchar(100) NOT NULL," +
+				" PRIMARY KEY (`user_id`, `role`)" +
+				");",
+			"INSERT INTO `" + s.prefix + "_user_role` (`user_id`, `role`)" +
+				" SELECT DISTINCT `user_id`, `val` FROM `" + s.prefix + "_param` WHERE `key`='role';",
+		},
+		// 11: groups of users with their params and roles
+		{
+			"CREATE TABLE IF NOT EXISTS `" + s.prefix + "_group` (" +
+				" `id` INTEGER PRIMARY KEY AUTOINCREMENT," +
+				" `name` varchar(100) NOT NULL UNIQUE," +
+				" `created` timestamp NULL" +
+				");",
+			"CREATE TABLE IF NOT EXISTS `" + s.prefix + "_group_member` (" +
+				" `group_id` int(11) NOT NULL," +
+				" `user_id` int(11) NOT NULL," +
+				" PRIMARY KEY (`group_id`, `user_id`)" +
+				");",
+			"CREATE INDEX IF NOT EXISTS `" + s.prefix + "_group_member_user_id` ON `" + s.prefix + "_group_member` (`user_id`);",
+			"CREATE TABLE IF NOT EXISTS `" + s.prefix + "_group_param` (" +
+				" `id` INTEGER PRIMARY KEY AUTOINCREMENT," +
+				" `group_id` int(11) NOT NULL," +
+				" `key` varchar(100) NOT NULL," +
+				" `val` varchar(250) NOT NULL" +
+				");",
+			"CREATE INDEX IF NOT EXISTS `" + s.prefix + "_group_param_group_id` ON `" + s.prefix + "_group_param` (`group_id`);",
+			"CREATE TABLE IF NOT EXISTS `" + s.prefix + "_group_role` (" +
+				" `group_id` int(11) NOT NULL," +
+				" `role` varchar(100) NOT NULL," +
+				" PRIMARY KEY (`group_id`, `role`)" +
+				");",
+		},
+		// 12: organizations with per-org roles and params, the active org of sessions
+		{
+			"CREATE TABLE IF NOT EXISTS `" + s.prefix + "_org` (" +
+				" `id` INTEGER PRIMARY KEY AUTOINCREMENT," +
+				" `name` varchar(100) NOT NULL UNIQUE," +
+				" `created` timestamp NULL" +
+				");",
+			"CREATE TABLE IF NOT EXISTS `" + s.prefix + "_org_member` (" +
+				" `org_id` int(11) NOT NULL," +
+				" `user_id` int(11) NOT NULL," +
+				" PRIMARY KEY (`org_id`, `user_id`)" +
+				");",
+			"CREATE INDEX IF NOT EXISTS `" + s.prefix + "_org_member_user_id` ON `" + s.prefix + "_org_member` (`user_id`);",
+			"CREATE TABLE IF NOT EXISTS `" + s.prefix + "_org_role` (" +
+				" `org_id` int(11) NOT NULL," +
+				" `user_id` int(11) NOT NULL," +
+				" `role` varchar(100) NOT NULL," +
+				" PRIMARY KEY (`org_id`, `user_id`, `role`)" +
+				");",
+			"CREATE TABLE IF NOT EXISTS `" + s.prefix + "_org_param` (" +
+				" `id` INTEGER PRIMARY KEY AUTOINCREMENT," +
+				" `org_id` int(11) NOT NULL," +
+				" `user_id` int(11) NOT NULL," +
+				" `key` varchar(100) NOT NULL," +
+				" `val` varchar(250) NOT NULL" +
+				");",
+			"CREATE INDEX IF NOT EXISTS `" + s.prefix + "_org_param_org_user` ON `" + s.prefix + "_org_param` (`org_id`, `user_id`);",
+			"ALTER TABLE `" + s.prefix + "_session` ADD COLUMN `org_id` int(11) NOT NULL DEFAULT 0",
+		},
+	}
+}
@@ -0,0 +1,48 @@
+package baxtep
+
+import (
+	"context"
+	"database/sql"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// openSQLite opens a SQLite file with NewStore and migrates it.
+func openSQLite(t *testing.T, path string) (*sql.DB, Store) {
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := NewStore(db, "sqlite", "user")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = s.Migrate(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return db, s
+}
+
+func TestSQLiteFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "baxtep.db")
+	db, s := openSQLite(t, path)
+	testStore(t, s)
+	id, err := s.AddUser("kept", "kept@example.com", "hash", "", time.Now().UTC())
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.AddParams(id, map[string]string{"key": "value"})
+	db.Close()
+
+	db, s = openSQLite(t, path)
+	defer db.Close()
+	d, err := s.GetUserByName("kept")
+	if err != nil || d.ID != id {
+		t.Fatalf("user after reopen: %+v, %v", d, err)
+	}
+	if values, err := s.GetParam(id, "key"); err != nil || len(values) != 1 || values[0] != "value" {
+		t.Errorf("param after reopen: %v, %v", values, err)
+	}
+}
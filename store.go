@@ -0,0 +1,302 @@
+package baxtep
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Store is a storage backend for users, their params and sessions.
+// Lookups must return sql.ErrNoRows when the record does not exist.
+type Store interface {
+	// Migrate brings the schema up to date, SchemaVersion returns
+	// the version applied so far.
+	Migrate(ctx context.Context) error
+	SchemaVersion() (int, error)
+	UserStore
+	ParamStore
+	SessionStore
+	TokenStore
+	RecoveryStore
+	CredentialStore
+	RoleStore
+	GroupStore
+	OrgStore
+}
+
+// UserData is a user row as stored by a Store.
+type UserData struct {
+	ID     int64
+	Name   string
+	Email  string
+	Enable bool
+}
+
+type UserStore interface {
+	AddUser(name, email, passhash, confirm string, registered time.Time) (int64, error)
+	DeleteUser(id int64) error
+	GetUserByID(id int64) (UserData, error)
+	GetUserByName(name string) (UserData, error)
+	GetUserByEmail(email string) (UserData, error)
+	// GetUserByConfirm finds a user by the hash of its registration
+	// confirmation code, AddUser gets the hash too.
+	GetUserByConfirm(confirm string) (UserData, error)
+	SetUserConfirm(id int64, confirm string) error
+	CountUserName(name string) (int64, error)
+	CountUserEmail(email string) (int64, error)
+	SetUserEnable(id int64, enable bool) error
+	SetUserEmail(id int64, email string) error
+	GetUserPassword(id int64) (string, error)
+	SetUserPassword(id int64, passhash string) error
+	// GetUserLock returns failed logins in a row and the lockout end.
+	GetUserLock(id int64) (failures int, until time.Time, err error)
+	// AddUserFailure counts a failed login and returns the new count.
+	AddUserFailure(id int64) (int, error)
+	SetUserLock(id int64, failures int, until time.Time) error
+	// GetUserTOTP returns the encrypted TOTP secret, empty when 2FA is
+	// off, and the last time step a code was accepted for.
+	GetUserTOTP(id int64) (secret string, step int64, err error)
+	SetUserTOTP(id int64, secret string, step int64) error
+}
+
+type ParamStore interface {
+	AddParams(userID int64, params ...map[string]string) error
+	HasParam(userID int64, key string) (bool, error)
+	HasParamValue(userID int64, key, value string) (bool, error)
+	GetParam(userID int64, key string) ([]string, error)
+	GetParams(userID int64) (map[string][]string, error)
+	DeleteParams(userID int64, keys ...string) error
+}
+
+// Session is a login of a user on one device. ID is the hash of the
+// session cookie value, never the value itself.
+type Session struct {
+	ID        string
+	UserID    int64
+	Created   time.Time
+	LastSeen  time.Time
+	UserAgent string
+	IP        string
+	OrgID     int64 // active organization, 0 for none
+}
+
+type SessionStore interface {
+	AddSession(s Session) error
+	GetSession(id string) (Session, error)
+	GetSessions(userID int64) ([]Session, error)
+	TouchSession(id string, t time.Time) error
+	DeleteSession(userID int64, id string) error
+	DeleteSessions(userID int64) error
+	SetSessionOrg(id string, orgID int64) error
+}
+
+// Token is a single-use code of some kind, like a password reset link,
+// sent to a user. ID is the hash of the code, Data is kind specific.
+type Token struct {
+	ID      string
+	UserID  int64
+	Kind    string
+	Expires time.Time
+	Data    string
+}
+
+type TokenStore interface {
+	AddToken(t Token) error
+	GetToken(id, kind string) (Token, error)
+	// TakeToken deletes and returns the token, only one caller gets it.
+	TakeToken(id, kind string) (Token, error)
+	DeleteTokens(userID int64, kind string) error
+	// DeleteExpiredTokens deletes the tokens of kind expired before now.
+	DeleteExpiredTokens(kind string, now time.Time) error
+}
+
+// RecoveryStore keeps hashes of 2FA recovery codes.
+type RecoveryStore interface {
+	// SetRecoveryCodes replaces the codes of the user.
+	SetRecoveryCodes(userID int64, ids []string) error
+	// TakeRecoveryCode deletes the code, sql.ErrNoRows if there is none.
+	TakeRecoveryCode(userID int64, id string) error
+	CountRecoveryCodes(userID int64) (int, error)
+}
+
+// Credential is a WebAuthn public key credential (passkey) of a user.
+// ID is the base64url credential ID, PublicKey the COSE key.
+type Credential struct {
+	ID        string
+	UserID    int64
+	Name      string
+	PublicKey string // base64
+	SignCount int64
+	Created   time.Time
+	LastUsed  time.Time
+}
+
+type CredentialStore interface {
+	AddCredential(c Credential) error
+	GetCredential(id string) (Credential, error)
+	GetCredentials(userID int64) ([]Credential, error)
+	UseCredential(id string, signCount int64, used time.Time) error
+	RenameCredential(userID int64, id, name string) error
+	DeleteCredential(userID int64, id string) error
+}
+
+// RoleStore keeps roles of users and permissions of roles, both are
+// plain names. Adding a row that exists is not an error.
+type RoleStore interface {
+	AddRolePermission(role, permission string) error
+	DeleteRolePermission(role, permission string) error
+	GetRolePermissions(role string) ([]string, error)
+	AddUserRole(userID int64, role string) error
+	DeleteUserRole(userID int64, role string) error
+	GetUserRoles(userID int64) ([]string, error)
+}
+
+// GroupData is a group row as stored by a Store.
+type GroupData struct {
+	ID   int64
+	Name string
+}
+
+// GroupStore keeps groups of users with their params and roles.
+type GroupStore interface {
+	AddGroup(name string, created time.Time) (int64, error)
+	DeleteGroup(id int64) error
+	GetGroupByID(id int64) (GroupData, error)
+	GetGroupByName(name string) (GroupData, error)
+	AddGroupMember(groupID, userID int64) error
+	DeleteGroupMember(groupID, userID int64) error
+	GetGroupMembers(groupID int64) ([]int64, error)
+	// GetUserGroups returns IDs of the groups the user is a member of.
+	GetUserGroups(userID int64) ([]int64, error)
+	AddGroupParams(groupID int64, params ...map[string]string) error
+	GetGroupParams(groupID int64) (map[string][]string, error)
+	DeleteGroupParams(groupID int64, keys ...string) error
+	AddGroupRole(groupID int64, role string) error
+	DeleteGroupRole(groupID int64, role string) error
+	GetGroupRoles(groupID int64) ([]string, error)
+}
+
+// OrgData is an organization row as stored by a Store.
+type OrgData struct {
+	ID   int64
+	Name string
+}
+
+// OrgStore keeps organizations (tenants), their members and the roles
+// and params of members within an organization.
+type OrgStore interface {
+	AddOrg(name string, created time.Time) (int64, error)
+	// DeleteOrg deletes the org with its memberships, roles and params
+	// and makes it inactive in sessions.
+	DeleteOrg(id int64) error
+	GetOrgByID(id int64) (OrgData, error)
+	GetOrgByName(name string) (OrgData, error)
+	AddOrgMember(orgID, userID int64) error
+	// DeleteOrgMember also deletes the user's roles and params in the org.
+	DeleteOrgMember(orgID, userID int64) error
+	GetOrgMembers(orgID int64) ([]int64, error)
+	// GetUserOrgs returns IDs of the orgs the user is a member of.
+	GetUserOrgs(userID int64) ([]int64, error)
+	AddOrgRole(orgID, userID int64, role string) error
+	DeleteOrgRole(orgID, userID int64, role string) error
+	GetOrgRoles(orgID, userID int64) ([]string, error)
+	AddOrgParams(orgID, userID int64, params ...map[string]string) error
+	GetOrgParams(orgID, userID int64) (map[string][]string, error)
+	DeleteOrgParams(orgID, userID int64, keys ...string) error
+}
+
+// NewStore returns the built-in Store for a database/sql driver name.
+func NewStore(db *sql.DB, driver, prefix string) (Store, error) {
+	switch driver {
+	case "mysql", "tidb":
+		return NewMySQLStore(db, prefix), nil
+	case "ql", "ql-mem":
+		return NewQLStore(db, prefix), nil
+	case "sqlite3", "sqlite":
+		return NewSQLiteStore(db, prefix), nil
+	case "postgres", "pgx":
+		return NewPostgresStore(db, prefix), nil
+	}
+	return nil, fmt.Errorf("Database type '%s' not supported", driver)
+}
+
+// numberedPlaceholders returns "$from, $from+1, ..." for n arguments.
+func numberedPlaceholders(from, n int) string {
+	var s []string
+	for i := 0; i < n; i++ {
+		s = append(s, fmt.Sprintf("$%d", from+i))
+	}
+	return strings.Join(s, ", ")
+}
+
+func scanParam(rows *sql.Rows) ([]string, error) {
+	var param []string
+	defer rows.Close()
+	for rows.Next() {
+		var v string
+		err := rows.Scan(&v)
+		if err != nil {
+			return param, err
+		}
+		param = append(param, v)
+	}
+	return param, rows.Err()
+}
+
+func scanParams(rows *sql.Rows) (map[string][]string, error) {
+	params := map[string][]string{}
+	defer rows.Close()
+	for rows.Next() {
+		var k, v string
+		err := rows.Scan(&k, &v)
+		if err != nil {
+			return params, err
+		}
+		params[k] = append(params[k], v)
+	}
+	return params, rows.Err()
+}
+
+func scanSessions(rows *sql.Rows) ([]Session, error) {
+	var sessions []Session
+	defer rows.Close()
+	for rows.Next() {
+		var s Session
+		err := rows.Scan(&s.ID, &s.UserID, &s.Created, &s.LastSeen, &s.UserAgent, &s.IP, &s.OrgID)
+		if err != nil {
+			return sessions, err
+		}
+		sessions = append(sessions, s)
+	}
+	return sessions, rows.Err()
+}
+
+func scanCredentials(rows *sql.Rows) ([]Credential, error) {
+	var credentials []Credential
+	defer rows.Close()
+	for rows.Next() {
+		var c Credential
+		err := rows.Scan(&c.ID, &c.UserID, &c.Name, &c.PublicKey, &c.SignCount, &c.Created, &c.LastUsed)
+		if err != nil {
+			return credentials, err
+		}
+		credentials = append(credentials, c)
+	}
+	return credentials, rows.Err()
+}
+
+func scanIDs(rows *sql.Rows) ([]int64, error) {
+	var ids []int64
+	defer rows.Close()
+	for rows.Next() {
+		var id int64
+		err := rows.Scan(&id)
+		if err != nil {
+			return ids, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
@@ -0,0 +1,194 @@
+package baxtep
+
+import (
+	"database/sql"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+)
+
+// testStore runs a migrated store through users, params, sessions,
+// tokens, recovery codes and passkeys.
+func testStore(t *testing.T, s Store) {
+	now := time.Now().UTC().Truncate(time.Second)
+
+	id, err := s.AddUser("user", "user@example.com", "hash", "confirm", now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := s.AddUser("other", "other@example.com", "", "", now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := UserData{ID: id, Name: "user", Email: "user@example.com"}
+	for name, get := range map[string]func() (UserData, error){
+		"ID":      func() (UserData, error) { return s.GetUserByID(id) },
+		"name":    func() (UserData, error) { return s.GetUserByName("user") },
+		"email":   func() (UserData, error) { return s.GetUserByEmail("user@example.com") },
+		"confirm": func() (UserData, error) { return s.GetUserByConfirm("confirm") },
+	} {
+		if d, err := get(); err != nil || d != want {
+			t.Errorf("user by %s: %+v, %v", name, d, err)
+		}
+	}
+	if _, err = s.GetUserByName("nobody"); err != sql.ErrNoRows {
+		t.Errorf("unknown user: %v", err)
+	}
+	if n, err := s.CountUserName("user"); err != nil || n != 1 {
+		t.Errorf("CountUserName: %d, %v", n, err)
+	}
+	if n, err := s.CountUserEmail("nobody@example.com"); err != nil || n != 0 {
+		t.Errorf("CountUserEmail: %d, %v", n, err)
+	}
+	s.SetUserConfirm(id, "")
+	s.SetUserEnable(id, true)
+	s.SetUserEmail(id, "new@example.com")
+	s.SetUserPassword(id, "new hash")
+	if d, err := s.GetUserByID(id); err != nil || !d.Enable || d.Email != "new@example.com" {
+		t.Errorf("updated user: %+v, %v", d, err)
+	}
+	if hash, err := s.GetUserPassword(id); err != nil || hash != "new hash" {
+		t.Errorf("password: %q, %v", hash, err)
+	}
+
+	if failures, until, err := s.GetUserLock(id); err != nil || failures != 0 || until.After(now) {
+		t.Errorf("lock of a new user: %d, %s, %v", failures, until, err)
+	}
+	s.AddUserFailure(id)
+	if failures, err := s.AddUserFailure(id); err != nil || failures != 2 {
+		t.Errorf("AddUserFailure: %d, %v", failures, err)
+	}
+	s.SetUserLock(id, 5, now.Add(time.Hour))
+	if failures, until, err := s.GetUserLock(id); err != nil || failures != 5 || !until.Equal(now.Add(time.Hour)) {
+		t.Errorf("lock: %d, %s, %v", failures, until, err)
+	}
+	s.SetUserTOTP(id, "secret", 42)
+	if secret, step, err := s.GetUserTOTP(id); err != nil || secret != "secret" || step != 42 {
+		t.Errorf("TOTP: %q, %d, %v", secret, step, err)
+	}
+
+	err = s.AddParams(id, map[string]string{"a": "1"}, map[string]string{"a": "2"}, map[string]string{"b": "3"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.AddParams(other, map[string]string{"a": "other"})
+	values, err := s.GetParam(id, "a")
+	sort.Strings(values)
+	if err != nil || !reflect.DeepEqual(values, []string{"1", "2"}) {
+		t.Errorf("GetParam: %v, %v", values, err)
+	}
+	if ok, _ := s.HasParamValue(id, "a", "other"); ok {
+		t.Error("param of another user")
+	}
+	s.DeleteParams(id, "a")
+	if params, err := s.GetParams(id); err != nil || !reflect.DeepEqual(params, map[string][]string{"b": {"3"}}) {
+		t.Errorf("params after delete: %v, %v", params, err)
+	}
+
+	session := Session{ID: "session", UserID: id, Created: now, LastSeen: now, UserAgent: "agent", IP: "192.0.2.1"}
+	s.AddSession(session)
+	s.AddSession(Session{ID: "other session", UserID: other, Created: now, LastSeen: now})
+	if got, err := s.GetSession("session"); err != nil || got.UserID != id || got.IP != "192.0.2.1" || !got.Created.Equal(now) {
+		t.Errorf("session: %+v, %v", got, err)
+	}
+	s.TouchSession("session", now.Add(time.Minute))
+	s.SetSessionOrg("session", 7)
+	if got, err := s.GetSession("session"); err != nil || !got.LastSeen.Equal(now.Add(time.Minute)) || got.OrgID != 7 {
+		t.Errorf("touched session: %+v, %v", got, err)
+	}
+	s.DeleteSession(other, "session")
+	if sessions, err := s.GetSessions(id); err != nil || len(sessions) != 1 {
+		t.Errorf("session deleted by another user: %v, %v", sessions, err)
+	}
+	s.DeleteSessions(id)
+	if _, err = s.GetSession("session"); err != sql.ErrNoRows {
+		t.Errorf("deleted session: %v", err)
+	}
+	if _, err = s.GetSession("other session"); err != nil {
+		t.Errorf("session of another user: %v", err)
+	}
+
+	s.AddToken(Token{ID: "token", UserID: id, Kind: "reset", Expires: now.Add(time.Hour), Data: "data"})
+	s.AddToken(Token{ID: "expired", Kind: "login", Expires: now.Add(-time.Hour)})
+	s.AddToken(Token{ID: "fresh", Kind: "login", Expires: now.Add(time.Hour)})
+	if _, err = s.GetToken("token", "magic"); err != sql.ErrNoRows {
+		t.Errorf("token of another kind: %v", err)
+	}
+	if token, err := s.GetToken("token", "reset"); err != nil || token.Data != "data" || !token.Expires.Equal(now.Add(time.Hour)) {
+		t.Errorf("token: %+v, %v", token, err)
+	}
+	if token, err := s.TakeToken("token", "reset"); err != nil || token.UserID != id {
+		t.Errorf("TakeToken: %+v, %v", token, err)
+	}
+	if _, err = s.TakeToken("token", "reset"); err != sql.ErrNoRows {
+		t.Errorf("second TakeToken: %v", err)
+	}
+	s.DeleteExpiredTokens("login", now)
+	if _, err = s.GetToken("expired", "login"); err != sql.ErrNoRows {
+		t.Errorf("expired token: %v", err)
+	}
+	if _, err = s.GetToken("fresh", "login"); err != nil {
+		t.Errorf("token is not expired: %v", err)
+	}
+
+	s.SetRecoveryCodes(id, []string{"1", "2", "3"})
+	s.SetRecoveryCodes(id, []string{"4", "5"})
+	if err = s.TakeRecoveryCode(id, "1"); err != sql.ErrNoRows {
+		t.Errorf("replaced recovery code: %v", err)
+	}
+	if err = s.TakeRecoveryCode(other, "4"); err != sql.ErrNoRows {
+		t.Errorf("recovery code of another user: %v", err)
+	}
+	s.TakeRecoveryCode(id, "4")
+	if n, err := s.CountRecoveryCodes(id); err != nil || n != 1 {
+		t.Errorf("recovery codes left: %d, %v", n, err)
+	}
+
+	c := Credential{ID: "credential", UserID: id, Name: "key", PublicKey: "cose", Created: now, LastUsed: now}
+	s.AddCredential(c)
+	s.UseCredential("credential", 10, now.Add(time.Minute))
+	s.RenameCredential(other, "credential", "stolen")
+	s.RenameCredential(id, "credential", "renamed")
+	got, err := s.GetCredential("credential")
+	if err != nil || got.Name != "renamed" || got.SignCount != 10 || !got.LastUsed.Equal(now.Add(time.Minute)) {
+		t.Errorf("credential: %+v, %v", got, err)
+	}
+	s.DeleteCredential(other, "credential")
+	if credentials, err := s.GetCredentials(id); err != nil || len(credentials) != 1 {
+		t.Errorf("credential deleted by another user: %v, %v", credentials, err)
+	}
+	s.DeleteCredential(id, "credential")
+	if _, err = s.GetCredential("credential"); err != sql.ErrNoRows {
+		t.Errorf("deleted credential: %v", err)
+	}
+
+	err = s.DeleteUser(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = s.GetUserByID(id); err != sql.ErrNoRows {
+		t.Errorf("deleted user: %v", err)
+	}
+	if params, _ := s.GetParams(id); len(params) != 0 {
+		t.Errorf("params of a deleted user: %v", params)
+	}
+	if _, err = s.GetUserByID(other); err != nil {
+		t.Errorf("other user: %v", err)
+	}
+}
+
+func TestNewStore(t *testing.T) {
+	for _, driver := range []string{"mysql", "tidb", "ql", "ql-mem", "sqlite3", "sqlite", "postgres", "pgx"} {
+		if s, err := NewStore(nil, driver, "user"); err != nil || s == nil {
+			t.Errorf("%s: %v", driver, err)
+		}
+	}
+	if _, err := NewStore(nil, "oracle", "user"); err == nil {
+		t.Error("unknown driver")
+	}
+}
+
+func TestStore(t *testing.T) {
+	testStore(t, newTestBaxtep(t).store)
+}
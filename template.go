@@ -6,18 +6,26 @@ var defaultTemplate = `
   Registration page<hr/>
   {{if ._User}}
     Hello {{._User.Name}}! You already registred.
+  {{else if ._Sent}}
+    Registration almost done, check your email for the confirmation link.
   {{else}}
     <form action="?registration" method="POST">
       <fieldset>
+      <input name="csrf_token" type="hidden" value="{{._CSRF}}"/>
         <legend>Registration form</legend>
+        {{- template "_usererrors" index ._Errors ""}}
         <label for="name">Name:</label> 
-        <input id="name" name="name" type="text" size="25" autofocus/><br/>
+        <input id="name" name="name" type="text" size="25" value="{{._Form.name}}" autofocus/>
+          {{- template "_usererrors" index ._Errors "name"}}<br/>
         <label for="email">Email:</label> 
-          <input id="email" name="email" type="email" size="25" autofocus/><br/>
+          <input id="email" name="email" type="email" size="25" value="{{._Form.email}}"/>
+          {{- template "_usererrors" index ._Errors "email"}}<br/>
         <label for="password">Password:</label>    
-          <input id="password" name="password" type="password" size="25" autocomplete="off"/><br/>
+          <input id="password" name="password" type="password" size="25" autocomplete="off"/>
+          {{- template "_usererrors" index ._Errors "password"}}<br/>
         <label for="retry-password">Retry password:</label>    
-          <input id="retry-password" name="retry-password" type="password" size="25" autocomplete="off"/><br/>
+          <input id="retry-password" name="retry-password" type="password" size="25" autocomplete="off"/>
+          {{- template "_usererrors" index ._Errors "retry-password"}}<br/>
         <input name="submit" type="submit" value="Submit" />
       </fieldset>
     </form>
@@ -29,7 +37,14 @@ var defaultTemplate = `
 
 {{- define "_userconfirmation" -}}
 {{- template "_userheader" -}}
-  Confirmed and login
+  {{if ._Token}}
+    <form action="?registration={{._Token}}" method="POST">
+      <input name="csrf_token" type="hidden" value="{{._CSRF}}"/>
+      <input name="submit" type="submit" value="Confirm registration" />
+    </form>
+  {{else}}
+    Confirmed and login
+  {{end}}
 {{- template "_userfooter" -}}
 {{end}}
 
@@ -38,23 +53,206 @@ var defaultTemplate = `
   Login page<hr/>
   {{if ._User}}
     <a href='?base'>User page</a><br/>
-	<a href='?logout'>Logout</a><br/> 
+    {{template "_userlogout" .}}
   {{else}}
     <form action="?login" method="POST">
       <fieldset>
+      <input name="csrf_token" type="hidden" value="{{._CSRF}}"/>
+      <input name="next" type="hidden" value="{{._Form.next}}"/>
         <legend>Login form</legend>
+        {{- template "_usererrors" index ._Errors ""}}
         <label for="email">Email:</label> 
-          <input id="email" name="email" type="email" size="25" autofocus/><br/>
+          <input id="email" name="email" type="email" size="25" value="{{._Form.email}}" autofocus/>
+          {{- template "_usererrors" index ._Errors "email"}}<br/>
         <label for="password">Password:</label>    
-          <input id="password" name="password" type="password" size="25" autocomplete="off"/><br/>
+          <input id="password" name="password" type="password" size="25" autocomplete="off"/>
+          {{- template "_usererrors" index ._Errors "password"}}<br/>
+        <input name="submit" type="submit" value="Submit" />
+      </fieldset>
+    </form>
+  <a href='?registration'>Registration</a><br/>
+  <a href='?forgot'>Forgot password?</a><br/>
+  <a href='?magic'>Email me a login link</a>
+  {{end}}
+{{- template "_userfooter" -}}
+{{end}}
+
+{{- define "_userforgot" -}}
+{{- template "_userheader" -}}
+  Forgot password page<hr/>
+  {{if ._Sent}}
+    If this email is registered, we have sent a link to reset the password.
+  {{else}}
+    <form action="?forgot" method="POST">
+      <fieldset>
+      <input name="csrf_token" type="hidden" value="{{._CSRF}}"/>
+        <legend>Password reset form</legend>
+        <label for="email">Email:</label> 
+          <input id="email" name="email" type="email" size="25" value="{{._Form.email}}" autofocus/>
+          {{- template "_usererrors" index ._Errors "email"}}<br/>
         <input name="submit" type="submit" value="Submit" />
       </fieldset>
     </form>
-  <a href='?registration'>Registration</a>
   {{end}}
+  <a href='?login'>Login</a>
 {{- template "_userfooter" -}}
 {{end}}
 
+{{- define "_usermagic" -}}
+{{- template "_userheader" -}}
+  Login link page<hr/>
+  {{if ._Token}}
+    <form action="?magic={{._Token}}" method="POST">
+      <input name="csrf_token" type="hidden" value="{{._CSRF}}"/>
+      <input name="submit" type="submit" value="Log in" />
+    </form>
+  {{else if ._Sent}}
+    If this email is registered, we have sent a login link to it.
+  {{else}}
+    <form action="?magic" method="POST">
+      <fieldset>
+      <input name="csrf_token" type="hidden" value="{{._CSRF}}"/>
+        <legend>Login link form</legend>
+        {{- template "_usererrors" index ._Errors ""}}
+        <label for="email">Email:</label> 
+          <input id="email" name="email" type="email" size="25" value="{{._Form.email}}" autofocus/>
+          {{- template "_usererrors" index ._Errors "email"}}<br/>
+        <input name="submit" type="submit" value="Submit" />
+      </fieldset>
+    </form>
+  {{end}}
+  <a href='?login'>Login</a>
+{{- template "_userfooter" -}}
+{{end}}
+
+{{- define "_userreset" -}}
+{{- template "_userheader" -}}
+  Reset password page<hr/>
+    <form action="?reset={{._Token}}" method="POST">
+      <fieldset>
+      <input name="csrf_token" type="hidden" value="{{._CSRF}}"/>
+        <legend>New password form</legend>
+        <label for="password">Password:</label>    
+          <input id="password" name="password" type="password" size="25" autocomplete="off"/>
+          {{- template "_usererrors" index ._Errors "password"}}<br/>
+        <label for="retry-password">Retry password:</label>    
+          <input id="retry-password" name="retry-password" type="password" size="25" autocomplete="off"/>
+          {{- template "_usererrors" index ._Errors "retry-password"}}<br/>
+        <input name="submit" type="submit" value="Submit" />
+      </fieldset>
+    </form>
+{{- template "_userfooter" -}}
+{{end}}
+
+
+{{- define "_useremail" -}}
+{{- template "_userheader" -}}
+  Change email page<hr/>
+  {{if ._Changed}}
+    Email changed to {{._User.Email}}.
+  {{else if ._Token}}
+    <form action="?email={{._Token}}" method="POST">
+      <input name="csrf_token" type="hidden" value="{{._CSRF}}"/>
+      <input name="submit" type="submit" value="Confirm email" />
+    </form>
+  {{else if ._Sent}}
+    We have sent a confirmation link to the new email.
+  {{else}}
+    <form action="?email" method="POST">
+      <fieldset>
+      <input name="csrf_token" type="hidden" value="{{._CSRF}}"/>
+        <legend>Change email form</legend>
+        {{- template "_usererrors" index ._Errors ""}}
+        <label for="email">New email:</label> 
+          <input id="email" name="email" type="email" size="25" value="{{._Form.email}}" autofocus/>
+          {{- template "_usererrors" index ._Errors "email"}}<br/>
+        <input name="submit" type="submit" value="Submit" />
+      </fieldset>
+    </form>
+  {{end}}
+  <a href='?base'>User page</a>
+{{- template "_userfooter" -}}
+{{end}}
+
+{{- define "_userlogout" -}}
+  <form action="?logout" method="POST">
+    <input name="csrf_token" type="hidden" value="{{._CSRF}}"/>
+    <input name="submit" type="submit" value="Logout" />
+  </form>
+{{- end}}
+
+{{/* messages of a Validation field */}}
+{{- define "_usererrors" -}}
+  {{range .}} <span class="error">{{.}}</span>{{end}}
+{{- end}}
+
+{{- define "_usertotp" -}}
+{{- template "_userheader" -}}
+  Two-factor authentication page<hr/>
+  {{if ._Pending}}
+    <form action="?totp" method="POST">
+      <fieldset>
+        <legend>Authenticator app code</legend>
+        <input name="csrf_token" type="hidden" value="{{._CSRF}}"/>
+        <input name="next" type="hidden" value="{{._Form.next}}"/>
+        {{- template "_usererrors" index ._Errors ""}}
+        <label for="code">Code or recovery code:</label>
+          <input id="code" name="code" type="text" size="12" autocomplete="one-time-code" autofocus/>
+          {{- template "_usererrors" index ._Errors "code"}}<br/>
+        <input name="submit" type="submit" value="Submit" />
+      </fieldset>
+    </form>
+  {{else if ._Enabled}}
+    Two-factor authentication is on.
+    {{with ._RecoveryCodes}}
+      Write down the recovery codes, each of them logs in once without the app:
+      <ul>{{range .}}<li><code>{{.}}</code></li>{{end}}</ul>
+    {{else}}
+      Recovery codes left: {{._RecoveryCodesLeft}}
+    {{end}}
+    <form action="?totp" method="POST">
+      <fieldset>
+        <legend>New recovery codes</legend>
+        <input name="csrf_token" type="hidden" value="{{._CSRF}}"/>
+        <input name="action" type="hidden" value="recovery"/>
+        <label for="recovery-code">Code:</label>
+          <input id="recovery-code" name="code" type="text" size="10" inputmode="numeric" autocomplete="one-time-code"/><br/>
+        <input name="submit" type="submit" value="Generate" />
+      </fieldset>
+    </form>
+    <form action="?totp" method="POST">
+      <fieldset>
+        <legend>Turn off two-factor authentication</legend>
+        <input name="csrf_token" type="hidden" value="{{._CSRF}}"/>
+        <input name="action" type="hidden" value="disable"/>
+        {{- template "_usererrors" index ._Errors ""}}
+        <label for="code">Code:</label>
+          <input id="code" name="code" type="text" size="10" inputmode="numeric" autocomplete="one-time-code"/>
+          {{- template "_usererrors" index ._Errors "code"}}<br/>
+        <input name="submit" type="submit" value="Turn off" />
+      </fieldset>
+    </form>
+  {{else}}
+    Add this link to an authenticator app as a QR code:<br/>
+    <code>{{._TOTP.URI}}</code><br/>
+    or type in the key <code>{{._TOTP.Secret}}</code>
+    <form action="?totp" method="POST">
+      <fieldset>
+        <legend>Turn on two-factor authentication</legend>
+        <input name="csrf_token" type="hidden" value="{{._CSRF}}"/>
+        <input name="action" type="hidden" value="enable"/>
+        <input name="secret" type="hidden" value="{{._TOTP.Secret}}"/>
+        {{- template "_usererrors" index ._Errors ""}}
+        <label for="code">Code:</label>
+          <input id="code" name="code" type="text" size="10" inputmode="numeric" autocomplete="one-time-code" autofocus/>
+          {{- template "_usererrors" index ._Errors "code"}}<br/>
+        <input name="submit" type="submit" value="Turn on" />
+      </fieldset>
+    </form>
+  {{end}}
+  {{if not ._Pending}}<a href='?base'>User page</a>{{end}}
+{{- template "_userfooter" -}}
+{{end}}
 
 {{- define "_usercameout" -}}
 {{- template "_userheader" -}}
@@ -66,7 +264,9 @@ var defaultTemplate = `
 {{- template "_userheader" -}}
   User page<hr/>
   {{if ._User}}
-    <a href='?logout'>Logout</a><br/>
+    {{template "_userlogout" .}}
+    <a href='?email'>Change email</a><br/>
+    <a href='?totp'>Two-factor authentication</a><br/>
     Hello {{._User.Name}} you email {{._User.Email}} and enable {{._User.Enable}}<br/>
     Params:
     <hr/>
@@ -110,3 +310,108 @@ var defaultTemplate = `
 </html>
 {{- end -}}
 `
+
+// Mail templates: "_mail<kind>_subject" and "_mail<kind>_text" in
+// defaultMailTemplate, optional "_mail<kind>_html" in defaultMailHTMLTemplate.
+// Localized variants are named with a locale suffix, like "_mailreset_subject.ru".
+var defaultMailTemplate = `
+{{- define "_mailconfirmation_subject" -}}
+Registration confirmation
+{{- end -}}
+
+{{- define "_mailconfirmation_text" -}}
+Hello {{.User.Name}}!
+
+To confirm the registration follow the link:
+{{.Link}}
+
+If you did not register, ignore this email.
+{{end -}}
+
+{{- define "_mailreset_subject" -}}
+Password reset
+{{- end -}}
+
+{{- define "_mailreset_text" -}}
+Hello {{.User.Name}}!
+
+To set a new password follow the link:
+{{.Link}}
+
+If you did not ask for it, ignore this email.
+{{end -}}
+
+{{- define "_mailmagic_subject" -}}
+Login link
+{{- end -}}
+
+{{- define "_mailmagic_text" -}}
+Hello {{.User.Name}}!
+
+To log in follow the link, it works once and expires soon:
+{{.Link}}
+
+If you did not ask for it, ignore this email.
+{{end -}}
+
+{{- define "_mailemail_subject" -}}
+Email change confirmation
+{{- end -}}
+
+{{- define "_mailemail_text" -}}
+Hello {{.User.Name}}!
+
+To confirm {{.Email}} as your new email follow the link:
+{{.Link}}
+
+If you did not ask for it, ignore this email.
+{{end -}}
+
+{{- define "_mailpasswordchanged_subject" -}}
+Your password was changed
+{{- end -}}
+
+{{- define "_mailpasswordchanged_text" -}}
+Hello {{.User.Name}}!
+
+The password of your account was changed and all sessions were logged out.
+If it was not you, reset the password right away.
+{{end -}}
+
+{{- define "_mailemailchanged_subject" -}}
+Your email was changed
+{{- end -}}
+
+{{- define "_mailemailchanged_text" -}}
+Hello {{.User.Name}}!
+
+The email of your account was changed from {{.OldEmail}} to {{.Email}}.
+If it was not you, contact support right away.
+{{end -}}
+`
+
+var defaultMailHTMLTemplate = `
+{{- define "_mailconfirmation_html" -}}
+<p>Hello {{.User.Name}}!</p>
+<p>To confirm the registration follow the link: <a href="{{.Link}}">{{.Link}}</a></p>
+<p>If you did not register, ignore this email.</p>
+{{- end -}}
+
+{{- define "_mailreset_html" -}}
+<p>Hello {{.User.Name}}!</p>
+<p>To set a new password follow the link: <a href="{{.Link}}">{{.Link}}</a></p>
+<p>If you did not ask for it, ignore this email.</p>
+{{- end -}}
+
+{{- define "_mailmagic_html" -}}
+<p>Hello {{.User.Name}}!</p>
+<p>To log in follow the link, it works once and expires soon: <a href="{{.Link}}">{{.Link}}</a></p>
+<p>If you did not ask for it, ignore this email.</p>
+{{- end -}}
+
+{{- define "_mailemail_html" -}}
+<p>Hello {{.User.Name}}!</p>
+<p>To confirm {{.Email}} as your new email follow the link: <a href="{{.Link}}">{{.Link}}</a></p>
+<p>If you did not ask for it, ignore this email.</p>
+{{- end -}}
+`
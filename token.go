@@ -0,0 +1,127 @@
+package baxtep
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"time"
+)
+
+// TokenGenerator makes random session IDs, confirmation codes and
+// generated passwords from crypto/rand.
+type TokenGenerator struct {
+	Alphabet       string
+	SessionLength  int
+	ConfirmLength  int // at most 64, the confirmation column size
+	PasswordLength int
+}
+
+func NewTokenGenerator() *TokenGenerator {
+	return &TokenGenerator{
+		Alphabet:       "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789_-",
+		SessionLength:  64,
+		ConfirmLength:  32,
+		PasswordLength: 8,
+	}
+}
+
+// Generate returns length random characters of the alphabet,
+// uniformly distributed.
+func (g *TokenGenerator) Generate(length int) (string, error) {
+	alphabet := []rune(g.Alphabet)
+	if len(alphabet) < 2 || len(alphabet) > 256 {
+		return "", errors.New("token alphabet must have from 2 to 256 characters")
+	}
+	// bytes at or above max would make the first characters more likely
+	max := 256 - 256%len(alphabet)
+	res := make([]rune, 0, length)
+	buf := make([]byte, length)
+	for len(res) < length {
+		_, err := rand.Read(buf)
+		if err != nil {
+			return "", err
+		}
+		for _, b := range buf {
+			if int(b) < max && len(res) < length {
+				res = append(res, alphabet[int(b)%len(alphabet)])
+			}
+		}
+	}
+	return string(res), nil
+}
+
+func (g *TokenGenerator) sessionID() (string, error) {
+	return g.Generate(g.SessionLength)
+}
+
+func (g *TokenGenerator) confirmCode() (string, error) {
+	return g.Generate(g.ConfirmLength)
+}
+
+func (g *TokenGenerator) password() (string, error) {
+	return g.Generate(g.PasswordLength)
+}
+
+// hashToken is how session IDs are kept in the store, so that a database
+// dump does not leak live sessions.
+func hashToken(token string) string {
+	hash := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(hash[:])
+}
+
+// newToken replaces the user's tokens of kind with a new one valid for d
+// and returns its value.
+func (b *Baxtep) newToken(userID int64, kind, data string, d time.Duration) (string, error) {
+	value, err := b.tokens.confirmCode()
+	if err != nil {
+		return "", err
+	}
+	return b.newTokenValue(userID, kind, value, data, d)
+}
+
+// newTokenValue is newToken with a value made by the caller.
+func (b *Baxtep) newTokenValue(userID int64, kind, value, data string, d time.Duration) (string, error) {
+	err := b.store.DeleteTokens(userID, kind)
+	if err != nil {
+		return "", err
+	}
+	err = b.store.AddToken(Token{
+		ID:      hashToken(value),
+		UserID:  userID,
+		Kind:    kind,
+		Expires: time.Now().UTC().Add(d),
+		Data:    data,
+	})
+	if err != nil {
+		return "", err
+	}
+	return value, nil
+}
+
+// checkToken returns a valid token and its owner without using it up.
+func (b *Baxtep) checkToken(value, kind string) (User, Token, error) {
+	t, err := b.store.GetToken(hashToken(value), kind)
+	return b.tokenUser(t, err)
+}
+
+// takeToken uses up the token and returns it with its owner.
+func (b *Baxtep) takeToken(value, kind string) (User, Token, error) {
+	t, err := b.store.TakeToken(hashToken(value), kind)
+	return b.tokenUser(t, err)
+}
+
+func (b *Baxtep) tokenUser(t Token, err error) (User, Token, error) {
+	if err == sql.ErrNoRows {
+		return User{b: b}, t, ErrUserTokenNotFound
+	}
+	if err != nil {
+		return User{b: b}, t, err
+	}
+	if time.Now().UTC().After(t.Expires) {
+		return User{b: b}, t, ErrUserTokenExpired
+	}
+	u, err := b.GetUserByID(t.UserID)
+	return u, t, err
+}
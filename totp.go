@@ -0,0 +1,393 @@
+package baxtep
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// RFC 6238 parameters every authenticator app supports.
+const (
+	totpPeriod = 30
+	totpDigits = 6 // hotp formats exactly 6
+	totpSkew   = 1 // steps accepted before and after the current one
+)
+
+const (
+	tokenTOTP       = "totp"
+	totpCookie      = "totp_pending"
+	totpPendingTime = 5 * time.Minute
+)
+
+var totpEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// TOTPKey is a new TOTP secret for enrollment. URI is the otpauth://
+// link authenticator apps read from a QR code, Secret is for typing in.
+type TOTPKey struct {
+	Secret string
+	URI    string
+}
+
+// NewTOTPKey generates a secret for User.EnableTOTP. Issuer and account,
+// usually the site and the email, are shown in the authenticator app.
+func NewTOTPKey(issuer, account string) (TOTPKey, error) {
+	secret := make([]byte, 20)
+	_, err := rand.Read(secret)
+	if err != nil {
+		return TOTPKey{}, err
+	}
+	return totpKey(issuer, account, totpEncoding.EncodeToString(secret)), nil
+}
+
+func totpKey(issuer, account, secret string) TOTPKey {
+	v := url.Values{}
+	v.Set("secret", secret)
+	v.Set("issuer", issuer)
+	v.Set("algorithm", "SHA1")
+	v.Set("digits", fmt.Sprint(totpDigits))
+	v.Set("period", fmt.Sprint(totpPeriod))
+	u := url.URL{
+		Scheme:   "otpauth",
+		Host:     "totp",
+		Path:     "/" + issuer + ":" + account,
+		RawQuery: v.Encode(),
+	}
+	return TOTPKey{Secret: secret, URI: u.String()}
+}
+
+func decodeTOTPSecret(secret string) ([]byte, error) {
+	secret = strings.ToUpper(strings.Replace(secret, " ", "", -1))
+	return totpEncoding.DecodeString(strings.TrimRight(secret, "="))
+}
+
+// hotp is the RFC 4226 code of the counter.
+func hotp(key []byte, counter uint64) string {
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], counter)
+	mac := hmac.New(sha1.New, key)
+	mac.Write(msg[:])
+	sum := mac.Sum(nil)
+	offset := sum[len(sum)-1] & 0x0f
+	code := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	return fmt.Sprintf("%06d", code%1000000)
+}
+
+// totpMatch returns the time step code is valid for, or -1. Steps up to
+// last are already used, so a code works only once.
+func totpMatch(key []byte, code string, now time.Time, last int64) int64 {
+	code = strings.TrimSpace(code)
+	current := now.Unix() / totpPeriod
+	for step := current - totpSkew; step <= current+totpSkew; step++ {
+		if step > last && subtle.ConstantTimeCompare([]byte(hotp(key, uint64(step))), []byte(code)) == 1 {
+			return step
+		}
+	}
+	return -1
+}
+
+// SetSecretKey sets the key TOTP secrets are encrypted with in the
+// database. Keep it out of the database, changing it turns off 2FA
+// for everyone.
+func (b *Baxtep) SetSecretKey(key []byte) {
+	hash := sha256.Sum256(key)
+	b.secretKey = hash[:]
+}
+
+func (b *Baxtep) gcm() (cipher.AEAD, error) {
+	if b.secretKey == nil {
+		return nil, ErrNoSecretKey
+	}
+	block, err := aes.NewCipher(b.secretKey)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func (b *Baxtep) encrypt(plain string) (string, error) {
+	gcm, err := b.gcm()
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	_, err = rand.Read(nonce)
+	if err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(gcm.Seal(nonce, nonce, []byte(plain), nil)), nil
+}
+
+func (b *Baxtep) decrypt(encrypted string) (string, error) {
+	gcm, err := b.gcm()
+	if err != nil {
+		return "", err
+	}
+	data, err := base64.StdEncoding.DecodeString(encrypted)
+	if err != nil {
+		return "", err
+	}
+	if len(data) < gcm.NonceSize() {
+		return "", errors.New("encrypted data too short")
+	}
+	plain, err := gcm.Open(nil, data[:gcm.NonceSize()], data[gcm.NonceSize():], nil)
+	return string(plain), err
+}
+
+// EnableTOTP turns on 2FA with a NewTOTPKey secret once the user proves
+// the authenticator app has it with a current code. It returns new
+// recovery codes for the user to write down.
+func (u *User) EnableTOTP(secret, code string) ([]string, error) {
+	key, err := decodeTOTPSecret(secret)
+	if err != nil || len(key) == 0 {
+		return nil, ErrUserBadCode
+	}
+	step := totpMatch(key, code, time.Now(), 0)
+	if step < 0 {
+		return nil, ErrUserBadCode
+	}
+	encrypted, err := u.b.encrypt(secret)
+	if err != nil {
+		return nil, err
+	}
+	err = u.b.store.SetUserTOTP(u.id, encrypted, step)
+	if err != nil {
+		return nil, err
+	}
+	return u.NewRecoveryCodes()
+}
+
+func (u *User) DisableTOTP() error {
+	err := u.b.store.SetUserTOTP(u.id, "", 0)
+	if err != nil {
+		return err
+	}
+	return u.b.store.SetRecoveryCodes(u.id, nil)
+}
+
+func (u *User) HasTOTP() (bool, error) {
+	secret, _, err := u.b.store.GetUserTOTP(u.id)
+	return secret != "", err
+}
+
+// VerifyTOTP checks a code of the authenticator app, each code works once.
+// Wrong codes count for the lockout like wrong passwords.
+func (u *User) VerifyTOTP(code string) error {
+	_, err := u.checkLock()
+	if err != nil {
+		return err
+	}
+	encrypted, last, err := u.b.store.GetUserTOTP(u.id)
+	if err != nil {
+		return err
+	}
+	if encrypted == "" {
+		return ErrUserBadCode
+	}
+	secret, err := u.b.decrypt(encrypted)
+	if err != nil {
+		return err
+	}
+	key, err := decodeTOTPSecret(secret)
+	if err != nil {
+		return err
+	}
+	step := totpMatch(key, code, time.Now(), last)
+	if step < 0 {
+		err = u.loginFailed()
+		if err != nil {
+			return err
+		}
+		return ErrUserBadCode
+	}
+	return u.b.store.SetUserTOTP(u.id, encrypted, step)
+}
+
+// secondFactor keeps a user with 2FA half logged in: the pending cookie
+// is good for the ?totp step only. The next param goes on to that step.
+func (uh *Handler) secondFactor(w http.ResponseWriter, r *http.Request, u User) {
+	token, err := uh.Config.Baxter.newToken(u.id, tokenTOTP, "", totpPendingTime)
+	if err != nil {
+		uh.logPrintf("Login second factor token error: %s", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	http.SetCookie(w, &http.Cookie{
+		Path:     "/",
+		Name:     totpCookie,
+		Value:    token,
+		Expires:  time.Now().Add(totpPendingTime),
+		HttpOnly: true,
+	})
+	action := "totp"
+	if next := safeNext(r.FormValue("next")); next != "" {
+		action += "&next=" + url.QueryEscape(next)
+	}
+	uh.redirect(w, r, action)
+}
+
+func (uh *Handler) totp(w http.ResponseWriter, r *http.Request) {
+	if c, err := r.Cookie(totpCookie); err == nil && c.Value != "" {
+		uh.totpLogin(w, r, c.Value)
+		return
+	}
+	uh.totpSetup(w, r)
+}
+
+// totpLogin is the second step of the login of a user with 2FA.
+func (uh *Handler) totpLogin(w http.ResponseWriter, r *http.Request, pending string) {
+	userdata, v := uh.getFormData(w, r, "next")
+	userdata["_Pending"] = true
+	status := http.StatusOK
+	u, _, err := uh.Config.Baxter.checkToken(pending, tokenTOTP)
+	if err == ErrUserTokenNotFound || err == ErrUserTokenExpired {
+		http.SetCookie(w, &http.Cookie{Path: "/", Name: totpCookie, Expires: time.Unix(0, 0), HttpOnly: true})
+		uh.redirect(w, r, "login")
+		return
+	}
+	if err != nil {
+		uh.logPrintf("TOTP checkToken error: %s", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	if r.Method == "POST" {
+		v.required(r, "code")
+		if !v.Valid() {
+			status = http.StatusUnprocessableEntity
+		} else if !uh.allowLogin(r, u.Email) {
+			v.Add("", "Too many login attempts, try again later")
+			status = http.StatusTooManyRequests
+		} else {
+			err = u.verifySecondFactor(r.FormValue("code"))
+			switch err {
+			case nil:
+				_, _, err = uh.Config.Baxter.takeToken(pending, tokenTOTP)
+				if err != nil {
+					// used up by a parallel request
+					uh.redirect(w, r, "login")
+					return
+				}
+				http.SetCookie(w, &http.Cookie{Path: "/", Name: totpCookie, Expires: time.Unix(0, 0), HttpOnly: true})
+				uh.logIn(w, r, u)
+				return
+			case ErrUserBadCode:
+				v.Add("code", "Wrong code")
+			case ErrUserLocked:
+				v.Add("", "Too many wrong codes, the account is locked for a while")
+			default:
+				uh.logPrintf("TOTP VerifyTOTP error: %s", err)
+				http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+				return
+			}
+			status = http.StatusForbidden
+		}
+	}
+	uh.totpTemplate(w, userdata, status)
+}
+
+// totpSetup turns 2FA on and off for the logged in user.
+func (uh *Handler) totpSetup(w http.ResponseWriter, r *http.Request) {
+	userdata, v := uh.getFormData(w, r)
+	u, ok := userdata["_User"].(User)
+	if !ok {
+		uh.redirect(w, r, "login")
+		return
+	}
+	status := http.StatusOK
+	if r.Method == "POST" {
+		v.required(r, "code")
+		var err error
+		var codes []string
+		switch {
+		case !v.Valid():
+		case r.FormValue("action") == "disable":
+			err = u.verifySecondFactor(r.FormValue("code"))
+			if err == nil {
+				err = u.DisableTOTP()
+			}
+		case r.FormValue("action") == "recovery":
+			err = u.VerifyTOTP(r.FormValue("code"))
+			if err == nil {
+				codes, err = u.NewRecoveryCodes()
+			}
+		default:
+			codes, err = u.EnableTOTP(r.FormValue("secret"), r.FormValue("code"))
+		}
+		userdata["_RecoveryCodes"] = codes
+		switch err {
+		case nil:
+		case ErrUserBadCode:
+			v.Add("code", "Wrong code")
+		case ErrUserLocked:
+			v.Add("", "Too many wrong codes, the account is locked for a while")
+		default:
+			uh.logPrintf("TOTP setup error: %s", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+		if !v.Valid() {
+			status = http.StatusUnprocessableEntity
+		}
+	}
+	enabled, err := u.HasTOTP()
+	if err != nil {
+		uh.logPrintf("TOTP HasTOTP error: %s", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	userdata["_Enabled"] = enabled
+	if enabled {
+		userdata["_RecoveryCodesLeft"], err = u.RecoveryCodesLeft()
+		if err != nil {
+			uh.logPrintf("TOTP RecoveryCodesLeft error: %s", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+	} else {
+		issuer := uh.Config.TOTPIssuer
+		if issuer == "" {
+			issuer = r.Host
+		}
+		// after a wrong code keep the secret the app has already scanned
+		key := totpKey(issuer, u.Email, r.PostFormValue("secret"))
+		if _, err := decodeTOTPSecret(key.Secret); err != nil || key.Secret == "" {
+			key, err = NewTOTPKey(issuer, u.Email)
+			if err != nil {
+				uh.logPrintf("TOTP NewTOTPKey error: %s", err)
+				http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+				return
+			}
+		}
+		userdata["_TOTP"] = key
+	}
+	uh.totpTemplate(w, userdata, status)
+}
+
+func (uh *Handler) totpTemplate(w http.ResponseWriter, userdata map[string]interface{}, status int) {
+	w.Header().Set("Content-Type", "text/html")
+	err := uh.checkTemplate()
+	if err != nil {
+		uh.logPrintf("TOTP template error: %s", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(status)
+	err = uh.Config.tmpl.ExecuteTemplate(w, "_usertotp", userdata)
+	if err != nil {
+		uh.logPrintf("TOTP template execute error: %s", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+}
@@ -0,0 +1,85 @@
+package baxtep
+
+import (
+	"testing"
+	"time"
+)
+
+func TestHOTP(t *testing.T) {
+	// RFC 4226 Appendix D
+	key := []byte("12345678901234567890")
+	for counter, want := range []string{
+		"755224", "287082", "359152", "969429", "338314",
+		"254676", "287922", "162583", "399871", "520489",
+	} {
+		if code := hotp(key, uint64(counter)); code != want {
+			t.Errorf("counter %d: %s, want %s", counter, code, want)
+		}
+	}
+}
+
+func TestTOTPMatch(t *testing.T) {
+	key := []byte("12345678901234567890")
+	now := time.Unix(1111111109, 0)
+	current := now.Unix() / totpPeriod
+	code := func(step int64) string { return hotp(key, uint64(step)) }
+
+	if step := totpMatch(key, code(current), now, 0); step != current {
+		t.Errorf("current code: step %d, want %d", step, current)
+	}
+	if step := totpMatch(key, " "+code(current)+" ", now, 0); step != current {
+		t.Errorf("code with spaces: step %d", step)
+	}
+	if step := totpMatch(key, code(current), now, current); step != -1 {
+		t.Errorf("reused step: %d", step)
+	}
+	if step := totpMatch(key, code(current-1), now, current-1); step != -1 {
+		t.Errorf("step before the used one: %d", step)
+	}
+	for _, skew := range []int64{-totpSkew, totpSkew} {
+		if step := totpMatch(key, code(current+skew), now, 0); step != current+skew {
+			t.Errorf("skew %d: step %d", skew, step)
+		}
+	}
+	for _, skew := range []int64{-totpSkew - 1, totpSkew + 1} {
+		if step := totpMatch(key, code(current+skew), now, 0); step != -1 {
+			t.Errorf("skew %d is accepted: step %d", skew, step)
+		}
+	}
+	if step := totpMatch(key, "000000x", now, 0); step != -1 {
+		t.Errorf("bad code: step %d", step)
+	}
+}
+
+func TestSecretEncryption(t *testing.T) {
+	b := NewBaxtep(nil)
+	if _, err := b.encrypt("secret"); err != ErrNoSecretKey {
+		t.Errorf("encrypt without a key: %v", err)
+	}
+	if _, err := b.decrypt("c2VjcmV0"); err != ErrNoSecretKey {
+		t.Errorf("decrypt without a key: %v", err)
+	}
+
+	b.SetSecretKey([]byte("key"))
+	key, err := NewTOTPKey("Example", "user@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	encrypted, err := b.encrypt(key.Secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	again, _ := b.encrypt(key.Secret)
+	if encrypted == again {
+		t.Error("same ciphertext twice, the nonce is not random")
+	}
+	plain, err := b.decrypt(encrypted)
+	if err != nil || plain != key.Secret {
+		t.Errorf("round trip: %q, %v", plain, err)
+	}
+
+	b.SetSecretKey([]byte("other key"))
+	if _, err := b.decrypt(encrypted); err == nil {
+		t.Error("decrypted with another key")
+	}
+}
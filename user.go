@@ -2,30 +2,22 @@ package baxtep
 
 import (
 	"database/sql"
-	"fmt"
-	"github.com/go-sql-driver/mysql"
-	"strings"
 	"time"
 )
 
 type User struct {
-	db      database
-	id      int64
-	Name    string
-	Email   string
+	b      *Baxtep
+	id     int64
+	Name   string
+	Email  string
 	Enable bool
+
+	session string // ID of the session the user was found by
+	org     int64  // the org the user is scoped to, 0 for none
 }
 
-func checkExistUserEmail(db database, email string) error {
-	var count int64
-	var row *sql.Row
-	switch db.driver {
-	case "mysql":
-		row = db.conn.QueryRow("SELECT COUNT(*) FROM `"+db.prefix+"` WHERE `email`=?", email)
-	case "ql", "ql-mem":
-		row = db.conn.QueryRow("SELECT count(*) FROM "+db.prefix+" WHERE `email`=$1", email)
-	}
-	err := row.Scan(&count)
+func checkExistUserEmail(store Store, email string) error {
+	count, err := store.CountUserEmail(email)
 	if err != nil {
 		return err
 	}
@@ -40,22 +32,12 @@ func (u *User) GetID() int64 {
 }
 
 func (u *User) setEnabled(enable bool) error {
-	tx, err := u.db.conn.Begin()
-	if err != nil {
-		return err
-	}
-	defer tx.Rollback()
-	switch u.db.driver {
-	case "mysql":
-		_, err = tx.Exec("UPDATE `"+u.db.prefix+"` SET `enable`=? WHERE id=?", enable, u.id)
-	case "ql", "ql-mem":
-		_, err = tx.Exec("UPDATE "+u.db.prefix+" SET enable=$1 WHERE id()=$2", enable, u.id)
-	}
+	err := u.b.store.SetUserEnable(u.id, enable)
 	if err != nil {
 		return err
 	}
-	u.Enable = false
-	return tx.Commit()
+	u.Enable = enable
+	return nil
 }
 
 func (u *User) SetEnable() error {
@@ -66,156 +48,177 @@ func (u *User) SetDisable() error {
 	return u.setEnabled(false)
 }
 
+// CheckPassword verifies password and upgrades the stored hash
+// if it was made by an outdated hasher or parameters.
+// After SetLockout failures in a row the user is locked and gets
+// ErrUserLocked without the password being checked.
 func (u *User) CheckPassword(password string) error {
-	var (
-		passhash string
-		row *sql.Row
-	)
-	switch u.db.driver {
-	case "mysql":
-		row = u.db.conn.QueryRow("SELECT `password` FROM `"+u.db.prefix+"` WHERE `id`=?", u.id)
-	case "ql", "ql-mem":
-		row = u.db.conn.QueryRow("SELECT password FROM "+u.db.prefix+" WHERE id()=$1", u.id)
-	}
-	err := row.Scan(&passhash)
+	failures, err := u.checkLock()
 	if err != nil {
 		return err
 	}
-	if getPasswordHash(password) != passhash {
+	passhash, err := u.b.store.GetUserPassword(u.id)
+	if err != nil {
+		return err
+	}
+	ok, rehash, err := verifyPassword(u.b.hasher, password, passhash)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		err = u.loginFailed()
+		if err != nil {
+			return err
+		}
 		return ErrUserBadPassword
 	}
+	if failures != 0 {
+		err = u.Unlock()
+		if err != nil {
+			return err
+		}
+	}
+	if rehash {
+		return u.SetNewPassword(password)
+	}
 	return nil
 }
 
 func (u *User) GetUpdate() error {
-	var row *sql.Row
-	switch u.db.driver {
-	case "mysql":
-		row = u.db.conn.QueryRow("SELECT `name`, `email`, `enable` FROM `"+u.db.prefix+"` WHERE `id`=?", u.id)
-	case "ql", "ql-mem":
-		row = u.db.conn.QueryRow("SELECT name, email, enable FROM "+u.db.prefix+" WHERE id()=$1", u.id)
-	}
-	return row.Scan(&u.Name, &u.Email, &u.Enable)
-}
-
-func (u *User) SetNewEmail(email string) error {
-	err := checkExistUserEmail(u.db, email)
+	d, err := u.b.store.GetUserByID(u.id)
 	if err != nil {
 		return err
 	}
-	tx, err := u.db.conn.Begin()
+	u.Name, u.Email, u.Enable = d.Name, d.Email, d.Enable
+	return nil
+}
+
+func (u *User) SetNewEmail(email string) error {
+	err := checkExistUserEmail(u.b.store, email)
 	if err != nil {
 		return err
 	}
-	defer tx.Rollback()
-	switch u.db.driver {
-	case "mysql":
-		_, err = u.db.conn.Exec("UPDATE `"+u.db.prefix+"` SET `email`=? WHERE id=?", email, u.id)
-	case "ql", "ql-mem":
-		_, err = u.db.conn.Exec("UPDATE "+u.db.prefix+" SET email=$1 WHERE id()=$2", email, u.id)
-	}
+	err = u.b.store.SetUserEmail(u.id, email)
 	if err != nil {
 		return err
 	}
 	u.Email = email
-	return tx.Commit()
+	return nil
 }
 
 func (u *User) SetNewPassword(password string) error {
-	passhash := getPasswordHash(password)
-	tx, err := u.db.conn.Begin()
+	passhash, err := u.b.hasher.Hash(password)
 	if err != nil {
 		return err
 	}
-	defer tx.Rollback()
-	switch u.db.driver {
-	case "mysql":
-		_, err = tx.Exec("UPDATE `"+u.db.prefix+"` SET `password`=? WHERE `id`=?", passhash, u.id)
-	case "ql", "ql-mem":
-		_, err = tx.Exec("UPDATE "+u.db.prefix+" SET password=$1 WHERE id()=$2", passhash, u.id)
-	}
-	if err != nil {
-		return err
-	}
-	return tx.Commit()
+	return u.b.store.SetUserPassword(u.id, passhash)
 }
 
 func (u *User) GetNewPassword() (string, error) {
-	password := generateRandomString(8)
-	err := u.SetNewPassword(password)
+	password, err := u.b.tokens.password()
+	if err != nil {
+		return "", err
+	}
+	err = u.SetNewPassword(password)
 	return password, err
 }
 
+// CheckSessionID checks that the session the user was found by
+// with Baxtep.GetUserBySessionID is not revoked or expired.
 func (u *User) CheckSessionID(expiredDuration time.Duration) error {
-	var (
-		sessionTime      time.Time
-		mysqlSessionTime mysql.NullTime
-		query string
-	)
-	switch u.db.driver {
-	case "mysql":
-		query = "SELECT `session_time` FROM `"+u.db.prefix+"` WHERE `id`=?"
-	case "ql", "ql-mem":
-		query = "SELECT session_time FROM "+u.db.prefix+" WHERE id()=$1"
-	}
-	row := u.db.conn.QueryRow(query, u.id)
-	err := row.Scan(&sessionTime)
+	session, err := u.b.store.GetSession(u.session)
 	if err == sql.ErrNoRows {
-		return ErrUserNotFound
+		return ErrUserSessionNotFound
 	}
 	if err != nil {
 		return err
 	}
-	if mysqlSessionTime.Valid {
-		sessionTime = mysqlSessionTime.Time
+	if session.UserID != u.id {
+		return ErrUserSessionNotFound
 	}
-	if time.Now().UTC().After(sessionTime.Add(expiredDuration)) {
+	now := time.Now().UTC()
+	if now.After(session.Created.Add(expiredDuration)) {
+		err = u.b.store.DeleteSession(u.id, session.ID)
+		if err != nil {
+			return err
+		}
 		return ErrUserSessionExpired
 	}
+	// don't write on every request
+	if now.Sub(session.LastSeen) > time.Minute {
+		return u.b.store.TouchSession(session.ID, now)
+	}
 	return nil
 }
 
+// SetNewSessionID starts a new session and returns its cookie value.
 func (u *User) SetNewSessionID() (string, error) {
-	var err error
-	sessionID := generateRandomString(64)
-	tx, err := u.db.conn.Begin()
+	return u.NewSession("", "")
+}
+
+// NewSession starts a new session from the given device and returns
+// its cookie value. Other sessions of the user stay valid.
+func (u *User) NewSession(userAgent, ip string) (string, error) {
+	sessionID, err := u.b.tokens.sessionID()
 	if err != nil {
 		return "", err
 	}
-	defer tx.Rollback()
-	switch u.db.driver {
-	case "mysql":
-		_, err = tx.Exec("UPDATE `"+u.db.prefix+"` SET `session_id`=?, `session_time`=? WHERE `id`=?", sessionID, time.Now(), u.id)
-	case "ql", "ql-mem":
-		_, err = tx.Exec("UPDATE "+u.db.prefix+" SET session_id=$1, session_time=$2 WHERE id()=$3", sessionID, time.Now(), u.id)
-	}
+	if r := []rune(userAgent); len(r) > 250 {
+		userAgent = string(r[:250])
+	}
+	now := time.Now().UTC()
+	err = u.b.store.AddSession(Session{
+		ID:        hashToken(sessionID),
+		UserID:    u.id,
+		Created:   now,
+		LastSeen:  now,
+		UserAgent: userAgent,
+		IP:        ip,
+	})
 	if err != nil {
 		return "", err
 	}
-	err = tx.Commit()
-	return sessionID, err
+	return sessionID, nil
 }
 
-func (u *User) AddParams(params ...map[string]string) error {
-	tx, err := u.db.conn.Begin()
-	if err != nil {
-		return err
+// SessionID returns the ID of the session the user was found by.
+func (u *User) SessionID() string {
+	return u.session
+}
+
+func (u *User) Sessions() ([]Session, error) {
+	return u.b.store.GetSessions(u.id)
+}
+
+func (u *User) RevokeSession(id string) error {
+	return u.b.store.DeleteSession(u.id, id)
+}
+
+func (u *User) RevokeAllSessions() error {
+	return u.b.store.DeleteSessions(u.id)
+}
+
+// GetLocale returns the "locale" param used for emails to the user,
+// empty if not set.
+func (u *User) GetLocale() (string, error) {
+	locale, err := u.GetParam("locale")
+	if err != nil || len(locale) == 0 {
+		return "", err
 	}
-	defer tx.Rollback()
-	for i := range params {
-		for k, v := range params[i] {
-			switch u.db.driver {
-			case "mysql":
-				_, err = tx.Exec("INSERT INTO `"+u.db.prefix+"_param` (`user_id`, `key`, `val`) VALUES (?, ?, ?)", u.id, k, v)
-			case "ql", "ql-mem":
-				_, err = tx.Exec("INSERT INTO "+u.db.prefix+"_param (user_id, key, val) VALUES ($1, $2, $3)", u.id, k, v)
-			}
-			if err != nil {
-				return nil
-			}
-		}
+	return locale[0], nil
+}
+
+func (u *User) SetLocale(locale string) error {
+	return u.UpdateParams("locale", locale)
+}
+
+// AddParams, UpdateParams and DeleteParams of a user scoped to an org
+// change the user's params in the org.
+func (u *User) AddParams(params ...map[string]string) error {
+	if u.org != 0 {
+		return u.b.store.AddOrgParams(u.org, u.id, params...)
 	}
-	return tx.Commit()
+	return u.b.store.AddParams(u.id, params...)
 }
 
 func (u *User) UpdateParams(key string, value ...string) error {
@@ -230,119 +233,67 @@ func (u *User) UpdateParams(key string, value ...string) error {
 	return u.AddParams(params...)
 }
 
+// HasParam, HasParamValue, GetParam and GetParams see the params of the
+// user's groups too, for keys the user does not have. Params in the org
+// of a scoped user come first.
 func (u *User) HasParam(key string) (bool, error) {
-	var (
-		cnt int64
-		err error
-	)
-	switch u.db.driver {
-	case "mysql":
-		err = u.db.conn.QueryRow("SELECT COUNT(*) FROM `"+u.db.prefix+"_param` WHERE `user_id`=? AND `key`=?", u.id, key).Scan(&cnt)
-	case "ql", "ql-mem":
-		err = u.db.conn.QueryRow("SELECT count(*) FROM "+u.db.prefix+"_param WHERE user_id=$1 AND key=$2", u.id, key).Scan(&cnt)
-	}
-	return cnt > 0, err
+	values, err := u.GetParam(key)
+	return len(values) > 0, err
 }
 
 func (u *User) HasParamValue(key, value string) (bool, error) {
-	var (
-		cnt int64
-		err error
-	)
-	switch u.db.driver {
-	case "mysql":
-		err = u.db.conn.QueryRow("SELECT COUNT(*) FROM `"+u.db.prefix+"_param` WHERE `user_id`=? AND `key`=? AND `val`=?", u.id, key, value).Scan(&cnt)
-	case "ql", "ql-mem":
-		err = u.db.conn.QueryRow("SELECT count(*) FROM "+u.db.prefix+"_param WHERE user_id=$1 AND key=$2 AND val=$3", u.id, key, value).Scan(&cnt)
-	}
-	return cnt > 0, err
+	values, err := u.GetParam(key)
+	if err != nil {
+		return false, err
+	}
+	return contains(values, value), nil
 }
 
 func (u *User) GetParam(key string) ([]string, error) {
-	var (
-		param []string
-		err   error
-	)
-	var rows *sql.Rows
-	switch u.db.driver {
-	case "mysql":
-		rows, err = u.db.conn.Query("SELECT `val` FROM `"+u.db.prefix+"_param` WHERE `user_id`=? AND `key`=?", u.id, key)
-	case "ql", "ql-mem":
-		rows, err = u.db.conn.Query("SELECT val FROM "+u.db.prefix+"_param WHERE user_id=$1 AND key=$2", u.id, key)
-	}
-	if err != nil {
-		return param, err
-	}
-	defer rows.Close()
-	for rows.Next() {
-		var v string
-		err = rows.Scan(&v)
-		if err != nil {
-			return param, err
+	if u.org != 0 {
+		params, err := u.b.store.GetOrgParams(u.org, u.id)
+		if err != nil || len(params[key]) > 0 {
+			return params[key], err
 		}
-		param = append(param, v)
 	}
-	return param, nil
+	values, err := u.b.store.GetParam(u.id, key)
+	if err != nil || len(values) > 0 {
+		return values, err
+	}
+	params, err := u.groupParams()
+	return params[key], err
 }
 
 func (u *User) GetParams() (map[string][]string, error) {
-	var (
-		params = map[string][]string{}
-		rows *sql.Rows
-		err    error
-	)
-	switch u.db.driver {
-	case "mysql":
-		rows, err = u.db.conn.Query("SELECT `key`, `val` FROM `"+u.db.prefix+"_param` WHERE `user_id`=?", u.id)
-	case "ql", "ql-mem":
-		rows, err = u.db.conn.Query("SELECT key, val FROM "+u.db.prefix+"_param WHERE user_id=$1", u.id)
+	params, err := u.b.store.GetParams(u.id)
+	if err != nil {
+		return params, err
 	}
+	inherited, err := u.groupParams()
 	if err != nil {
 		return params, err
 	}
-	defer rows.Close()
-	for rows.Next() {
-		var k, v string
-		err = rows.Scan(&k, &v)
+	for k, v := range inherited {
+		if _, ok := params[k]; !ok {
+			params[k] = v
+		}
+	}
+	if u.org != 0 {
+		scoped, err := u.b.store.GetOrgParams(u.org, u.id)
 		if err != nil {
 			return params, err
 		}
-		if _, ok := params[k]; !ok {
-			params[k] = []string{}
+		for k, v := range scoped {
+			params[k] = v
 		}
-		params[k] = append(params[k], v)
 	}
 	return params, nil
 }
 
+// DeleteParams deletes the user's own params, not the inherited ones.
 func (u *User) DeleteParams(keys ...string) error {
-	var (
-		placeholders string
-		params       []interface{}
-	)
-	tx, err := u.db.conn.Begin()
-	if err !=nil {
-		return nil
-	}
-	defer tx.Rollback()
-	switch u.db.driver {
-	case "mysql":
-		placeholders = strings.TrimLeft(strings.Repeat(", ?", len(keys)), ", ")
-		for i := range keys {
-			params = append(params, keys[i])
-		}
-		_, err = tx.Exec(fmt.Sprintf("DELETE FROM `"+u.db.prefix+"_param` WHERE `user_id`=%d AND `key` IN (%s)", u.id, placeholders), params...)
-	case "ql", "ql-mem":
-		var placeholders string
-		for i := range keys {
-			params = append(params, keys[i])
-			placeholders += fmt.Sprintf("$%d, ", i + 1)
-		}
-		placeholders = placeholders[:len(placeholders)-2]
-		_, err = tx.Exec(fmt.Sprintf("DELETE FROM "+u.db.prefix+"_param WHERE user_id=%d AND key IN (%s)", u.id, placeholders), params...)
-	}
-	if err != nil {
-		return err
+	if u.org != 0 {
+		return u.b.store.DeleteOrgParams(u.org, u.id, keys...)
 	}
-	return tx.Commit()
+	return u.b.store.DeleteParams(u.id, keys...)
 }
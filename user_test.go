@@ -0,0 +1,78 @@
+package baxtep
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSessions(t *testing.T) {
+	b := newTestBaxtep(t)
+	u := addTestUser(t, b, "user", "user@example.com")
+	other := addTestUser(t, b, "other", "other@example.com")
+
+	phone, err := u.NewSession("phone", "192.0.2.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	laptop, err := u.NewSession("laptop", "192.0.2.2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if phone == laptop {
+		t.Fatal("same session ID twice")
+	}
+	if _, err = b.store.GetSession(phone); err == nil {
+		t.Error("the session ID is stored in plaintext")
+	}
+	sessions, err := u.Sessions()
+	if err != nil || len(sessions) != 2 {
+		t.Fatalf("sessions: %v, %v", sessions, err)
+	}
+
+	// both devices stay logged in
+	for _, id := range []string{phone, laptop} {
+		logged, err := b.GetUserBySessionID(id)
+		if err != nil || logged.GetID() != u.GetID() {
+			t.Fatalf("user of the session: %d, %v", logged.GetID(), err)
+		}
+		if err = logged.CheckSessionID(time.Hour); err != nil {
+			t.Errorf("CheckSessionID: %v", err)
+		}
+	}
+	if _, err = b.GetUserBySessionID("unknown"); err != ErrUserSessionNotFound {
+		t.Errorf("unknown session: %v", err)
+	}
+
+	// a user can't revoke sessions of another one
+	logged, _ := b.GetUserBySessionID(phone)
+	if err = other.RevokeSession(logged.SessionID()); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = b.GetUserBySessionID(phone); err != nil {
+		t.Errorf("session revoked by another user: %v", err)
+	}
+	if err = u.RevokeSession(logged.SessionID()); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = b.GetUserBySessionID(phone); err != ErrUserSessionNotFound {
+		t.Errorf("revoked session: %v", err)
+	}
+	if _, err = b.GetUserBySessionID(laptop); err != nil {
+		t.Errorf("other session after revoke: %v", err)
+	}
+
+	logged, _ = b.GetUserBySessionID(laptop)
+	if err = logged.CheckSessionID(0); err != ErrUserSessionExpired {
+		t.Errorf("expired session: %v", err)
+	}
+	if _, err = b.GetUserBySessionID(laptop); err != ErrUserSessionNotFound {
+		t.Errorf("expired session is kept: %v", err)
+	}
+
+	u.NewSession("", "")
+	u.NewSession("", "")
+	u.RevokeAllSessions()
+	if sessions, _ = u.Sessions(); len(sessions) != 0 {
+		t.Errorf("sessions after RevokeAllSessions: %v", sessions)
+	}
+}
@@ -0,0 +1,52 @@
+package baxtep
+
+import (
+	"net/http"
+	"net/mail"
+)
+
+// Validation is the result of form checks: field name to error messages.
+// Errors of the whole form, like a wrong password, are under "".
+// Templates get it as ._Errors and the entered values as ._Form.
+type Validation map[string][]string
+
+func (v Validation) Add(field, message string) {
+	v[field] = append(v[field], message)
+}
+
+func (v Validation) Has(field string) bool {
+	return len(v[field]) != 0
+}
+
+func (v Validation) Valid() bool {
+	return len(v) == 0
+}
+
+// required adds an error for every blank field.
+func (v Validation) required(r *http.Request, fields ...string) {
+	for _, field := range fields {
+		if r.FormValue(field) == "" {
+			v.Add(field, "This field is required")
+		}
+	}
+}
+
+// validEmail accepts a bare address like "user@host", without a name.
+func validEmail(email string) bool {
+	addr, err := mail.ParseAddress(email)
+	return err == nil && addr.Address == email
+}
+
+// getFormData is getUserData with an empty Validation as ._Errors and
+// the submitted values of fields as ._Form. Never pass password fields.
+func (uh *Handler) getFormData(w http.ResponseWriter, r *http.Request, fields ...string) (map[string]interface{}, Validation) {
+	data := uh.getUserData(w, r)
+	v := Validation{}
+	form := map[string]string{}
+	for _, field := range fields {
+		form[field] = r.FormValue(field)
+	}
+	data["_Errors"] = v
+	data["_Form"] = form
+	return data, v
+}
@@ -0,0 +1,63 @@
+package baxtep
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestValidEmail(t *testing.T) {
+	for email, ok := range map[string]bool{
+		"user@example.com":          true,
+		"user.name+tag@example.com": true,
+		"":                          false,
+		"user":                      false,
+		"user@":                     false,
+		"User <user@example.com>":   false,
+		" user@example.com":         false,
+	} {
+		if validEmail(email) != ok {
+			t.Errorf("validEmail(%q) is %v", email, !ok)
+		}
+	}
+}
+
+func TestValidationErrors(t *testing.T) {
+	s := newTestServer(t, nil)
+	addTestUser(t, s.b, "taken", "taken@example.com")
+
+	for _, test := range []struct {
+		name   string
+		form   url.Values
+		errors []string
+	}{
+		{"empty form", url.Values{}, []string{"This field is required"}},
+		{"bad email", url.Values{"name": {"user"}, "email": {"user@"}, "password": {"secret"}, "retry-password": {"secret"}}, []string{"Invalid email address"}},
+		{"passwords don't match", url.Values{"name": {"user"}, "email": {"user@example.com"}, "password": {"secret"}, "retry-password": {"other"}}, []string{"Password does not match"}},
+		{"taken name", url.Values{"name": {"taken"}, "email": {"user@example.com"}, "password": {"secret"}, "retry-password": {"secret"}}, []string{"This name is already taken"}},
+		{"taken email", url.Values{"name": {"user"}, "email": {"taken@example.com"}, "password": {"secret"}, "retry-password": {"secret"}}, []string{"This email is already registered"}},
+	} {
+		resp, body := s.post("/user?registration", test.form)
+		if resp.StatusCode != http.StatusUnprocessableEntity {
+			t.Errorf("%s: %d", test.name, resp.StatusCode)
+		}
+		for _, e := range test.errors {
+			if !strings.Contains(body, `<span class="error">`+e+`</span>`) {
+				t.Errorf("%s: no %q in %s", test.name, e, body)
+			}
+		}
+		// entered values are kept, passwords are not
+		if email := test.form.Get("email"); email != "" && !strings.Contains(body, `value="`+email+`"`) {
+			t.Errorf("%s: email %q is not kept", test.name, email)
+		}
+		if strings.Contains(body, "secret") {
+			t.Errorf("%s: password is rendered", test.name)
+		}
+	}
+
+	resp, body := s.post("/user?login", url.Values{"email": {"taken@example.com"}, "password": {"wrong"}})
+	if resp.StatusCode != http.StatusForbidden || !strings.Contains(body, `<span class="error">Wrong email or password</span>`) || !strings.Contains(body, `value="taken@example.com"`) {
+		t.Errorf("wrong password: %d %s", resp.StatusCode, body)
+	}
+}
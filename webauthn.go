@@ -0,0 +1,713 @@
+package baxtep
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// WebAuthn is the relying party of passkey ceremonies. RPID is the domain
+// of the site, Origins are the allowed origins of its pages like
+// "https://example.com". Attestation statements are not verified, any
+// authenticator the browser accepts is accepted.
+type WebAuthn struct {
+	RPID    string
+	RPName  string
+	Origins []string
+	Timeout time.Duration
+}
+
+// COSE algorithms of supported public keys.
+const (
+	coseES256 = -7
+	coseEdDSA = -8
+	coseRS256 = -257
+)
+
+// authenticator data flags
+const (
+	flagUserPresent  = 0x01
+	flagUserVerified = 0x04
+	flagAttested     = 0x40
+)
+
+// Challenges are single-use tokens, of registration bound to the user.
+const (
+	tokenPasskeyRegister = "passkey-register"
+	tokenPasskeyLogin    = "passkey-login"
+	challengeDuration    = 5 * time.Minute
+)
+
+// Base64URL is binary data, base64url encoded in JSON as WebAuthn does.
+type Base64URL []byte
+
+func (b Base64URL) MarshalJSON() ([]byte, error) {
+	return json.Marshal(base64.RawURLEncoding.EncodeToString(b))
+}
+
+func (b *Base64URL) UnmarshalJSON(data []byte) error {
+	var s string
+	err := json.Unmarshal(data, &s)
+	if err != nil {
+		return err
+	}
+	*b, err = base64.RawURLEncoding.DecodeString(strings.TrimRight(s, "="))
+	return err
+}
+
+// PasskeyRegistration is the result of navigator.credentials.create()
+// in the PublicKeyCredential.toJSON() form.
+type PasskeyRegistration struct {
+	ID       string    `json:"id"`
+	RawID    Base64URL `json:"rawId"`
+	Type     string    `json:"type"`
+	Response struct {
+		ClientDataJSON    Base64URL `json:"clientDataJSON"`
+		AttestationObject Base64URL `json:"attestationObject"`
+	} `json:"response"`
+}
+
+// PasskeyAssertion is the result of navigator.credentials.get()
+// in the PublicKeyCredential.toJSON() form.
+type PasskeyAssertion struct {
+	ID       string    `json:"id"`
+	RawID    Base64URL `json:"rawId"`
+	Type     string    `json:"type"`
+	Response struct {
+		ClientDataJSON    Base64URL `json:"clientDataJSON"`
+		AuthenticatorData Base64URL `json:"authenticatorData"`
+		Signature         Base64URL `json:"signature"`
+		UserHandle        Base64URL `json:"userHandle"`
+	} `json:"response"`
+}
+
+// NewChallenge returns a random challenge for a ceremony.
+func NewChallenge() ([]byte, error) {
+	challenge := make([]byte, 32)
+	_, err := rand.Read(challenge)
+	return challenge, err
+}
+
+// PasskeyChallenge returns the challenge of CreationOptions for the user.
+// It works for one AddPasskey of the user within a few minutes.
+func (u *User) PasskeyChallenge() ([]byte, error) {
+	challenge, err := NewChallenge()
+	if err != nil {
+		return nil, err
+	}
+	_, err = u.b.newTokenValue(u.id, tokenPasskeyRegister, base64.RawURLEncoding.EncodeToString(challenge), "", challengeDuration)
+	return challenge, err
+}
+
+// PasskeyLoginChallenge returns the challenge of RequestOptions. It works
+// for one LoginPasskey within a few minutes.
+func (b *Baxtep) PasskeyLoginChallenge() ([]byte, error) {
+	challenge, err := NewChallenge()
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now().UTC()
+	err = b.store.DeleteExpiredTokens(tokenPasskeyLogin, now)
+	if err != nil {
+		return nil, err
+	}
+	err = b.store.AddToken(Token{
+		ID:      hashToken(base64.RawURLEncoding.EncodeToString(challenge)),
+		Kind:    tokenPasskeyLogin,
+		Expires: now.Add(challengeDuration),
+	})
+	return challenge, err
+}
+
+// takeChallenge uses up the challenge of the client data, it must be one
+// of PasskeyChallenge or PasskeyLoginChallenge by kind.
+func (b *Baxtep) takeChallenge(clientDataJSON []byte, kind string) ([]byte, Token, error) {
+	var clientData struct {
+		Challenge string `json:"challenge"`
+	}
+	err := json.Unmarshal(clientDataJSON, &clientData)
+	if err != nil {
+		return nil, Token{}, ErrUserBadPasskey
+	}
+	value := strings.TrimRight(clientData.Challenge, "=")
+	challenge, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil || len(challenge) == 0 {
+		return nil, Token{}, ErrUserBadPasskey
+	}
+	t, err := b.store.TakeToken(hashToken(value), kind)
+	if err == sql.ErrNoRows {
+		return nil, t, ErrUserBadPasskey
+	}
+	if err != nil {
+		return nil, t, err
+	}
+	if time.Now().UTC().After(t.Expires) {
+		return nil, t, ErrUserBadPasskey
+	}
+	return challenge, t, nil
+}
+
+// userHandle is the WebAuthn user.id of a user.
+func userHandle(id int64) []byte {
+	handle := make([]byte, 8)
+	binary.BigEndian.PutUint64(handle, uint64(id))
+	return handle
+}
+
+func (wa *WebAuthn) timeout() int64 {
+	if wa.Timeout == 0 {
+		return int64(5 * time.Minute / time.Millisecond)
+	}
+	return int64(wa.Timeout / time.Millisecond)
+}
+
+// CreationOptions returns the JSON form of the options for
+// navigator.credentials.create(), see
+// PublicKeyCredential.parseCreationOptionsFromJSON. Credentials in
+// exclude are not registered again on the same authenticator.
+func (wa *WebAuthn) CreationOptions(u User, challenge []byte, exclude []Credential) map[string]interface{} {
+	excludeCredentials := []map[string]interface{}{}
+	for _, c := range exclude {
+		excludeCredentials = append(excludeCredentials, map[string]interface{}{"type": "public-key", "id": c.ID})
+	}
+	return map[string]interface{}{
+		"rp":        map[string]interface{}{"id": wa.RPID, "name": wa.RPName},
+		"user":      map[string]interface{}{"id": Base64URL(userHandle(u.id)), "name": u.Email, "displayName": u.Name},
+		"challenge": Base64URL(challenge),
+		"pubKeyCredParams": []map[string]interface{}{
+			{"type": "public-key", "alg": coseES256},
+			{"type": "public-key", "alg": coseEdDSA},
+			{"type": "public-key", "alg": coseRS256},
+		},
+		"timeout":            wa.timeout(),
+		"excludeCredentials": excludeCredentials,
+		"authenticatorSelection": map[string]interface{}{
+			"residentKey":      "required",
+			"userVerification": "required",
+		},
+		"attestation": "none",
+	}
+}
+
+// RequestOptions returns the JSON form of the options for
+// navigator.credentials.get() with discoverable credentials, see
+// PublicKeyCredential.parseRequestOptionsFromJSON.
+func (wa *WebAuthn) RequestOptions(challenge []byte) map[string]interface{} {
+	return map[string]interface{}{
+		"challenge":        Base64URL(challenge),
+		"timeout":          wa.timeout(),
+		"rpId":             wa.RPID,
+		"userVerification": "required",
+	}
+}
+
+func (wa *WebAuthn) checkClientData(data []byte, ceremony string, challenge []byte) error {
+	var clientData struct {
+		Type        string `json:"type"`
+		Challenge   string `json:"challenge"`
+		Origin      string `json:"origin"`
+		CrossOrigin bool   `json:"crossOrigin"`
+	}
+	err := json.Unmarshal(data, &clientData)
+	if err != nil || clientData.Type != ceremony || clientData.CrossOrigin {
+		return ErrUserBadPasskey
+	}
+	got, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(clientData.Challenge, "="))
+	if err != nil || len(challenge) == 0 || subtle.ConstantTimeCompare(got, challenge) != 1 {
+		return ErrUserBadPasskey
+	}
+	for _, origin := range wa.Origins {
+		if clientData.Origin == origin {
+			return nil
+		}
+	}
+	return ErrUserBadPasskey
+}
+
+type authenticatorData struct {
+	rpIDHash     []byte
+	flags        byte
+	signCount    uint32
+	credentialID []byte
+	publicKey    []byte // COSE key
+}
+
+func parseAuthenticatorData(data []byte) (authenticatorData, error) {
+	var ad authenticatorData
+	if len(data) < 37 {
+		return ad, ErrUserBadPasskey
+	}
+	ad.rpIDHash = data[:32]
+	ad.flags = data[32]
+	ad.signCount = binary.BigEndian.Uint32(data[33:37])
+	if ad.flags&flagAttested == 0 {
+		return ad, nil
+	}
+	// attested credential data: AAGUID, ID length, ID and the COSE key
+	rest := data[37:]
+	if len(rest) < 18 {
+		return ad, ErrUserBadPasskey
+	}
+	idLen := int(binary.BigEndian.Uint16(rest[16:18]))
+	rest = rest[18:]
+	if len(rest) < idLen {
+		return ad, ErrUserBadPasskey
+	}
+	ad.credentialID = rest[:idLen]
+	rest = rest[idLen:]
+	_, after, err := cborDecode(rest)
+	if err != nil {
+		return ad, ErrUserBadPasskey
+	}
+	ad.publicKey = rest[:len(rest)-len(after)]
+	return ad, nil
+}
+
+// checkAuthenticatorData checks the RP ID and that the user was verified
+// by a PIN or biometrics, not only present: a passkey stands for both
+// the password and 2FA.
+func (wa *WebAuthn) checkAuthenticatorData(ad authenticatorData) error {
+	rpIDHash := sha256.Sum256([]byte(wa.RPID))
+	if !bytes.Equal(ad.rpIDHash, rpIDHash[:]) || ad.flags&flagUserPresent == 0 || ad.flags&flagUserVerified == 0 {
+		return ErrUserBadPasskey
+	}
+	return nil
+}
+
+// parseCOSEKey returns the public key and its COSE algorithm.
+func parseCOSEKey(data []byte) (crypto.PublicKey, int64, error) {
+	item, _, err := cborDecode(data)
+	if err != nil {
+		return nil, 0, ErrUserBadPasskey
+	}
+	m, ok := item.(map[interface{}]interface{})
+	if !ok {
+		return nil, 0, ErrUserBadPasskey
+	}
+	kty, _ := m[int64(1)].(int64)
+	alg, _ := m[int64(3)].(int64)
+	crv, _ := m[int64(-1)].(int64)
+	x, _ := m[int64(-2)].([]byte)
+	switch {
+	case kty == 2 && alg == coseES256 && crv == 1:
+		y, _ := m[int64(-3)].([]byte)
+		if len(x) != 32 || len(y) != 32 {
+			return nil, 0, ErrUserBadPasskey
+		}
+		key := &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		if !key.Curve.IsOnCurve(key.X, key.Y) {
+			return nil, 0, ErrUserBadPasskey
+		}
+		return key, alg, nil
+	case kty == 1 && alg == coseEdDSA && crv == 6:
+		if len(x) != ed25519.PublicKeySize {
+			return nil, 0, ErrUserBadPasskey
+		}
+		return ed25519.PublicKey(x), alg, nil
+	case kty == 3 && alg == coseRS256:
+		// RSA keys have n and e under the labels of crv and x
+		n, _ := m[int64(-1)].([]byte)
+		e, _ := m[int64(-2)].([]byte)
+		if len(n) < 256 || len(e) == 0 || len(e) > 4 {
+			return nil, 0, ErrUserBadPasskey
+		}
+		exponent := new(big.Int).SetBytes(e)
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(exponent.Int64())}, alg, nil
+	}
+	return nil, 0, ErrUserBadPasskey
+}
+
+func verifyCOSESignature(coseKey, signed, signature []byte) error {
+	key, alg, err := parseCOSEKey(coseKey)
+	if err != nil {
+		return err
+	}
+	hash := sha256.Sum256(signed)
+	ok := false
+	switch alg {
+	case coseES256:
+		ok = ecdsa.VerifyASN1(key.(*ecdsa.PublicKey), hash[:], signature)
+	case coseEdDSA:
+		ok = ed25519.Verify(key.(ed25519.PublicKey), signed, signature)
+	case coseRS256:
+		ok = rsa.VerifyPKCS1v15(key.(*rsa.PublicKey), crypto.SHA256, hash[:], signature) == nil
+	}
+	if !ok {
+		return ErrUserBadPasskey
+	}
+	return nil
+}
+
+// AddPasskey verifies the result of navigator.credentials.create() with
+// CreationOptions of a PasskeyChallenge of the user and keeps the new
+// credential.
+func (u *User) AddPasskey(wa *WebAuthn, name string, reg PasskeyRegistration) (Credential, error) {
+	challenge, t, err := u.b.takeChallenge(reg.Response.ClientDataJSON, tokenPasskeyRegister)
+	if err != nil {
+		return Credential{}, err
+	}
+	if t.UserID != u.id {
+		return Credential{}, ErrUserBadPasskey
+	}
+	err = wa.checkClientData(reg.Response.ClientDataJSON, "webauthn.create", challenge)
+	if err != nil {
+		return Credential{}, err
+	}
+	item, _, err := cborDecode(reg.Response.AttestationObject)
+	if err != nil {
+		return Credential{}, ErrUserBadPasskey
+	}
+	attestation, _ := item.(map[interface{}]interface{})
+	authData, _ := attestation["authData"].([]byte)
+	ad, err := parseAuthenticatorData(authData)
+	if err != nil {
+		return Credential{}, err
+	}
+	err = wa.checkAuthenticatorData(ad)
+	if err != nil {
+		return Credential{}, err
+	}
+	if len(ad.credentialID) == 0 {
+		return Credential{}, ErrUserBadPasskey
+	}
+	_, _, err = parseCOSEKey(ad.publicKey)
+	if err != nil {
+		return Credential{}, err
+	}
+	id := base64.RawURLEncoding.EncodeToString(ad.credentialID)
+	if len(id) > 255 {
+		return Credential{}, ErrUserBadPasskey
+	}
+	_, err = u.b.store.GetCredential(id)
+	if err == nil {
+		return Credential{}, ErrUserBadPasskey
+	}
+	if err != sql.ErrNoRows {
+		return Credential{}, err
+	}
+	now := time.Now().UTC()
+	c := Credential{
+		ID:        id,
+		UserID:    u.id,
+		Name:      passkeyName(name),
+		PublicKey: base64.StdEncoding.EncodeToString(ad.publicKey),
+		SignCount: int64(ad.signCount),
+		Created:   now,
+		LastUsed:  now,
+	}
+	return c, u.b.store.AddCredential(c)
+}
+
+// LoginPasskey verifies the result of navigator.credentials.get() with
+// RequestOptions of a PasskeyLoginChallenge and returns the owner of the
+// passkey. Passkeys replace both the password and 2FA.
+func (b *Baxtep) LoginPasskey(wa *WebAuthn, a PasskeyAssertion) (User, error) {
+	// synced passkeys keep the sign count at 0, only the single-use
+	// challenge stops a replayed assertion
+	challenge, _, err := b.takeChallenge(a.Response.ClientDataJSON, tokenPasskeyLogin)
+	if err != nil {
+		return User{b: b}, err
+	}
+	id := base64.RawURLEncoding.EncodeToString(a.RawID)
+	if len(a.RawID) == 0 {
+		id = a.ID
+	}
+	c, err := b.store.GetCredential(id)
+	if err == sql.ErrNoRows {
+		return User{b: b}, ErrUserPasskeyNotFound
+	}
+	if err != nil {
+		return User{b: b}, err
+	}
+	err = wa.checkClientData(a.Response.ClientDataJSON, "webauthn.get", challenge)
+	if err != nil {
+		return User{b: b}, err
+	}
+	ad, err := parseAuthenticatorData(a.Response.AuthenticatorData)
+	if err != nil {
+		return User{b: b}, err
+	}
+	err = wa.checkAuthenticatorData(ad)
+	if err != nil {
+		return User{b: b}, err
+	}
+	if len(a.Response.UserHandle) != 0 && !bytes.Equal(a.Response.UserHandle, userHandle(c.UserID)) {
+		return User{b: b}, ErrUserBadPasskey
+	}
+	coseKey, err := base64.StdEncoding.DecodeString(c.PublicKey)
+	if err != nil {
+		return User{b: b}, err
+	}
+	clientDataHash := sha256.Sum256(a.Response.ClientDataJSON)
+	signed := append(append([]byte(nil), a.Response.AuthenticatorData...), clientDataHash[:]...)
+	err = verifyCOSESignature(coseKey, signed, a.Response.Signature)
+	if err != nil {
+		return User{b: b}, err
+	}
+	// a counter that does not grow means a cloned authenticator
+	if (ad.signCount != 0 || c.SignCount != 0) && int64(ad.signCount) <= c.SignCount {
+		return User{b: b}, ErrUserBadPasskey
+	}
+	err = b.store.UseCredential(c.ID, int64(ad.signCount), time.Now().UTC())
+	if err != nil {
+		return User{b: b}, err
+	}
+	return b.GetUserByID(c.UserID)
+}
+
+// passkeyName is name fit for the store, "Passkey" if it is empty.
+func passkeyName(name string) string {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return "Passkey"
+	}
+	if r := []rune(name); len(r) > 100 {
+		return string(r[:100])
+	}
+	return name
+}
+
+// Passkeys returns the passkeys of the user, oldest first.
+func (b *Baxtep) Passkeys(userID int64) ([]Credential, error) {
+	return b.store.GetCredentials(userID)
+}
+
+// passkey returns ErrUserPasskeyNotFound if the user has no passkey id.
+func (b *Baxtep) passkey(userID int64, id string) error {
+	c, err := b.store.GetCredential(id)
+	if err == sql.ErrNoRows || err == nil && c.UserID != userID {
+		return ErrUserPasskeyNotFound
+	}
+	return err
+}
+
+// RenamePasskey sets the name of a passkey of the user.
+func (b *Baxtep) RenamePasskey(userID int64, id, name string) error {
+	err := b.passkey(userID, id)
+	if err != nil {
+		return err
+	}
+	return b.store.RenameCredential(userID, id, passkeyName(name))
+}
+
+// RemovePasskey deletes a passkey, it no longer logs the user in.
+func (b *Baxtep) RemovePasskey(userID int64, id string) error {
+	err := b.passkey(userID, id)
+	if err != nil {
+		return err
+	}
+	return b.store.DeleteCredential(userID, id)
+}
+
+func (u *User) Passkeys() ([]Credential, error) {
+	return u.b.Passkeys(u.id)
+}
+
+func (u *User) RenamePasskey(id, name string) error {
+	return u.b.RenamePasskey(u.id, id, name)
+}
+
+func (u *User) RemovePasskey(id string) error {
+	return u.b.RemovePasskey(u.id, id)
+}
+
+// webAuthn returns HandlerConfig.WebAuthn or the relying party of
+// BaseURL, or of the request without it.
+func (uh *Handler) webAuthn(r *http.Request) *WebAuthn {
+	if uh.Config.WebAuthn != nil {
+		return uh.Config.WebAuthn
+	}
+	origin := uh.Config.BaseURL
+	if origin == "" {
+		origin = "http://" + r.Host
+		if r.TLS != nil {
+			origin = "https://" + r.Host
+		}
+	}
+	u, err := url.Parse(origin)
+	if err != nil {
+		return &WebAuthn{}
+	}
+	name := uh.Config.TOTPIssuer
+	if name == "" {
+		name = u.Hostname()
+	}
+	return &WebAuthn{RPID: u.Hostname(), RPName: name, Origins: []string{u.Scheme + "://" + u.Host}}
+}
+
+type passkeyJSON struct {
+	ID       string    `json:"id"`
+	Name     string    `json:"name"`
+	Created  time.Time `json:"created"`
+	LastUsed time.Time `json:"lastUsed"`
+}
+
+// passkey serves the JSON endpoints of passkeys:
+//
+//	GET  ?passkey                   passkeys of the user
+//	POST ?passkey=register-options  options for navigator.credentials.create()
+//	POST ?passkey=register          its result and "name"
+//	POST ?passkey=login-options     options for navigator.credentials.get()
+//	POST ?passkey=login             its result, logs in, "redirect" honours
+//	                                a next param in the query
+//	POST ?passkey=rename            "id" and "name"
+//	POST ?passkey=remove            "id"
+//
+// POST requests need the X-CSRF-Token header.
+func (uh *Handler) passkey(w http.ResponseWriter, r *http.Request) {
+	action := r.URL.Query().Get("passkey")
+	if action == "login-options" || action == "login" {
+		uh.passkeyLogin(w, r, action)
+		return
+	}
+	ctx := uh.check(w, r)
+	user, ok := UserFromContext(ctx)
+	if !ok {
+		uh.jsonError(w, http.StatusUnauthorized, "login required")
+		return
+	}
+	if action != "" && r.Method != "POST" {
+		uh.jsonError(w, http.StatusMethodNotAllowed, "POST required")
+		return
+	}
+	var req struct {
+		PasskeyRegistration
+		Name string `json:"name"`
+	}
+	if action != "" && action != "register-options" {
+		err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 64<<10)).Decode(&req)
+		if err != nil {
+			uh.jsonError(w, http.StatusBadRequest, "bad JSON")
+			return
+		}
+	}
+	switch action {
+	case "":
+	case "register-options":
+		credentials, err := user.Passkeys()
+		if err != nil {
+			uh.logPrintf("Passkey Passkeys error: %s", err)
+			uh.jsonError(w, http.StatusInternalServerError, "internal error")
+			return
+		}
+		challenge, err := user.PasskeyChallenge()
+		if err != nil {
+			uh.logPrintf("Passkey challenge error: %s", err)
+			uh.jsonError(w, http.StatusInternalServerError, "internal error")
+			return
+		}
+		uh.writeJSON(w, http.StatusOK, uh.webAuthn(r).CreationOptions(user, challenge, credentials))
+		return
+	case "register":
+		c, err := user.AddPasskey(uh.webAuthn(r), req.Name, req.PasskeyRegistration)
+		if err == ErrUserBadPasskey {
+			uh.jsonError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		if err != nil {
+			uh.logPrintf("Passkey AddPasskey error: %s", err)
+			uh.jsonError(w, http.StatusInternalServerError, "internal error")
+			return
+		}
+		uh.writeJSON(w, http.StatusCreated, passkeyJSON{c.ID, c.Name, c.Created, c.LastUsed})
+		return
+	case "rename":
+		err := user.RenamePasskey(req.ID, req.Name)
+		if err == ErrUserPasskeyNotFound {
+			uh.jsonError(w, http.StatusNotFound, err.Error())
+			return
+		}
+		if err != nil {
+			uh.logPrintf("Passkey RenamePasskey error: %s", err)
+			uh.jsonError(w, http.StatusInternalServerError, "internal error")
+			return
+		}
+	case "remove":
+		err := user.RemovePasskey(req.ID)
+		if err == ErrUserPasskeyNotFound {
+			uh.jsonError(w, http.StatusNotFound, err.Error())
+			return
+		}
+		if err != nil {
+			uh.logPrintf("Passkey RemovePasskey error: %s", err)
+			uh.jsonError(w, http.StatusInternalServerError, "internal error")
+			return
+		}
+	default:
+		uh.jsonError(w, http.StatusNotFound, "unknown action")
+		return
+	}
+	credentials, err := user.Passkeys()
+	if err != nil {
+		uh.logPrintf("Passkey Passkeys error: %s", err)
+		uh.jsonError(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+	list := []passkeyJSON{}
+	for _, c := range credentials {
+		list = append(list, passkeyJSON{c.ID, c.Name, c.Created, c.LastUsed})
+	}
+	uh.writeJSON(w, http.StatusOK, list)
+}
+
+func (uh *Handler) passkeyLogin(w http.ResponseWriter, r *http.Request, action string) {
+	if r.Method != "POST" {
+		uh.jsonError(w, http.StatusMethodNotAllowed, "POST required")
+		return
+	}
+	if uh.Config.IPLimiter != nil && !uh.Config.IPLimiter.Allow("ip:"+uh.clientIP(r)) {
+		uh.jsonError(w, http.StatusTooManyRequests, "too many login attempts, try again later")
+		return
+	}
+	if action == "login-options" {
+		challenge, err := uh.Config.Baxter.PasskeyLoginChallenge()
+		if err != nil {
+			uh.logPrintf("Passkey challenge error: %s", err)
+			uh.jsonError(w, http.StatusInternalServerError, "internal error")
+			return
+		}
+		uh.writeJSON(w, http.StatusOK, uh.webAuthn(r).RequestOptions(challenge))
+		return
+	}
+	var a PasskeyAssertion
+	err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 64<<10)).Decode(&a)
+	if err != nil {
+		uh.jsonError(w, http.StatusBadRequest, "bad JSON")
+		return
+	}
+	u, err := uh.Config.Baxter.LoginPasskey(uh.webAuthn(r), a)
+	switch {
+	case err == ErrUserBadPasskey || err == ErrUserPasskeyNotFound:
+		uh.jsonError(w, http.StatusUnauthorized, err.Error())
+		return
+	case err != nil:
+		uh.logPrintf("Passkey LoginPasskey error: %s", err)
+		uh.jsonError(w, http.StatusInternalServerError, "internal error")
+		return
+	case !u.Enable:
+		uh.jsonError(w, http.StatusForbidden, ErrUserDisabled.Error())
+		return
+	}
+	err = uh.startSession(w, r, u)
+	if err != nil {
+		uh.logPrintf("Passkey SetNewSession error: %s", err)
+		uh.jsonError(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+	uh.writeJSON(w, http.StatusOK, map[string]string{"redirect": uh.afterLogin(r)})
+}
@@ -0,0 +1,425 @@
+package baxtep
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+// memStore keeps users, tokens and credentials in memory, the Store
+// methods the tests don't use are nil and panic.
+type memStore struct {
+	Store
+	users       map[int64]UserData
+	tokens      map[string]Token
+	credentials map[string]Credential
+}
+
+func newMemStore(users ...UserData) *memStore {
+	s := &memStore{users: map[int64]UserData{}, tokens: map[string]Token{}, credentials: map[string]Credential{}}
+	for _, u := range users {
+		s.users[u.ID] = u
+	}
+	return s
+}
+
+func (s *memStore) GetUserByID(id int64) (UserData, error) {
+	u, ok := s.users[id]
+	if !ok {
+		return u, sql.ErrNoRows
+	}
+	return u, nil
+}
+
+func (s *memStore) AddToken(t Token) error {
+	s.tokens[t.ID] = t
+	return nil
+}
+
+func (s *memStore) TakeToken(id, kind string) (Token, error) {
+	t, ok := s.tokens[id]
+	if !ok || t.Kind != kind {
+		return Token{}, sql.ErrNoRows
+	}
+	delete(s.tokens, id)
+	return t, nil
+}
+
+func (s *memStore) DeleteTokens(userID int64, kind string) error {
+	for id, t := range s.tokens {
+		if t.UserID == userID && t.Kind == kind {
+			delete(s.tokens, id)
+		}
+	}
+	return nil
+}
+
+func (s *memStore) DeleteExpiredTokens(kind string, now time.Time) error {
+	for id, t := range s.tokens {
+		if t.Kind == kind && t.Expires.Before(now) {
+			delete(s.tokens, id)
+		}
+	}
+	return nil
+}
+
+func (s *memStore) AddCredential(c Credential) error {
+	s.credentials[c.ID] = c
+	return nil
+}
+
+func (s *memStore) GetCredential(id string) (Credential, error) {
+	c, ok := s.credentials[id]
+	if !ok {
+		return c, sql.ErrNoRows
+	}
+	return c, nil
+}
+
+func (s *memStore) GetCredentials(userID int64) ([]Credential, error) {
+	var credentials []Credential
+	for _, c := range s.credentials {
+		if c.UserID == userID {
+			credentials = append(credentials, c)
+		}
+	}
+	return credentials, nil
+}
+
+func (s *memStore) UseCredential(id string, signCount int64, used time.Time) error {
+	c := s.credentials[id]
+	c.SignCount, c.LastUsed = signCount, used
+	s.credentials[id] = c
+	return nil
+}
+
+func (s *memStore) RenameCredential(userID int64, id, name string) error {
+	if c, ok := s.credentials[id]; ok && c.UserID == userID {
+		c.Name = name
+		s.credentials[id] = c
+	}
+	return nil
+}
+
+func (s *memStore) DeleteCredential(userID int64, id string) error {
+	if c, ok := s.credentials[id]; ok && c.UserID == userID {
+		delete(s.credentials, id)
+	}
+	return nil
+}
+
+// cborEncode encodes the few types the tests need.
+func cborEncode(v interface{}) []byte {
+	head := func(major byte, n uint64) []byte {
+		switch {
+		case n < 24:
+			return []byte{major<<5 | byte(n)}
+		case n < 1<<8:
+			return []byte{major<<5 | 24, byte(n)}
+		case n < 1<<16:
+			return binary.BigEndian.AppendUint16([]byte{major<<5 | 25}, uint16(n))
+		}
+		return binary.BigEndian.AppendUint32([]byte{major<<5 | 26}, uint32(n))
+	}
+	switch v := v.(type) {
+	case int:
+		if v < 0 {
+			return head(1, uint64(-1-v))
+		}
+		return head(0, uint64(v))
+	case []byte:
+		return append(head(2, uint64(len(v))), v...)
+	case string:
+		return append(head(3, uint64(len(v))), v...)
+	case map[interface{}]interface{}:
+		data := head(5, uint64(len(v)))
+		for key, value := range v {
+			data = append(data, cborEncode(key)...)
+			data = append(data, cborEncode(value)...)
+		}
+		return data
+	}
+	panic("cborEncode: unsupported type")
+}
+
+// softAuthenticator is an ES256 authenticator in software. A synced one
+// keeps the sign count at 0 like passkeys of password managers do.
+type softAuthenticator struct {
+	key    *ecdsa.PrivateKey
+	id     []byte
+	count  uint32
+	synced bool
+}
+
+func newSoftAuthenticator(t *testing.T) *softAuthenticator {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &softAuthenticator{key: key, id: []byte("credential-1")}
+}
+
+func (a *softAuthenticator) authData(rpID string, flags byte, attested bool) []byte {
+	rpIDHash := sha256.Sum256([]byte(rpID))
+	data := append([]byte(nil), rpIDHash[:]...)
+	if attested {
+		flags |= flagAttested
+	}
+	data = append(data, flags)
+	data = binary.BigEndian.AppendUint32(data, a.count)
+	if !attested {
+		return data
+	}
+	data = append(data, make([]byte, 16)...) // AAGUID
+	data = binary.BigEndian.AppendUint16(data, uint16(len(a.id)))
+	data = append(data, a.id...)
+	return append(data, cborEncode(map[interface{}]interface{}{
+		1:  2,
+		3:  coseES256,
+		-1: 1,
+		-2: a.key.PublicKey.X.FillBytes(make([]byte, 32)),
+		-3: a.key.PublicKey.Y.FillBytes(make([]byte, 32)),
+	})...)
+}
+
+func clientData(ceremony string, challenge []byte, origin string) []byte {
+	data, _ := json.Marshal(map[string]string{
+		"type":      ceremony,
+		"challenge": base64.RawURLEncoding.EncodeToString(challenge),
+		"origin":    origin,
+	})
+	return data
+}
+
+func (a *softAuthenticator) register(rpID string, challenge []byte, origin string) PasskeyRegistration {
+	var reg PasskeyRegistration
+	reg.ID = base64.RawURLEncoding.EncodeToString(a.id)
+	reg.RawID = a.id
+	reg.Type = "public-key"
+	reg.Response.ClientDataJSON = clientData("webauthn.create", challenge, origin)
+	reg.Response.AttestationObject = cborEncode(map[interface{}]interface{}{
+		"fmt":      "none",
+		"attStmt":  map[interface{}]interface{}{},
+		"authData": a.authData(rpID, flagUserPresent|flagUserVerified, true),
+	})
+	return reg
+}
+
+func (a *softAuthenticator) assert(t *testing.T, rpID string, challenge []byte, origin string, flags byte) PasskeyAssertion {
+	if !a.synced {
+		a.count++
+	}
+	var as PasskeyAssertion
+	as.ID = base64.RawURLEncoding.EncodeToString(a.id)
+	as.RawID = a.id
+	as.Type = "public-key"
+	as.Response.ClientDataJSON = clientData("webauthn.get", challenge, origin)
+	as.Response.AuthenticatorData = a.authData(rpID, flags, false)
+	clientDataHash := sha256.Sum256(as.Response.ClientDataJSON)
+	hash := sha256.Sum256(append(append([]byte(nil), as.Response.AuthenticatorData...), clientDataHash[:]...))
+	signature, err := ecdsa.SignASN1(rand.Reader, a.key, hash[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	as.Response.Signature = signature
+	as.Response.UserHandle = userHandle(1)
+	return as
+}
+
+func TestPasskey(t *testing.T) {
+	const (
+		rpID   = "example.com"
+		origin = "https://example.com"
+		uv     = flagUserPresent | flagUserVerified
+	)
+	b := NewBaxtep(newMemStore(
+		UserData{ID: 1, Name: "user", Email: "user@example.com", Enable: true},
+		UserData{ID: 2, Name: "other", Email: "other@example.com", Enable: true},
+	))
+	wa := &WebAuthn{RPID: rpID, Origins: []string{origin}}
+	u, err := b.GetUserByID(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, _ := b.GetUserByID(2)
+	challenge := func(u User) []byte {
+		c, err := u.PasskeyChallenge()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return c
+	}
+	loginChallenge := func() []byte {
+		c, err := b.PasskeyLoginChallenge()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return c
+	}
+	a := newSoftAuthenticator(t)
+
+	for name, reg := range map[string]func() PasskeyRegistration{
+		"wrong origin":         func() PasskeyRegistration { return a.register(rpID, challenge(u), "https://evil.com") },
+		"wrong RP ID":          func() PasskeyRegistration { return a.register("evil.com", challenge(u), origin) },
+		"not issued challenge": func() PasskeyRegistration { return a.register(rpID, []byte("other challenge"), origin) },
+		"login challenge":      func() PasskeyRegistration { return a.register(rpID, loginChallenge(), origin) },
+		"other's challenge":    func() PasskeyRegistration { return a.register(rpID, challenge(other), origin) },
+	} {
+		if _, err := u.AddPasskey(wa, "", reg()); err != ErrUserBadPasskey {
+			t.Errorf("registration with %s: %v", name, err)
+		}
+	}
+	c, err := u.AddPasskey(wa, "", a.register(rpID, challenge(u), origin))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Name != "Passkey" {
+		t.Errorf("default name %q", c.Name)
+	}
+	if _, err := u.AddPasskey(wa, "", a.register(rpID, challenge(u), origin)); err != ErrUserBadPasskey {
+		t.Errorf("registration of the same credential: %v", err)
+	}
+	reused := challenge(u)
+	second := newSoftAuthenticator(t)
+	second.id = []byte("credential-2")
+	if _, err := u.AddPasskey(wa, "", second.register(rpID, reused, origin)); err != nil {
+		t.Fatal(err)
+	}
+	third := newSoftAuthenticator(t)
+	third.id = []byte("credential-3")
+	if _, err := u.AddPasskey(wa, "", third.register(rpID, reused, origin)); err != ErrUserBadPasskey {
+		t.Errorf("registration with a reused challenge: %v", err)
+	}
+	b.RemovePasskey(1, base64.RawURLEncoding.EncodeToString(second.id))
+
+	for name, as := range map[string]func() PasskeyAssertion{
+		"wrong origin":           func() PasskeyAssertion { return a.assert(t, rpID, loginChallenge(), "https://evil.com", uv) },
+		"wrong RP ID":            func() PasskeyAssertion { return a.assert(t, "evil.com", loginChallenge(), origin, uv) },
+		"not issued challenge":   func() PasskeyAssertion { return a.assert(t, rpID, []byte("other challenge"), origin, uv) },
+		"registration challenge": func() PasskeyAssertion { return a.assert(t, rpID, challenge(u), origin, uv) },
+		"no user verification":   func() PasskeyAssertion { return a.assert(t, rpID, loginChallenge(), origin, flagUserPresent) },
+	} {
+		if _, err := b.LoginPasskey(wa, as()); err != ErrUserBadPasskey {
+			t.Errorf("login with %s: %v", name, err)
+		}
+	}
+	as := a.assert(t, rpID, loginChallenge(), origin, uv)
+	logged, err := b.LoginPasskey(wa, as)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if logged.GetID() != 1 {
+		t.Errorf("logged in user %d", logged.GetID())
+	}
+	if _, err := b.LoginPasskey(wa, as); err != ErrUserBadPasskey {
+		t.Errorf("replayed assertion: %v", err)
+	}
+	a.count -= 2
+	if _, err := b.LoginPasskey(wa, a.assert(t, rpID, loginChallenge(), origin, uv)); err != ErrUserBadPasskey {
+		t.Errorf("non-increasing sign count: %v", err)
+	}
+	as = a.assert(t, rpID, loginChallenge(), origin, uv)
+	as.RawID = []byte("unknown")
+	if _, err := b.LoginPasskey(wa, as); err != ErrUserPasskeyNotFound {
+		t.Errorf("unknown credential: %v", err)
+	}
+
+	err = b.RenamePasskey(1, c.ID, strings.Repeat("я", 150))
+	if err != nil {
+		t.Fatal(err)
+	}
+	passkeys, _ := b.Passkeys(1)
+	if len(passkeys) != 1 || len([]rune(passkeys[0].Name)) != 100 {
+		t.Errorf("long name is not trimmed: %v", passkeys)
+	}
+	b.RenamePasskey(1, c.ID, " ")
+	passkeys, _ = b.Passkeys(1)
+	if passkeys[0].Name != "Passkey" {
+		t.Errorf("empty name %q", passkeys[0].Name)
+	}
+	for name, id := range map[string]string{"unknown": "unknown", "another user's": c.ID} {
+		userID := int64(1)
+		if id == c.ID {
+			userID = 2
+		}
+		if err := b.RenamePasskey(userID, id, "name"); err != ErrUserPasskeyNotFound {
+			t.Errorf("rename of %s passkey: %v", name, err)
+		}
+		if err := b.RemovePasskey(userID, id); err != ErrUserPasskeyNotFound {
+			t.Errorf("remove of %s passkey: %v", name, err)
+		}
+	}
+	if passkeys, _ = b.Passkeys(1); len(passkeys) != 1 || passkeys[0].Name != "Passkey" {
+		t.Errorf("passkey of another user is changed: %v", passkeys)
+	}
+	b.RemovePasskey(1, c.ID)
+	if passkeys, _ = b.Passkeys(1); len(passkeys) != 0 {
+		t.Errorf("passkey is not removed: %v", passkeys)
+	}
+}
+
+func TestPasskeyReplaySynced(t *testing.T) {
+	const origin = "https://example.com"
+	b := NewBaxtep(newMemStore(UserData{ID: 1, Name: "user", Email: "user@example.com", Enable: true}))
+	wa := &WebAuthn{RPID: "example.com", Origins: []string{origin}}
+	u, _ := b.GetUserByID(1)
+	a := newSoftAuthenticator(t)
+	a.synced = true
+	challenge, _ := u.PasskeyChallenge()
+	if _, err := u.AddPasskey(wa, "", a.register(wa.RPID, challenge, origin)); err != nil {
+		t.Fatal(err)
+	}
+	challenge, _ = b.PasskeyLoginChallenge()
+	as := a.assert(t, wa.RPID, challenge, origin, flagUserPresent|flagUserVerified)
+	if _, err := b.LoginPasskey(wa, as); err != nil {
+		t.Fatal(err)
+	}
+	// sign count 0 is not checked, the challenge must not work twice
+	for i := 0; i < 3; i++ {
+		if _, err := b.LoginPasskey(wa, as); err != ErrUserBadPasskey {
+			t.Fatalf("replay %d of a zero-counter assertion: %v", i+1, err)
+		}
+	}
+	fresh, _ := b.PasskeyLoginChallenge()
+	if _, err := b.LoginPasskey(wa, a.assert(t, wa.RPID, fresh, origin, flagUserPresent|flagUserVerified)); err != nil {
+		t.Errorf("login with a new challenge: %v", err)
+	}
+}
+
+func TestCBORDecode(t *testing.T) {
+	item, rest, err := cborDecode(append(cborEncode(map[interface{}]interface{}{1: []byte("x"), "k": -7}), 0xff))
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := item.(map[interface{}]interface{})
+	if !bytes.Equal(m[int64(1)].([]byte), []byte("x")) || m["k"] != int64(-7) || !bytes.Equal(rest, []byte{0xff}) {
+		t.Errorf("decoded %v, rest %x", item, rest)
+	}
+
+	for name, data := range map[string][]byte{
+		"empty":                 {},
+		"truncated argument":    {0x19, 0x01},
+		"truncated byte string": {0x42, 0x01},
+		"truncated map":         {0xa1, 0x01},
+		"indefinite length":     {0x5f, 0x41, 0x00, 0xff},
+		"bad map key":           {0xa1, 0x40, 0x00},
+		"oversized byte string": {0x5b, 0x00, 0x00, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00},
+		"oversized array":       {0x9b, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff},
+		"oversized map":         {0xba, 0xff, 0xff, 0xff, 0xff},
+		"negative int overflow": {0x3b, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff},
+		"too deep":              bytes.Repeat([]byte{0x81}, 100),
+	} {
+		if _, _, err := cborDecode(data); err != errCBOR {
+			t.Errorf("%s: %v", name, err)
+		}
+	}
+}